@@ -649,7 +649,7 @@ const ProtobufCMessageDescriptor istio__security__address__descriptor =
   (ProtobufCMessageInit) istio__security__address__init,
   NULL,NULL,NULL    /* reserved[123] */
 };
-static const ProtobufCFieldDescriptor istio__security__string_match__field_descriptors[3] =
+static const ProtobufCFieldDescriptor istio__security__string_match__field_descriptors[4] =
 {
   {
     "exact",
@@ -687,16 +687,29 @@ static const ProtobufCFieldDescriptor istio__security__string_match__field_descr
     0 | PROTOBUF_C_FIELD_FLAG_ONEOF,             /* flags */
     0,NULL,NULL    /* reserved1,reserved2, etc */
   },
+  {
+    "presence",
+    4,
+    PROTOBUF_C_LABEL_NONE,
+    PROTOBUF_C_TYPE_MESSAGE,
+    offsetof(Istio__Security__StringMatch, match_type_case),
+    offsetof(Istio__Security__StringMatch, presence),
+    &google__protobuf__empty__descriptor,
+    NULL,
+    0 | PROTOBUF_C_FIELD_FLAG_ONEOF,             /* flags */
+    0,NULL,NULL    /* reserved1,reserved2, etc */
+  },
 };
 static const unsigned istio__security__string_match__field_indices_by_name[] = {
   0,   /* field[0] = exact */
   1,   /* field[1] = prefix */
+  3,   /* field[3] = presence */
   2,   /* field[2] = suffix */
 };
 static const ProtobufCIntRange istio__security__string_match__number_ranges[1 + 1] =
 {
   { 1, 0 },
-  { 0, 3 }
+  { 0, 4 }
 };
 const ProtobufCMessageDescriptor istio__security__string_match__descriptor =
 {
@@ -706,7 +719,7 @@ const ProtobufCMessageDescriptor istio__security__string_match__descriptor =
   "Istio__Security__StringMatch",
   "istio.security",
   sizeof(Istio__Security__StringMatch),
-  3,
+  4,
   istio__security__string_match__field_descriptors,
   istio__security__string_match__field_indices_by_name,
   1,  istio__security__string_match__number_ranges,
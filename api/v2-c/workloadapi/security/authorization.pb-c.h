@@ -14,6 +14,7 @@ PROTOBUF_C__BEGIN_DECLS
 # error This file was generated by an older version of protoc-c which is incompatible with your libprotobuf-c headers. Please regenerate this file with a newer version of protoc-c.
 #endif
 
+#include "google/protobuf/empty.pb-c.h"
 
 typedef struct Istio__Security__Authorization Istio__Security__Authorization;
 typedef struct Istio__Security__Rule Istio__Security__Rule;
@@ -162,7 +163,8 @@ typedef enum {
   ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE__NOT_SET = 0,
   ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE_EXACT = 1,
   ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE_PREFIX = 2,
-  ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE_SUFFIX = 3
+  ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE_SUFFIX = 3,
+  ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE_PRESENCE = 4
     PROTOBUF_C__FORCE_ENUM_TO_BE_INT_SIZE(ISTIO__SECURITY__STRING_MATCH__MATCH_TYPE__CASE)
 } Istio__Security__StringMatch__MatchTypeCase;
 
@@ -183,6 +185,10 @@ struct  Istio__Security__StringMatch
      * suffix-based match
      */
     char *suffix;
+    /*
+     * presence match
+     */
+    Google__Protobuf__Empty *presence;
   };
 };
 #define ISTIO__SECURITY__STRING_MATCH__INIT \
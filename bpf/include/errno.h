@@ -28,4 +28,8 @@
 #define ENOSPC 28 /* No space left on device */
 #endif
 
+#ifndef EAFNOSUPPORT
+#define EAFNOSUPPORT 97 /* Address family not supported by protocol */
+#endif
+
 #endif // _ERRNO_H_
\ No newline at end of file
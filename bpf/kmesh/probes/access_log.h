@@ -30,17 +30,19 @@ struct {
     __uint(max_entries, RINGBUF_SIZE);
 } map_of_access_log SEC(".maps");
 
+// the addresses and ports of the tuple are big endian, src is the local end of the socket
 static inline void constuct_tuple(struct bpf_sock *sk, struct bpf_sock_tuple *tuple)
 {
+    // src_port is host byteorder, dst_port is network byteorder
     if (sk->family == AF_INET) {
         tuple->ipv4.saddr = sk->src_ip4;
         tuple->ipv4.daddr = sk->dst_ip4;
-        tuple->ipv4.sport = sk->src_port;
+        tuple->ipv4.sport = bpf_htons(sk->src_port);
         tuple->ipv4.dport = sk->dst_port;
     } else {
         bpf_memcpy(tuple->ipv6.saddr, sk->src_ip6, IPV6_ADDR_LEN);
         bpf_memcpy(tuple->ipv6.daddr, sk->dst_ip6, IPV6_ADDR_LEN);
-        tuple->ipv6.sport = sk->src_port;
+        tuple->ipv6.sport = bpf_htons(sk->src_port);
         tuple->ipv6.dport = sk->dst_port;
     }
     return;
@@ -59,10 +61,12 @@ report_access_log(struct bpf_sock *sk, struct bpf_tcp_sock *tcp_sock, struct soc
     }
 
     constuct_tuple(sk, &log->tuple);
+    log->family = sk->family;
+    log->protocol = sk->protocol;
     log->direction = storage->direction;
     log->close_ns = bpf_ktime_get_ns();
     log->duration = log->close_ns - storage->connect_ns;
-    log->sent_bytes = tcp_sock->delivered;
+    log->sent_bytes = tcp_sock->bytes_acked;
     log->received_bytes = tcp_sock->bytes_received;
     log->conn_success = storage->connect_success;
 
@@ -13,22 +13,45 @@ struct metric_key {
     __u32 dst_port;
 };
 
+// the buckets are coarse to keep the entries of map_of_metrics small
+#define METRIC_LATENCY_BUCKETS 6
+
+// a histogram of latencies, the last bucket counts the values above all the bounds
+struct latency_hist {
+    __u64 buckets[METRIC_LATENCY_BUCKETS];
+    __u64 sum_ns;
+};
+
+// the upper bounds of the buckets in ns, kmesh daemon exports the histograms with the same bounds
+static const __u64 connect_latency_bounds[METRIC_LATENCY_BUCKETS - 1] = {
+    1000000, 5000000, 25000000, 100000000, 1000000000};
+static const __u64 conn_duration_bounds[METRIC_LATENCY_BUCKETS - 1] = {
+    100000000, 1000000000, 10000000000, 60000000000, 600000000000};
+
+// the counters are cumulative since the entry is created, kmesh daemon reports the increments
+// between its reads
 struct metric_data {
-    __u32 direction;      // update on connect
-    __u32 conn_open;      // update on connect
-    __u32 conn_close;     // update on close
-    __u32 conn_failed;    // update on close
-    __u32 sent_bytes;     // update on close
-    __u32 received_bytes; // update on close
+    __u32 direction;                     // update on connect
+    __u32 pad;                           // align the counters
+    __u64 conn_open;                     // update on connect
+    __u64 conn_close;                    // update on close
+    __u64 conn_failed;                   // update on close
+    __u64 sent_bytes;                    // update on close
+    __u64 received_bytes;                // update on close
+    struct latency_hist connect_latency; // update on connect, outbound only
+    struct latency_hist duration;        // update on close
 };
 
-#define MAP_SIZE_OF_METRICS 100000
+// an LRU map is always preallocated, each entry takes about 250 bytes with the kernel element
+// header, so the map takes about 2.5MB
+#define MAP_SIZE_OF_METRICS 10000
+// the least recently used entries are evicted when the map is full, an evicted entry starts over
+// from zero the next time it is updated
 struct {
-    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
     __type(key, struct metric_key);
     __type(value, struct metric_data);
     __uint(max_entries, MAP_SIZE_OF_METRICS);
-    __uint(map_flags, BPF_F_NO_PREALLOC);
 } map_of_metrics SEC(".maps");
 
 struct {
@@ -49,7 +72,8 @@ static inline void construct_metric_key(struct bpf_sock *sk, __u8 direction, str
             bpf_memcpy(key->src_ip.ip6, sk->src_ip6, IPV6_ADDR_LEN);
             bpf_memcpy(key->dst_ip.ip6, sk->dst_ip6, IPV6_ADDR_LEN);
         }
-        key->dst_port = bpf_ntohl(sk->dst_port);
+        // dst_port is network byteorder, src_port is host byteorder
+        key->dst_port = bpf_ntohs(sk->dst_port);
     } else {
         if (sk->family == AF_INET) {
             key->src_ip.ip4 = sk->dst_ip4;
@@ -63,6 +87,19 @@ static inline void construct_metric_key(struct bpf_sock *sk, __u8 direction, str
     return;
 }
 
+static inline void latency_observe(struct latency_hist *hist, const __u64 *bounds, __u64 ns)
+{
+    __u32 i;
+
+#pragma unroll
+    for (i = 0; i < METRIC_LATENCY_BUCKETS - 1; i++) {
+        if (ns <= bounds[i])
+            break;
+    }
+    hist->buckets[i]++;
+    hist->sum_ns += ns;
+}
+
 static inline void report_metrics(struct metric_key *mk)
 {
     struct metric_key *key = bpf_ringbuf_reserve(&map_of_metric_notify, sizeof(struct metric_key), 0);
@@ -82,12 +119,16 @@ metric_on_connect(struct bpf_sock *sk, struct bpf_tcp_sock *tcp_sock, struct soc
     struct metric_key key = {0};
     struct metric_data data = {0};
     struct metric_data *metric = NULL;
+    // the connect_ns of an inbound connection is the time it is established, it has no latency
+    __u64 latency = bpf_ktime_get_ns() - storage->connect_ns;
 
     construct_metric_key(sk, storage->direction, &key);
     metric = (struct metric_data *)bpf_map_lookup_elem(&map_of_metrics, &key);
     if (!metric) {
         data.conn_open++;
         data.direction = storage->direction;
+        if (storage->direction == OUTBOUND)
+            latency_observe(&data.connect_latency, connect_latency_bounds, latency);
         int err = bpf_map_update_elem(&map_of_metrics, &key, &data, BPF_NOEXIST);
         if (err) {
             BPF_LOG(ERR, PROBE, "metric_on_connect update failed, err is %d\n", err);
@@ -98,6 +139,8 @@ metric_on_connect(struct bpf_sock *sk, struct bpf_tcp_sock *tcp_sock, struct soc
 
     metric->conn_open++;
     metric->direction = storage->direction;
+    if (storage->direction == OUTBOUND)
+        latency_observe(&metric->connect_latency, connect_latency_bounds, latency);
 notify:
     report_metrics(&key);
     return;
@@ -126,8 +169,9 @@ metric_on_close(struct bpf_sock *sk, struct bpf_tcp_sock *tcp_sock, struct sock_
 
     // connect succeeded & closed
     metric->conn_close++;
-    metric->sent_bytes += tcp_sock->delivered;
+    metric->sent_bytes += tcp_sock->bytes_acked;
     metric->received_bytes += tcp_sock->bytes_received;
+    latency_observe(&metric->duration, conn_duration_bounds, bpf_ktime_get_ns() - storage->connect_ns);
 notify:
     report_metrics(&key);
     return;
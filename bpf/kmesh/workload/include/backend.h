@@ -16,6 +16,45 @@ static inline backend_value *map_lookup_backend(const backend_key *key)
     return kmesh_map_lookup_elem(&map_of_backend, key);
 }
 
+static inline __u32 *map_lookup_backend_service(const backend_service_key *key)
+{
+    return kmesh_map_lookup_elem(&map_of_backend_service, key);
+}
+
+static inline service_port_value *map_lookup_service_port(const service_port_key *key)
+{
+    return kmesh_map_lookup_elem(&map_of_service_port, key);
+}
+
+static inline void tunnel_stats_inc(__u32 index)
+{
+    __u64 *count = kmesh_map_lookup_elem(&map_of_tunnel_stats, &index);
+    if (count)
+        (*count)++;
+}
+
+// select the backend address with the same family as the original destination, an AF_INET6 socket
+// connecting to an IPv4 mapped address wants the IPv4 one. dnat_ip still holds the original
+// destination here, unlike orig_dst_addr it is not reversed from the IPv4 mapped form.
+static inline struct ip_addr *backend_select_addr(struct kmesh_context *kmesh_ctx, backend_value *backend_v)
+{
+    ctx_buff_t *ctx = (ctx_buff_t *)kmesh_ctx->ctx;
+    __u32 index = 0;
+
+    if (ctx->user_family == AF_INET || is_ipv4_mapped_addr(kmesh_ctx->dnat_ip.ip6)) {
+        if (backend_v->ipv4_count == 0)
+            return NULL;
+    } else {
+        if (backend_v->ipv6_count == 0)
+            return NULL;
+        index = backend_v->ipv4_count;
+    }
+
+    if (index >= MAX_ADDRESS_COUNT)
+        return NULL;
+    return &backend_v->addr[index];
+}
+
 static inline int waypoint_manager(struct kmesh_context *kmesh_ctx, struct ip_addr *wp_addr, __u32 port)
 {
     int ret;
@@ -50,11 +89,14 @@ static inline int waypoint_manager(struct kmesh_context *kmesh_ctx, struct ip_ad
 }
 
 static inline int
-backend_manager(struct kmesh_context *kmesh_ctx, backend_value *backend_v, __u32 service_id, service_value *service_v)
+backend_manager(struct kmesh_context *kmesh_ctx, __u32 backend_uid, backend_value *backend_v, __u32 service_id)
 {
     int ret;
     ctx_buff_t *ctx = (ctx_buff_t *)kmesh_ctx->ctx;
-    __u32 user_port = ctx->user_port;
+    struct ip_addr *addr = NULL;
+    backend_service_key backend_service_k = {0};
+    service_port_key service_port_k = {0};
+    service_port_value *service_port_v = NULL;
 
     if (backend_v->waypoint_port != 0) {
         BPF_LOG(
@@ -70,37 +112,50 @@ backend_manager(struct kmesh_context *kmesh_ctx, backend_value *backend_v, __u32
         return ret;
     }
 
-#pragma unroll
-    for (__u32 i = 0; i < MAX_SERVICE_COUNT; i++) {
-        if (i >= backend_v->service_count) {
-            BPF_LOG(WARN, BACKEND, "exceed the max backend service count:%d", backend_v->service_count);
-            break;
-        }
-        if (service_id == backend_v->service[i]) {
-            BPF_LOG(DEBUG, BACKEND, "access the backend by service:%u\n", service_id);
-#pragma unroll
-            for (__u32 j = 0; j < MAX_PORT_COUNT; j++) {
-                if (user_port == service_v->service_port[j]) {
-                    if (ctx->user_family == AF_INET)
-                        kmesh_ctx->dnat_ip.ip4 = backend_v->addr.ip4;
-                    else
-                        bpf_memcpy(kmesh_ctx->dnat_ip.ip6, backend_v->addr.ip6, IPV6_ADDR_LEN);
-                    kmesh_ctx->dnat_port = service_v->target_port[j];
-                    kmesh_ctx->via_waypoint = false;
-                    BPF_LOG(
-                        DEBUG,
-                        BACKEND,
-                        "get the backend addr=[%s:%u]\n",
-                        ip2str((__u32 *)&kmesh_ctx->dnat_ip, ctx->family == AF_INET),
-                        bpf_ntohs(service_v->target_port[j]));
-                    return 0;
-                }
-            }
-        }
+    backend_service_k.backend_uid = backend_uid;
+    backend_service_k.service_id = service_id;
+    if (!map_lookup_backend_service(&backend_service_k)) {
+        BPF_LOG(ERR, BACKEND, "backend %u does not belong to service %u\n", backend_uid, service_id);
+        return -ENOENT;
+    }
+    BPF_LOG(DEBUG, BACKEND, "access the backend by service:%u\n", service_id);
+
+    service_port_k.service_id = service_id;
+    service_port_k.service_port = ctx->user_port;
+    service_port_v = map_lookup_service_port(&service_port_k);
+    if (!service_port_v) {
+        BPF_LOG(ERR, BACKEND, "service %u does not expose port %u\n", service_id, bpf_ntohs(ctx->user_port));
+        return -ENOENT;
     }
 
-    BPF_LOG(ERR, BACKEND, "failed to get the backend\n");
-    return -ENOENT;
+    // kmesh sends plain TCP to the backend, it can not prepend the PROXY header the backend expects
+    if (backend_v->app_tunnel_protocol == APP_TUNNEL_PROXY) {
+        BPF_LOG(WARN, BACKEND, "backend %u expects the PROXY protocol, send plain TCP to it\n", backend_uid);
+        tunnel_stats_inc(TUNNEL_STATS_APP_PROXY);
+    }
+    if (backend_v->tunnel_protocol == TUNNEL_PROTOCOL_HBONE) {
+        BPF_LOG(DEBUG, BACKEND, "backend %u accepts HBONE, send plain TCP to it\n", backend_uid);
+        tunnel_stats_inc(TUNNEL_STATS_HBONE);
+    }
+
+    addr = backend_select_addr(kmesh_ctx, backend_v);
+    if (!addr) {
+        BPF_LOG(ERR, BACKEND, "no backend address matches the socket family\n");
+        return -EAFNOSUPPORT;
+    }
+    if (ctx->user_family == AF_INET)
+        kmesh_ctx->dnat_ip.ip4 = addr->ip4;
+    else
+        bpf_memcpy(kmesh_ctx->dnat_ip.ip6, addr->ip6, IPV6_ADDR_LEN);
+    kmesh_ctx->dnat_port = service_port_v->target_port;
+    kmesh_ctx->via_waypoint = false;
+    BPF_LOG(
+        DEBUG,
+        BACKEND,
+        "get the backend addr=[%s:%u]\n",
+        ip2str((__u32 *)&kmesh_ctx->dnat_ip, ctx->family == AF_INET),
+        bpf_ntohs(service_port_v->target_port));
+    return 0;
 }
 
 #endif
@@ -5,18 +5,27 @@
 #define _KMESH_CONFIG_H_
 
 // map size
-#define MAP_SIZE_OF_FRONTEND 105000
-#define MAP_SIZE_OF_SERVICE  5000
-#define MAP_SIZE_OF_ENDPOINT 105000
-#define MAP_SIZE_OF_BACKEND  100000
-#define MAP_SIZE_OF_AUTH     8192
-#define MAP_SIZE_OF_DSTINFO  8192
+#define MAP_SIZE_OF_FRONTEND        105000
+#define MAP_SIZE_OF_SERVICE         5000
+#define MAP_SIZE_OF_SERVICE_PORT    50000
+#define MAP_SIZE_OF_ENDPOINT        105000
+#define MAP_SIZE_OF_BACKEND         100000
+#define MAP_SIZE_OF_BACKEND_SERVICE 105000
+#define MAP_SIZE_OF_AUTH            8192
+#define MAP_SIZE_OF_AUTHZ           8192
+#define MAP_SIZE_OF_AUTHZ_RULE      65536
+#define MAP_SIZE_OF_DSTINFO         8192
 
 // map name
-#define map_of_frontend kmesh_frontend
-#define map_of_service  kmesh_service
-#define map_of_endpoint kmesh_endpoint
-#define map_of_backend  kmesh_backend
-#define map_of_manager  kmesh_manage
+#define map_of_frontend        kmesh_frontend
+#define map_of_service         kmesh_service
+#define map_of_service_port    kmesh_service_port
+#define map_of_endpoint        kmesh_endpoint
+#define map_of_backend         kmesh_backend
+#define map_of_backend_service kmesh_backend_service
+#define map_of_manager         kmesh_manage
+#define map_of_authz           kmesh_authz
+#define map_of_authz_rule      kmesh_authz_rule
+#define map_of_tunnel_stats    kmesh_tunnel_stats
 
 #endif // _CONFIG_H_
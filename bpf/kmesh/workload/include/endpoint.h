@@ -12,8 +12,7 @@ static inline endpoint_value *map_lookup_endpoint(const endpoint_key *key)
     return kmesh_map_lookup_elem(&map_of_endpoint, key);
 }
 
-static inline int endpoint_manager(
-    struct kmesh_context *kmesh_ctx, endpoint_value *endpoint_v, __u32 service_id, service_value *service_v)
+static inline int endpoint_manager(struct kmesh_context *kmesh_ctx, endpoint_value *endpoint_v, __u32 service_id)
 {
     int ret = 0;
     backend_key backend_k = {0};
@@ -26,7 +25,7 @@ static inline int endpoint_manager(
         return -ENOENT;
     }
 
-    ret = backend_manager(kmesh_ctx, backend_v, service_id, service_v);
+    ret = backend_manager(kmesh_ctx, backend_k.backend_uid, backend_v, service_id);
     if (ret != 0) {
         if (ret != -ENOENT)
             BPF_LOG(ERR, ENDPOINT, "backend_manager failed, ret:%d\n", ret);
@@ -12,22 +12,28 @@ static inline service_value *map_lookup_service(const service_key *key)
     return kmesh_map_lookup_elem(&map_of_service, key);
 }
 
-static inline int lb_random_handle(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v)
+static inline int
+lb_random_handle(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v, __u32 prio)
 {
     int ret = 0;
     endpoint_key endpoint_k = {0};
     endpoint_value *endpoint_v = NULL;
 
+    if (prio >= PRIO_COUNT || service_v->prio_endpoint_count[prio] == 0)
+        return -ENOENT;
+
     endpoint_k.service_id = service_id;
-    endpoint_k.backend_index = bpf_get_prandom_u32() % service_v->endpoint_count + 1;
+    endpoint_k.version = service_v->endpoint_version;
+    endpoint_k.prio = prio;
+    endpoint_k.backend_index = bpf_get_prandom_u32() % service_v->prio_endpoint_count[prio] + 1;
 
     endpoint_v = map_lookup_endpoint(&endpoint_k);
     if (!endpoint_v) {
-        BPF_LOG(WARN, SERVICE, "find endpoint [%u/%u] failed", service_id, endpoint_k.backend_index);
+        BPF_LOG(WARN, SERVICE, "find endpoint [%u/%u/%u] failed", service_id, prio, endpoint_k.backend_index);
         return -ENOENT;
     }
 
-    ret = endpoint_manager(kmesh_ctx, endpoint_v, service_id, service_v);
+    ret = endpoint_manager(kmesh_ctx, endpoint_v, service_id);
     if (ret != 0) {
         if (ret != -ENOENT)
             BPF_LOG(ERR, SERVICE, "endpoint_manager failed, ret:%d\n", ret);
@@ -37,6 +43,31 @@ static inline int lb_random_handle(struct kmesh_context *kmesh_ctx, __u32 servic
     return 0;
 }
 
+// Strict mode only selects endpoints which match all the routing preferences, they are stored with the highest
+// priority 0.
+static inline int lb_locality_strict_handle(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v)
+{
+    if (service_v->prio_endpoint_count[0] == 0) {
+        BPF_LOG(WARN, SERVICE, "no endpoint matches the locality of service %u in strict mode\n", service_id);
+        return -ENOENT;
+    }
+
+    return lb_random_handle(kmesh_ctx, service_id, service_v, 0);
+}
+
+// Failover mode selects endpoints from the highest priority group which is not empty.
+static inline int
+lb_locality_failover_handle(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v)
+{
+#pragma unroll
+    for (__u32 prio = 0; prio < PRIO_COUNT; prio++) {
+        if (service_v->prio_endpoint_count[prio] > 0)
+            return lb_random_handle(kmesh_ctx, service_id, service_v, prio);
+    }
+
+    return -ENOENT;
+}
+
 static inline int service_manager(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v)
 {
     int ret = 0;
@@ -58,7 +89,14 @@ static inline int service_manager(struct kmesh_context *kmesh_ctx, __u32 service
     BPF_LOG(DEBUG, SERVICE, "load balance type:%u\n", service_v->lb_policy);
     switch (service_v->lb_policy) {
     case LB_POLICY_RANDOM:
-        ret = lb_random_handle(kmesh_ctx, service_id, service_v);
+        // without locality load balancing, all the endpoints are stored with priority 0
+        ret = lb_random_handle(kmesh_ctx, service_id, service_v, 0);
+        break;
+    case LB_POLICY_STRICT:
+        ret = lb_locality_strict_handle(kmesh_ctx, service_id, service_v);
+        break;
+    case LB_POLICY_FAILOVER:
+        ret = lb_locality_failover_handle(kmesh_ctx, service_id, service_v);
         break;
     default:
         BPF_LOG(ERR, SERVICE, "unsupported load balance type:%u\n", service_v->lb_policy);
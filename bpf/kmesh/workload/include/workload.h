@@ -6,9 +6,25 @@
 
 #include "config.h"
 
-#define MAX_PORT_COUNT    10
-#define MAX_SERVICE_COUNT 10
+#define MAX_ADDRESS_COUNT 4
 #define RINGBUF_SIZE      (1 << 12)
+#define PRIO_COUNT        7
+
+// the values follow the TunnelProtocol and ApplicationTunnel.Protocol enums of the workload api
+#define TUNNEL_PROTOCOL_NONE  0 // the backend receives plain TCP
+#define TUNNEL_PROTOCOL_HBONE 1 // the backend receives HBONE
+#define APP_TUNNEL_NONE       0
+#define APP_TUNNEL_PROXY      1 // the backend expects the PROXY protocol after the last mesh hop
+
+// index of map_of_tunnel_stats, the connections sent plain TCP to a backend expecting the tunnel
+#define TUNNEL_STATS_APP_PROXY 0
+#define TUNNEL_STATS_HBONE     1
+#define TUNNEL_STATS_MAX       2
+
+#define AUTHZ_ACTION_ALLOW 0
+#define AUTHZ_ACTION_DENY  1
+// number of the bits in authz_rule_key before src_addr, they are always matched in full
+#define AUTHZ_RULE_PREFIX_LEN ((sizeof(struct ip_addr) + sizeof(__u32) * 2) * 8)
 
 #pragma pack(1)
 // frontend map
@@ -26,19 +42,29 @@ typedef struct {
 } service_key;
 
 typedef struct {
-    __u32 endpoint_count;               // endpoint count of current service
-    __u32 lb_policy;                    // load balancing algorithm, currently only supports random algorithm
-    __u32 service_port[MAX_PORT_COUNT]; // service_port[i] and target_port[i] are a pair, i starts from 0 and max value
-                                        // is MAX_PORT_COUNT-1
-    __u32 target_port[MAX_PORT_COUNT];
+    __u32 prio_endpoint_count[PRIO_COUNT]; // endpoint count of current service, grouped by locality priority
+    __u32 endpoint_version;                // the endpoint set in use, it is flipped to publish a rewritten set
+    __u32 lb_policy;                       // load balancing algorithm, random/strict/failover
     struct ip_addr wp_addr;
     __u32 waypoint_port;
 } service_value;
 
+// service port map
+typedef struct {
+    __u32 service_id;   // service id
+    __u32 service_port; // service port in network byte order
+} service_port_key;
+
+typedef struct {
+    __u32 target_port; // target port in network byte order
+} service_port_value;
+
 // endpoint map
 typedef struct {
     __u32 service_id;    // service id
-    __u32 backend_index; // if endpoint_count = 3, then backend_index = 0/1/2
+    __u32 version;       // endpoint_version of the service the endpoint belongs to
+    __u32 prio;          // locality priority of the endpoint, 0 is the highest
+    __u32 backend_index; // if prio_endpoint_count[prio] = 3, then backend_index = 1/2/3
 } endpoint_key;
 
 typedef struct {
@@ -50,12 +76,39 @@ typedef struct {
     __u32 backend_uid; // workload_uid to uint32
 } backend_key;
 typedef struct {
-    struct ip_addr addr;
-    __u32 service_count;
-    __u32 service[MAX_SERVICE_COUNT];
+    struct ip_addr addr[MAX_ADDRESS_COUNT]; // IPv4 addresses are stored before IPv6 addresses
+    __u32 ipv4_count;                       // number of IPv4 addresses in addr
+    __u32 ipv6_count;                       // number of IPv6 addresses in addr, following the IPv4 ones
     struct ip_addr wp_addr;
     __u32 waypoint_port;
+    __u32 tunnel_protocol;     // TUNNEL_PROTOCOL_NONE or TUNNEL_PROTOCOL_HBONE
+    __u32 app_tunnel_protocol; // APP_TUNNEL_NONE or APP_TUNNEL_PROXY
 } backend_value;
+
+// backend service map, an entry exists for every service the backend belongs to
+typedef struct {
+    __u32 backend_uid; // workload_uid to uint32
+    __u32 service_id;  // service id
+} backend_service_key;
+
+// authz map, a workload is present only if all its authorization policies are decided in kernel
+typedef struct {
+    struct ip_addr addr; // Pod ip
+} authz_key;
+
+typedef struct {
+    __u32 has_allow; // the workload has allow policies, a connection matching none of them is denied
+} authz_value;
+
+// authz rule map, an lpm trie of the (source prefix, destination port) pairs matched by the
+// allow or deny policies of a workload
+typedef struct {
+    __u32 prefixlen; // AUTHZ_RULE_PREFIX_LEN plus the prefix length of src_addr
+    struct ip_addr dst_addr;
+    __u32 action;   // AUTHZ_ACTION_ALLOW or AUTHZ_ACTION_DENY
+    __u32 dst_port; // host byte order, 0 matches any port
+    struct ip_addr src_addr;
+} authz_rule_key;
 #pragma pack()
 
 struct {
@@ -74,6 +127,14 @@ struct {
     __uint(map_flags, BPF_F_NO_PREALLOC);
 } map_of_service SEC(".maps");
 
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(service_port_key));
+    __uint(value_size, sizeof(service_port_value));
+    __uint(max_entries, MAP_SIZE_OF_SERVICE_PORT);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} map_of_service_port SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(key_size, sizeof(endpoint_key));
@@ -90,14 +151,48 @@ struct {
     __uint(map_flags, BPF_F_NO_PREALLOC);
 } map_of_backend SEC(".maps");
 
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(backend_service_key));
+    __uint(value_size, sizeof(__u32)); // unused, only the existence of the key matters
+    __uint(max_entries, MAP_SIZE_OF_BACKEND_SERVICE);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} map_of_backend_service SEC(".maps");
+
+// map_of_auth holds the denied tuples, the value is the bpf_ktime_get_ns of the denial
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __type(key, struct bpf_sock_tuple);
-    __type(value, __u32);
+    __type(value, __u64);
     __uint(max_entries, MAP_SIZE_OF_AUTH);
     __uint(map_flags, BPF_F_NO_PREALLOC);
 } map_of_auth SEC(".maps");
 
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(authz_key));
+    __uint(value_size, sizeof(authz_value));
+    __uint(max_entries, MAP_SIZE_OF_AUTHZ);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} map_of_authz SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(key_size, sizeof(authz_rule_key));
+    __uint(value_size, sizeof(__u32));
+    __uint(max_entries, MAP_SIZE_OF_AUTHZ_RULE);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} map_of_authz_rule SEC(".maps");
+
+// map_of_tunnel_stats counts the connections kmesh can not send over the tunnel of the backend,
+// the user space reports them
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, TUNNEL_STATS_MAX);
+} map_of_tunnel_stats SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_RINGBUF);
     __uint(max_entries, RINGBUF_SIZE);
@@ -20,6 +20,12 @@ enum family_type {
     IPV6,
 };
 
+enum authz_verdict {
+    AUTHZ_USERSPACE, // the policies of the workload are not offloaded, userspace decides
+    AUTHZ_ALLOW,
+    AUTHZ_DENY,
+};
+
 struct ringbuf_msg_type {
     __u32 type;
     struct bpf_sock_tuple tuple;
@@ -126,6 +132,75 @@ static inline void auth_ip_tuple(struct bpf_sock_ops *skops)
     bpf_ringbuf_submit(msg, 0);
 }
 
+// deny the connection by recording its tuple in map_of_auth, the xdp prog resets it on the next packet
+static inline void deny_ip_tuple(struct bpf_sock_ops *skops)
+{
+    // the time of the denial, the tuples never reset by the xdp prog are expired by kmesh daemon
+    __u64 value = bpf_ktime_get_ns();
+    struct bpf_sock_tuple tuple_key = {0};
+    // same as auth_ip_tuple, src is the client and dst is the server
+    extract_skops_to_tuple_reverse(skops, &tuple_key);
+    int ret = bpf_map_update_elem(&map_of_auth, &tuple_key, &value, BPF_ANY);
+    if (ret)
+        BPF_LOG(ERR, SOCKOPS, "map_of_auth bpf_map_update_elem failed, ret: %d", ret);
+}
+
+static inline bool authz_rule_matched(authz_rule_key *key, __u32 action, __u32 port)
+{
+    key->action = action;
+    key->dst_port = port;
+    if (bpf_map_lookup_elem(&map_of_authz_rule, key))
+        return true;
+    // the rules without destination ports
+    key->dst_port = 0;
+    return bpf_map_lookup_elem(&map_of_authz_rule, key) != NULL;
+}
+
+// authz_offloaded decides the connection in kernel with map_of_authz_rule, if the policies of the
+// server workload contain nothing but addresses and ports
+static inline int authz_offloaded(struct bpf_sock_ops *skops)
+{
+    authz_key key = {0};
+    authz_rule_key rule_key = {0};
+
+    if (skops->family == AF_INET) {
+        key.addr.ip4 = skops->local_ip4;
+        rule_key.src_addr.ip4 = skops->remote_ip4;
+    } else {
+        IP6_COPY(key.addr.ip6, skops->local_ip6);
+        IP6_COPY(rule_key.src_addr.ip6, skops->remote_ip6);
+    }
+
+    authz_value *value = bpf_map_lookup_elem(&map_of_authz, &key);
+    if (!value)
+        return AUTHZ_USERSPACE;
+
+    rule_key.prefixlen = AUTHZ_RULE_PREFIX_LEN + sizeof(struct ip_addr) * 8;
+    rule_key.dst_addr = key.addr;
+    // local_port is host byteorder
+    __u32 port = GET_SKOPS_LOCAL_PORT(skops);
+
+    if (authz_rule_matched(&rule_key, AUTHZ_ACTION_DENY, port))
+        return AUTHZ_DENY;
+    if (value->has_allow && !authz_rule_matched(&rule_key, AUTHZ_ACTION_ALLOW, port))
+        return AUTHZ_DENY;
+    return AUTHZ_ALLOW;
+}
+
+static inline void auth_connection(struct bpf_sock_ops *skops)
+{
+    switch (authz_offloaded(skops)) {
+    case AUTHZ_ALLOW:
+        break;
+    case AUTHZ_DENY:
+        deny_ip_tuple(skops);
+        break;
+    default:
+        auth_ip_tuple(skops);
+        break;
+    }
+}
+
 // update sockmap to trigger sk_msg prog to encode metadata before sending to waypoint
 static inline void enable_encoding_metadata(struct bpf_sock_ops *skops)
 {
@@ -227,7 +302,7 @@ int sockops_prog(struct bpf_sock_ops *skops)
         observe_on_connect_established(skops->sk, INBOUND);
         if (bpf_sock_ops_cb_flags_set(skops, BPF_SOCK_OPS_STATE_CB_FLAG) != 0)
             BPF_LOG(ERR, SOCKOPS, "set sockops cb failed!\n");
-        auth_ip_tuple(skops);
+        auth_connection(skops);
         break;
     case BPF_SOCK_OPS_STATE_CB:
         if (skops->args[1] == BPF_TCP_CLOSE) {
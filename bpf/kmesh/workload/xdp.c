@@ -54,7 +54,7 @@ static inline void shutdown_tuple(struct xdp_info *info)
 
 static inline int should_shutdown(struct xdp_info *info, struct bpf_sock_tuple *tuple_info)
 {
-    __u32 *value = bpf_map_lookup_elem(&map_of_auth, tuple_info);
+    __u64 *value = bpf_map_lookup_elem(&map_of_auth, tuple_info);
     if (value) {
         if (info->iph->version == 4)
             BPF_LOG(
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2024 The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"kmesh.net/kmesh/pkg/auth"
+	"kmesh.net/kmesh/pkg/status"
+)
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "authz",
+		Short: "Inspect the authorization of workload mode",
+	}
+	cmd.AddCommand(newCheckCmd())
+	return cmd
+}
+
+func newCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Explain the authorization decision on a connection with the live policies",
+		Example: `Check a connection from 10.244.0.5 to port 8080 of 10.244.0.8:
+		kmesh-daemon authz check --src 10.244.0.5 --dst 10.244.0.8:8080`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			RunCheck(cmd)
+		},
+	}
+	cmd.Flags().String("src", "", "source ip of the connection")
+	cmd.Flags().String("dst", "", "destination ip:port of the connection")
+	cmd.Flags().Bool("json", false, "print the result in json")
+	_ = cmd.MarkFlagRequired("src")
+	_ = cmd.MarkFlagRequired("dst")
+	return cmd
+}
+
+func RunCheck(cmd *cobra.Command) {
+	src, _ := cmd.Flags().GetString("src")
+	dst, _ := cmd.Flags().GetString("dst")
+	printJson, _ := cmd.Flags().GetBool("json")
+
+	query := url.Values{}
+	query.Set("src", src)
+	query.Set("dst", dst)
+	resp, err := http.Get(status.GetAuthzCheckURL() + "?" + query.Encode())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Error reading response: %v\n", err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Error: received status code %d\n", resp.StatusCode)
+		fmt.Printf("Response body: %s\n", body)
+		os.Exit(1)
+	}
+	if printJson {
+		fmt.Println(string(body))
+		return
+	}
+
+	var result auth.CheckResult
+	if err = json.Unmarshal(body, &result); err != nil {
+		fmt.Printf("Error unmarshaling response body: %v\n", err)
+		os.Exit(1)
+	}
+	printCheckResult(os.Stdout, &result)
+}
+
+func printCheckResult(w io.Writer, result *auth.CheckResult) {
+	fmt.Fprintf(w, "Source:      %s%s\n", result.SrcIp, annotate(result.SrcIdentity))
+	fmt.Fprintf(w, "Destination: %s:%d%s\n", result.DstIp, result.DstPort, annotate(result.DstWorkload))
+	if !result.Enforced {
+		fmt.Fprintln(w, "Warning:     the initial sync is not done, the policies are not enforced yet")
+	}
+
+	fmt.Fprintln(w, "Policies:")
+	if len(result.Policies) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	}
+	for _, policy := range result.Policies {
+		action := policy.Action
+		if policy.DryRun != "" {
+			action += ", dry-run would " + policy.DryRun
+		}
+		matched := "not matched"
+		if policy.Matched {
+			matched = "matched"
+		}
+		fmt.Fprintf(w, "  %s [%s]: %s\n", policy.Policy, action, matched)
+		for i, rule := range policy.Rules {
+			matched = "not matched"
+			if rule.Matched {
+				matched = "matched"
+			}
+			fmt.Fprintf(w, "    rule %d: %s, clauses: %s\n", i, matched, describeClauses(rule.Clauses))
+		}
+	}
+	fmt.Fprintf(w, "Verdict:     %s (%s)\n", result.Decision, result.Reason)
+}
+
+func annotate(s string) string {
+	if s == "" {
+		return ""
+	}
+	return " (" + s + ")"
+}
+
+// describeClauses names the match satisfying each clause, see auth.RuleCheck
+func describeClauses(clauses []int) string {
+	out := make([]string, 0, len(clauses))
+	for _, index := range clauses {
+		switch {
+		case index == -1:
+			out = append(out, "any")
+		case index < 0:
+			out = append(out, "none")
+		default:
+			out = append(out, fmt.Sprintf("match %d", index))
+		}
+	}
+	return "[" + strings.Join(out, ", ") + "]"
+}
@@ -18,6 +18,7 @@
 package manager
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
@@ -26,6 +27,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
+	"kmesh.net/kmesh/daemon/manager/authz"
 	"kmesh.net/kmesh/daemon/manager/dump"
 	logcmd "kmesh.net/kmesh/daemon/manager/log"
 	"kmesh.net/kmesh/daemon/manager/version"
@@ -33,6 +35,7 @@ import (
 	"kmesh.net/kmesh/pkg/bpf"
 	"kmesh.net/kmesh/pkg/cni"
 	"kmesh.net/kmesh/pkg/controller"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/logger"
 	"kmesh.net/kmesh/pkg/status"
 )
@@ -67,6 +70,7 @@ func NewCommand() *cobra.Command {
 	cmd.AddCommand(version.NewCmd())
 	cmd.AddCommand(dump.NewCmd())
 	cmd.AddCommand(logcmd.NewCmd())
+	cmd.AddCommand(authz.NewCmd())
 
 	return cmd
 }
@@ -90,6 +94,18 @@ func Execute(configs *options.BootstrapConfigs) error {
 	log.Info("controller Start successful")
 	defer c.Stop()
 
+	// the metrics server is created after the controller, which drops the configured traffic labels
+	metricsCtx, cancelMetrics := context.WithCancel(context.Background())
+	metricsServer := telemetry.NewMetricsServer(configs.TelemetryConfig.MetricsAddr)
+	if err := metricsServer.Start(metricsCtx); err != nil {
+		cancelMetrics()
+		return err
+	}
+	defer func() {
+		cancelMetrics()
+		metricsServer.Wait()
+	}()
+
 	statusServer := status.NewServer(c.GetXdsClient(), configs, bpfLoader.GetBpfLogLevel())
 	statusServer.StartServer()
 	defer func() {
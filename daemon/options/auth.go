@@ -0,0 +1,74 @@
+/* Copyright 2024 The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kmesh.net/kmesh/pkg/constants"
+)
+
+// authConfig holds the authorization settings as given, the modes are parsed by the auth package
+// when they are applied.
+type authConfig struct {
+	IdentityVerify string
+	AuditLogFile   string
+	AuditAllowed   bool
+	DryRunPolicies []string
+	// UnknownDestination is the policy of the connections to the destinations not in the workload cache
+	UnknownDestination string
+	// InterfaceNetworks maps the interfaces of the node to the networks other than the node's one
+	InterfaceNetworks []string
+	// DeniedTupleTTL is how long a denied connection is kept in the xdp auth map
+	DeniedTupleTTL time.Duration
+}
+
+func (c *authConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&c.IdentityVerify, "service-identity-verify", "log",
+		"what to do with connections to backends whose identity is not in the subject alt names of their services, valid values are [off, log, deny]")
+	cmd.PersistentFlags().StringVar(&c.AuditLogFile, "authz-audit-log", "/var/run/kmesh/authz_audit.log",
+		"file the authorization decisions are written to, the recent ones can still be queried from the status server if it is empty. "+
+			"The policies are not offloaded into the bpf maps while it is set, as the connections decided in kernel are not audited, "+
+			"set it empty to offload them, the recent decisions then miss the offloaded ones")
+	cmd.PersistentFlags().BoolVar(&c.AuditAllowed, "authz-audit-allowed", false,
+		"record the allowed connections in the authorization audit log besides the denied ones")
+	cmd.PersistentFlags().StringSliceVar(&c.DryRunPolicies, "authz-dry-run-policies", nil,
+		"authorization policies evaluated and reported without being enforced, in the form of namespace/name or namespace/*")
+	cmd.PersistentFlags().StringVar(&c.UnknownDestination, "authz-unknown-destination", "deny",
+		"what to do with connections to destinations not found in the workload cache, valid values are [allow, deny, retry]. "+
+			"allow also allows all the connections before the initial sync, the others hold them until it completes, "+
+			"retry holds the connections to unknown destinations until the caches are updated")
+	cmd.PersistentFlags().StringSliceVar(&c.InterfaceNetworks, "authz-interface-networks", nil,
+		"networks of the peers reached through the interfaces of the node, in the form of interface=network, the others are in the network of the node")
+	cmd.PersistentFlags().DurationVar(&c.DeniedTupleTTL, "authz-denied-tuple-ttl", constants.AuthDeniedTupleTTL,
+		"how long a denied connection is kept in the xdp auth map if its packets are not reset, 0 keeps it until the connection is closed")
+}
+
+func (c *authConfig) ParseConfig() error {
+	if c.DeniedTupleTTL < 0 {
+		return fmt.Errorf("invalid denied tuple ttl %s, should not be negative", c.DeniedTupleTTL)
+	}
+	for _, name := range c.DryRunPolicies {
+		if ns, policy, ok := strings.Cut(name, "/"); !ok || ns == "" || policy == "" {
+			return fmt.Errorf("invalid dry-run policy %q, should be namespace/name or namespace/*", name)
+		}
+	}
+	return nil
+}
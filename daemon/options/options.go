@@ -29,6 +29,9 @@ type BootstrapConfigs struct {
 	CniConfig           *cniConfig
 	ByPassConfig        *byPassConfig
 	SecretManagerConfig *secretConfig
+	WaypointConfig      *waypointConfig
+	AuthConfig          *authConfig
+	TelemetryConfig     *telemetryConfig
 }
 
 func NewBootstrapConfigs() *BootstrapConfigs {
@@ -37,6 +40,9 @@ func NewBootstrapConfigs() *BootstrapConfigs {
 		CniConfig:           &cniConfig{},
 		ByPassConfig:        &byPassConfig{},
 		SecretManagerConfig: &secretConfig{},
+		WaypointConfig:      &waypointConfig{},
+		AuthConfig:          &authConfig{},
+		TelemetryConfig:     &telemetryConfig{},
 	}
 }
 
@@ -54,6 +60,9 @@ func (c *BootstrapConfigs) AttachFlags(cmd *cobra.Command) {
 	c.CniConfig.AttachFlags(cmd)
 	c.ByPassConfig.AttachFlags(cmd)
 	c.SecretManagerConfig.AttachFlags(cmd)
+	c.WaypointConfig.AttachFlags(cmd)
+	c.AuthConfig.AttachFlags(cmd)
+	c.TelemetryConfig.AttachFlags(cmd)
 }
 
 func (c *BootstrapConfigs) ParseConfigs() error {
@@ -63,5 +72,14 @@ func (c *BootstrapConfigs) ParseConfigs() error {
 	if err := c.CniConfig.ParseConfig(); err != nil {
 		return fmt.Errorf("parse CniConfig failed, %s", err)
 	}
+	if err := c.WaypointConfig.ParseConfig(); err != nil {
+		return fmt.Errorf("parse WaypointConfig failed, %s", err)
+	}
+	if err := c.AuthConfig.ParseConfig(); err != nil {
+		return fmt.Errorf("parse AuthConfig failed, %s", err)
+	}
+	if err := c.TelemetryConfig.ParseConfig(); err != nil {
+		return fmt.Errorf("parse TelemetryConfig failed, %s", err)
+	}
 	return nil
 }
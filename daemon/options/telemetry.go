@@ -0,0 +1,51 @@
+/* Copyright 2024 The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+type telemetryConfig struct {
+	EnableAccessLog bool
+	AccessLogFormat string
+	// AccessLogFormatType is the parsed AccessLogFormat
+	AccessLogFormatType telemetry.AccessLogFormat `json:"-"`
+	// MetricDroppedLabels are the traffic labels left out of the metrics
+	MetricDroppedLabels []string
+	MetricsAddr         string
+}
+
+func (c *telemetryConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&c.EnableAccessLog, "enable-accesslog", false,
+		"write an access log line for each connection closed, it can also be toggled from the status server")
+	cmd.PersistentFlags().StringVar(&c.AccessLogFormat, "accesslog-format", "text",
+		"format of the access log lines, valid values are [text, json]")
+	cmd.PersistentFlags().StringSliceVar(&c.MetricDroppedLabels, "metrics-drop-labels", nil,
+		"labels left out of the tcp metrics to reduce their cardinality on large clusters, e.g. source_principal,destination_principal,source_canonical_revision,destination_canonical_revision")
+	cmd.PersistentFlags().StringVar(&c.MetricsAddr, "metrics-addr", telemetry.DefaultMetricsAddr,
+		"address the prometheus metrics are served on at /status/metric, empty to disable the metrics server")
+}
+
+func (c *telemetryConfig) ParseConfig() error {
+	var err error
+	if c.AccessLogFormatType, err = telemetry.ParseAccessLogFormat(c.AccessLogFormat); err != nil {
+		return err
+	}
+	return telemetry.CheckTrafficLabels(c.MetricDroppedLabels)
+}
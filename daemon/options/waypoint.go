@@ -0,0 +1,40 @@
+/* Copyright 2024 The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"kmesh.net/kmesh/pkg/constants"
+)
+
+type waypointConfig struct {
+	Port uint32
+}
+
+func (c *waypointConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Uint32Var(&c.Port, "waypoint-port", constants.KmeshWaypointPort,
+		"the port kmesh waypoints listen on, traffic to the services used as waypoints is sent to it")
+}
+
+func (c *waypointConfig) ParseConfig() error {
+	if c.Port == 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid waypoint port %d", c.Port)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	AuditDecisionAllow = "allow"
+	AuditDecisionDeny  = "deny"
+
+	// auditRecentRecords is the number of the latest records kept in memory for queries
+	auditRecentRecords = 1024
+	auditMaxSize       = 100 // megabytes
+	auditMaxBackups    = 5
+	auditMaxAge        = 7 // days
+	// auditPendingRecords is the number of the records waiting to be written, the records coming
+	// while it is full are dropped from the file
+	auditPendingRecords = 4096
+)
+
+// AuditRecord is the decision made on a connection by Rbac and the reason of it.
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	SrcIp       string    `json:"srcIp"`
+	SrcPort     uint32    `json:"srcPort"`
+	DstIp       string    `json:"dstIp"`
+	DstPort     uint32    `json:"dstPort"`
+	SrcIdentity string    `json:"srcIdentity,omitempty"`
+	DstWorkload string    `json:"dstWorkload,omitempty"`
+	Decision    string    `json:"decision"`
+	Reason      string    `json:"reason"`
+	// Match is the policy rule the decision is made by, it is nil for a default decision
+	Match *AuditMatch `json:"match,omitempty"`
+	// DryRun maps each dry-run policy of the workload to the decision it would make
+	DryRun map[string]string `json:"dryRun,omitempty"`
+}
+
+type AuditMatch struct {
+	Policy string `json:"policy"`
+	Action string `json:"action"`
+	Rule   int    `json:"rule"`
+	// Clauses holds the index of the match satisfying each clause of the rule, -1 for a clause
+	// without matches
+	Clauses []int `json:"clauses"`
+}
+
+// AuditFilter selects the records returned by Query, an empty field selects all.
+type AuditFilter struct {
+	Decision    string
+	SrcIp       string
+	DstIp       string
+	DstWorkload string
+	// Limit is the maximum number of records returned, 0 means no limit
+	Limit int
+}
+
+// AuditLog records the authorization decisions made in userspace. Every record is written as a
+// json line to a rotating file, and the latest ones are kept in memory to be queried from the
+// status server. The connections decided by the policies offloaded into the bpf maps never reach
+// userspace, so they are not recorded.
+//
+// The file is written by a background goroutine, so that a slow disk or a rotation never holds up
+// the decisions.
+type AuditLog struct {
+	mutex sync.Mutex
+	// writer is nil if the records are only kept in memory
+	writer io.WriteCloser
+	// lines are the records waiting for writeLoop, done is closed once it has written them all
+	lines   chan []byte
+	done    chan struct{}
+	closed  bool
+	dropped uint64
+	// allowed decides whether the allowed connections are recorded besides the denied ones
+	allowed bool
+	recent  []AuditRecord
+	next    int
+}
+
+// NewAuditLog creates an audit log writing to path, the file is not written if path is empty.
+func NewAuditLog(path string, allowed bool) *AuditLog {
+	a := &AuditLog{
+		allowed: allowed,
+		recent:  make([]AuditRecord, 0, auditRecentRecords),
+	}
+	if path != "" {
+		a.writer = &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    auditMaxSize,
+			MaxBackups: auditMaxBackups,
+			MaxAge:     auditMaxAge,
+		}
+		a.lines = make(chan []byte, auditPendingRecords)
+		a.done = make(chan struct{})
+		go a.writeLoop()
+	}
+	return a
+}
+
+func (a *AuditLog) writeLoop() {
+	defer close(a.done)
+	for line := range a.lines {
+		if _, err := a.writer.Write(line); err != nil {
+			log.Errorf("write audit record failed, err:%s", err)
+		}
+	}
+}
+
+// SetAuditLog sets the audit log of the decisions. The connections decided by the sockops prog
+// on the offloaded policies never reach userspace, so the offloading is disabled while the
+// records are written to a file.
+func (r *Rbac) SetAuditLog(auditLog *AuditLog) {
+	r.auditLog = auditLog
+	if auditLog.writesFile() {
+		r.disablePolicyMaps()
+	}
+}
+
+// AuditLog returns the audit log, it is nil if auditing is not enabled.
+func (r *Rbac) AuditLog() *AuditLog {
+	if r == nil {
+		return nil
+	}
+	return r.auditLog
+}
+
+// writesFile reports whether the records are written to a file besides being kept in memory
+func (a *AuditLog) writesFile() bool {
+	return a != nil && a.writer != nil
+}
+
+func (a *AuditLog) record(conn *rbacConnection, result *rbacResult) {
+	if a == nil || (result.allow && !a.allowed) {
+		return
+	}
+
+	record := AuditRecord{
+		Time:     time.Now(),
+		SrcIp:    addrString(conn.srcIp),
+		SrcPort:  conn.srcPort,
+		DstIp:    addrString(conn.dstIp),
+		DstPort:  conn.dstPort,
+		Decision: AuditDecisionDeny,
+		Reason:   result.reason,
+	}
+	if result.allow {
+		record.Decision = AuditDecisionAllow
+	}
+	if conn.srcIdentity != (Identity{}) {
+		record.SrcIdentity = conn.srcIdentity.String()
+	}
+	if result.dstWorkload != nil {
+		record.DstWorkload = result.dstWorkload.GetUid()
+	}
+	if result.policy != nil {
+		record.Match = &AuditMatch{
+			Policy: result.policy.policy.ResourceName(),
+			Action: result.policy.policy.GetAction().String(),
+			Rule:   result.rule,
+		}
+		for i := range result.policy.rules[result.rule].clauses {
+			record.Match.Clauses = append(record.Match.Clauses,
+				matchClause(conn, &result.policy.rules[result.rule].clauses[i]))
+		}
+	}
+	for _, dryRun := range result.dryRun {
+		if record.DryRun == nil {
+			record.DryRun = make(map[string]string, len(result.dryRun))
+		}
+		record.DryRun[dryRun.policy.policy.ResourceName()] = AuditDecisionDeny
+		if dryRun.allow {
+			record.DryRun[dryRun.policy.policy.ResourceName()] = AuditDecisionAllow
+		}
+	}
+
+	var line []byte
+	if a.lines != nil {
+		data, err := json.Marshal(&record)
+		if err != nil {
+			log.Errorf("marshal audit record failed, err:%s", err)
+		} else {
+			line = append(data, '\n')
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(a.recent) < cap(a.recent) {
+		a.recent = append(a.recent, record)
+	} else {
+		a.recent[a.next] = record
+	}
+	a.next = (a.next + 1) % cap(a.recent)
+
+	if line == nil || a.closed {
+		return
+	}
+	select {
+	case a.lines <- line:
+	default:
+		// log the first drop and every auditPendingRecords drops after it
+		if a.dropped%auditPendingRecords == 0 {
+			log.Warnf("audit log can not keep up, %d records are dropped from the file", a.dropped+1)
+		}
+		a.dropped++
+	}
+}
+
+// Query returns the latest records selected by the filter, the newest comes first.
+func (a *AuditLog) Query(filter AuditFilter) []AuditRecord {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	out := []AuditRecord{}
+	for i := 1; i <= len(a.recent); i++ {
+		record := a.recent[(a.next-i+len(a.recent))%len(a.recent)]
+		if (filter.Decision != "" && record.Decision != filter.Decision) ||
+			(filter.SrcIp != "" && record.SrcIp != filter.SrcIp) ||
+			(filter.DstIp != "" && record.DstIp != filter.DstIp) ||
+			(filter.DstWorkload != "" && record.DstWorkload != filter.DstWorkload) {
+			continue
+		}
+		out = append(out, record)
+		if filter.Limit > 0 && len(out) == filter.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// Close writes the pending records and closes the file.
+func (a *AuditLog) Close() error {
+	if a == nil || a.writer == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.lines)
+	}
+	a.mutex.Unlock()
+	<-a.done
+	return a.writer.Close()
+}
+
+func addrString(ip []byte) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ""
+	}
+	return addr.Unmap().String()
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestAuditLog(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := NewRbac(workloadCache, nil)
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "deny-8080",
+		Namespace: "default",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{{Clauses: []*security.Clause{
+			{},
+			{Matches: []*security.Match{
+				{DestinationPorts: []uint32{9090}},
+				{DestinationPorts: []uint32{8080}},
+			}},
+		}}},
+	}))
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog := NewAuditLog(path, false)
+	rbac.SetAuditLog(auditLog)
+
+	check := func(dst string, port uint32) {
+		conn := &rbacConnection{
+			srcIp:   netip.MustParseAddr("192.168.1.1").AsSlice(),
+			srcPort: 40000,
+			dstIp:   netip.MustParseAddr(dst).AsSlice(),
+			dstPort: port,
+		}
+		result := rbac.authorize(conn)
+		rbac.auditLog.record(conn, &result)
+	}
+	check("10.0.0.1", 80)
+	check("10.0.0.1", 8080)
+	check("10.0.0.2", 80)
+
+	// the allowed connection is not recorded, the newest record comes first
+	records := auditLog.Query(AuditFilter{})
+	assert.Len(t, records, 2)
+	assert.Equal(t, "10.0.0.2", records[0].DstIp)
+	assert.Equal(t, reasonNoWorkload, records[0].Reason)
+	assert.Nil(t, records[0].Match)
+
+	denied := records[1]
+	assert.Equal(t, AuditDecisionDeny, denied.Decision)
+	assert.Equal(t, "cluster0//Pod/default/reviews", denied.DstWorkload)
+	assert.Equal(t, uint32(40000), denied.SrcPort)
+	assert.Equal(t, &AuditMatch{
+		Policy:  "default/deny-8080",
+		Action:  security.Action_DENY.String(),
+		Rule:    0,
+		Clauses: []int{clauseMatchAll, 1},
+	}, denied.Match)
+
+	assert.Equal(t, []AuditRecord{denied}, auditLog.Query(AuditFilter{DstWorkload: "cluster0//Pod/default/reviews"}))
+	assert.Len(t, auditLog.Query(AuditFilter{Decision: AuditDecisionAllow}), 0)
+	assert.Len(t, auditLog.Query(AuditFilter{Limit: 1}), 1)
+
+	// the records are written as json lines
+	assert.NoError(t, auditLog.Close())
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var lines []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		lines = append(lines, record)
+	}
+	assert.Len(t, lines, 2)
+	assert.Equal(t, reasonDenyPolicy, lines[0].Reason)
+}
+
+func TestAuditLog_recentRecords(t *testing.T) {
+	auditLog := NewAuditLog("", true)
+	result := rbacResult{allow: true, reason: reasonNoAllowPolicy}
+	for port := uint32(1); port <= auditRecentRecords+10; port++ {
+		auditLog.record(&rbacConnection{dstPort: port}, &result)
+	}
+
+	records := auditLog.Query(AuditFilter{})
+	assert.Len(t, records, auditRecentRecords)
+	assert.Equal(t, uint32(auditRecentRecords+10), records[0].DstPort)
+	assert.Equal(t, uint32(11), records[len(records)-1].DstPort)
+}
+
+// blockingWriter holds every write until it is released
+type blockingWriter struct {
+	release chan struct{}
+	writes  int
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.writes++
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error {
+	return nil
+}
+
+func TestAuditLog_slowWriter(t *testing.T) {
+	writer := &blockingWriter{release: make(chan struct{})}
+	auditLog := NewAuditLog("", true)
+	auditLog.writer = writer
+	auditLog.lines = make(chan []byte, auditPendingRecords)
+	auditLog.done = make(chan struct{})
+	go auditLog.writeLoop()
+
+	// the records are not held up by the file, those beyond the pending ones are dropped from it
+	result := rbacResult{allow: true, reason: reasonNoAllowPolicy}
+	for port := uint32(1); port <= auditPendingRecords+10; port++ {
+		auditLog.record(&rbacConnection{dstPort: port}, &result)
+	}
+	assert.NotZero(t, auditLog.dropped)
+	assert.Len(t, auditLog.Query(AuditFilter{Limit: 1}), 1)
+
+	close(writer.release)
+	assert.NoError(t, auditLog.Close())
+	assert.Equal(t, uint64(auditPendingRecords+10), uint64(writer.writes)+auditLog.dropped)
+}
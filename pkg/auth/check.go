@@ -0,0 +1,131 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+)
+
+// CheckResult explains the decision Rbac makes on a connection with the live policies.
+type CheckResult struct {
+	SrcIp       string `json:"srcIp"`
+	DstIp       string `json:"dstIp"`
+	DstPort     uint32 `json:"dstPort"`
+	SrcIdentity string `json:"srcIdentity,omitempty"`
+	DstWorkload string `json:"dstWorkload,omitempty"`
+	// Enforced is false until the initial sync, the connections are allowed without any policy
+	// evaluated before it
+	Enforced bool   `json:"enforced"`
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+	// Policies are the candidate policies of the destination workload in the order they are
+	// evaluated, followed by the dry-run ones
+	Policies []PolicyCheck `json:"policies"`
+}
+
+type PolicyCheck struct {
+	Policy  string `json:"policy"`
+	Action  string `json:"action"`
+	Matched bool   `json:"matched"`
+	// DryRun is the decision of a dry-run policy, it is empty for an enforced policy
+	DryRun string      `json:"dryRun,omitempty"`
+	Rules  []RuleCheck `json:"rules"`
+}
+
+type RuleCheck struct {
+	Matched bool `json:"matched"`
+	// Clauses holds the index of the match satisfying each clause of the rule, -1 for a clause
+	// without matches and -2 for a clause not satisfied
+	Clauses []int `json:"clauses"`
+}
+
+// Check evaluates the policies against a connection from src to dst, nothing is enforced or
+// recorded in the audit log.
+func (r *Rbac) Check(src netip.Addr, dst netip.AddrPort) CheckResult {
+	conn := rbacConnection{
+		srcIp:   src.Unmap().AsSlice(),
+		dstIp:   dst.Addr().Unmap().AsSlice(),
+		dstPort: uint32(dst.Port()),
+	}
+	conn.srcNetwork = r.networks.of(conn.srcIp)
+	conn.dstNetwork = r.networks.of(conn.dstIp)
+	conn.srcIdentity = r.getIdentityByIp(conn.srcNetwork, conn.srcIp)
+
+	result := r.authorize(&conn)
+	out := CheckResult{
+		SrcIp:    addrString(conn.srcIp),
+		DstIp:    addrString(conn.dstIp),
+		DstPort:  conn.dstPort,
+		Enforced: r.Ready(),
+		Decision: AuditDecisionDeny,
+		Reason:   result.reason,
+		Policies: []PolicyCheck{},
+	}
+	if result.allow {
+		out.Decision = AuditDecisionAllow
+	}
+	// the connections are deferred before the sync, unless the unknown destinations are allowed
+	if !out.Enforced && r.unknownDestinationPolicy == UnknownDestinationAllow {
+		out.Decision = AuditDecisionAllow
+		out.Reason = reasonNotSynced
+	}
+	if conn.srcIdentity != (Identity{}) {
+		out.SrcIdentity = conn.srcIdentity.String()
+	}
+	if result.dstWorkload == nil {
+		return out
+	}
+	out.DstWorkload = result.dstWorkload.GetUid()
+
+	policies := r.policyCache.get(r.policyStore, result.dstWorkload)
+	for _, group := range [][]*compiledPolicy{policies.deny, policies.allow} {
+		for _, policy := range group {
+			out.Policies = append(out.Policies, checkPolicy(&conn, policy))
+		}
+	}
+	for _, dryRun := range result.dryRun {
+		check := checkPolicy(&conn, dryRun.policy)
+		check.DryRun = AuditDecisionDeny
+		if dryRun.allow {
+			check.DryRun = AuditDecisionAllow
+		}
+		out.Policies = append(out.Policies, check)
+	}
+	return out
+}
+
+// checkPolicy matches every clause of the policy, instead of stopping at the first mismatch
+func checkPolicy(conn *rbacConnection, policy *compiledPolicy) PolicyCheck {
+	check := PolicyCheck{
+		Policy: policy.policy.ResourceName(),
+		Action: policy.policy.GetAction().String(),
+		Rules:  []RuleCheck{},
+	}
+	for i := range policy.rules {
+		rule := RuleCheck{Matched: true, Clauses: []int{}}
+		for j := range policy.rules[i].clauses {
+			index := matchClause(conn, &policy.rules[i].clauses[j])
+			rule.Clauses = append(rule.Clauses, index)
+			if index == clauseMismatch {
+				rule.Matched = false
+			}
+		}
+		check.Matched = check.Matched || rule.Matched
+		check.Rules = append(check.Rules, rule)
+	}
+	return check
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestRbac_Check(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:            "cluster0//Pod/default/sleep",
+		Namespace:      "default",
+		ServiceAccount: "sleep",
+		TrustDomain:    "cluster.local",
+		Addresses:      [][]byte{netip.MustParseAddr("192.168.1.1").AsSlice()},
+	})
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := &Rbac{
+		policyStore:   newPolicyStore(),
+		workloadCache: workloadCache,
+	}
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny-8080", 8080)))
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "allow-sleep",
+		Namespace: "default",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{Clauses: []*security.Clause{
+			{Matches: []*security.Match{{
+				Principals: []*security.StringMatch{{MatchType: &security.StringMatch_Exact{Exact: "cluster.local/ns/default/sa/sleep"}}},
+			}}},
+			{Matches: []*security.Match{
+				{DestinationPorts: []uint32{9090}},
+				{DestinationPorts: []uint32{80, 8080}},
+			}},
+		}}},
+	}))
+
+	result := rbac.Check(netip.MustParseAddr("192.168.1.1"), netip.MustParseAddrPort("10.0.0.1:8080"))
+	assert.Equal(t, CheckResult{
+		SrcIp:       "192.168.1.1",
+		DstIp:       "10.0.0.1",
+		DstPort:     8080,
+		SrcIdentity: "spiffe://cluster.local/ns/default/sa/sleep",
+		DstWorkload: "cluster0//Pod/default/reviews",
+		Enforced:    true,
+		Decision:    AuditDecisionDeny,
+		Reason:      reasonDenyPolicy,
+		Policies: []PolicyCheck{
+			{
+				Policy:  "default/deny-8080",
+				Action:  "DENY",
+				Matched: true,
+				Rules:   []RuleCheck{{Matched: true, Clauses: []int{0}}},
+			},
+			{
+				Policy:  "default/allow-sleep",
+				Action:  "ALLOW",
+				Matched: true,
+				Rules:   []RuleCheck{{Matched: true, Clauses: []int{0, 1}}},
+			},
+		},
+	}, result)
+
+	// every clause is evaluated even after a mismatch
+	result = rbac.Check(netip.MustParseAddr("192.168.1.2"), netip.MustParseAddrPort("10.0.0.1:80"))
+	assert.Equal(t, AuditDecisionDeny, result.Decision)
+	assert.Equal(t, reasonNoAllowMatched, result.Reason)
+	assert.Empty(t, result.SrcIdentity)
+	assert.Equal(t, []RuleCheck{{Matched: false, Clauses: []int{clauseMismatch, 1}}}, result.Policies[1].Rules)
+
+	// the dry-run policies come last with the decision they would make
+	rbac.SetDryRunPolicies([]string{"default/deny-8080"})
+	result = rbac.Check(netip.MustParseAddr("192.168.1.1"), netip.MustParseAddrPort("10.0.0.1:8080"))
+	assert.Equal(t, AuditDecisionAllow, result.Decision)
+	assert.Len(t, result.Policies, 2)
+	assert.Equal(t, "default/deny-8080", result.Policies[1].Policy)
+	assert.Equal(t, AuditDecisionDeny, result.Policies[1].DryRun)
+
+	result = rbac.Check(netip.MustParseAddr("192.168.1.1"), netip.MustParseAddrPort("10.0.0.2:80"))
+	assert.Equal(t, AuditDecisionDeny, result.Decision)
+	assert.Equal(t, reasonNoWorkload, result.Reason)
+	assert.Empty(t, result.Policies)
+}
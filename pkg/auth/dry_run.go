@@ -0,0 +1,76 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+// dryRunResult is the decision a dry-run policy would make if it was enforced together with the
+// enforced policies.
+type dryRunResult struct {
+	policy *compiledPolicy
+	allow  bool
+}
+
+// SetDryRunPolicies sets the policies which are evaluated and reported without being enforced,
+// a name is either namespace/name or namespace/* for all the policies in the namespace.
+func (r *Rbac) SetDryRunPolicies(names []string) {
+	r.policyStore.setDryRun(names)
+}
+
+// evaluateDryRun adds the decision of each dry-run policy of the workload to result, it never
+// changes the enforced decision.
+func evaluateDryRun(conn *rbacConnection, policies *workloadPolicies, result *rbacResult) {
+	for _, policy := range policies.dryRun {
+		allow := dryRunDecision(conn, policies, policy, result)
+		result.dryRun = append(result.dryRun, dryRunResult{policy: policy, allow: allow})
+	}
+}
+
+// reportDryRun logs and counts the dry-run decisions on a connection actually made
+func reportDryRun(conn *rbacConnection, result *rbacResult) {
+	for _, dryRun := range result.dryRun {
+		decision := AuditDecisionDeny
+		if dryRun.allow {
+			decision = AuditDecisionAllow
+		}
+		log.Debugf("dry-run policy %s would %s connection: %+v", dryRun.policy.policy.ResourceName(), decision, conn)
+		telemetry.RecordAuthzDryRun(dryRun.policy.policy.ResourceName(), decision)
+	}
+}
+
+func dryRunDecision(conn *rbacConnection, policies *workloadPolicies, policy *compiledPolicy, enforced *rbacResult) bool {
+	_, matched := matches(conn, policy)
+	switch policy.policy.GetAction() {
+	case security.Action_DENY:
+		return enforced.allow && !matched
+	case security.Action_ALLOW:
+		// a deny policy takes precedence over any allow policy
+		if enforced.reason == reasonDenyPolicy {
+			return false
+		}
+		if matched {
+			return true
+		}
+		// the enforced allow policies still allow the connections they match
+		return len(policies.allow) != 0 && enforced.allow
+	default:
+		return enforced.allow
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestRbac_dryRun(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := NewRbac(workloadCache, nil)
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny-8080", 8080)))
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "allow-internal",
+		Namespace: "default",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+			SourceIps: []*security.Address{testAddress("192.168.0.0/16")},
+		}}}}}},
+	}))
+
+	connTo := func(src string, port uint32) *rbacConnection {
+		return &rbacConnection{
+			srcIp:   netip.MustParseAddr(src).AsSlice(),
+			dstIp:   netip.MustParseAddr("10.0.0.1").AsSlice(),
+			dstPort: port,
+		}
+	}
+	dryRunOf := func(result rbacResult) map[string]bool {
+		out := make(map[string]bool)
+		for _, dryRun := range result.dryRun {
+			out[dryRun.policy.policy.ResourceName()] = dryRun.allow
+		}
+		return out
+	}
+
+	// both policies are enforced
+	assert.False(t, rbac.doRbac(connTo("192.168.1.1", 8080)))
+	assert.False(t, rbac.doRbac(connTo("172.16.0.1", 80)))
+
+	rbac.SetDryRunPolicies([]string{"default/*"})
+	tests := []struct {
+		src    string
+		port   uint32
+		dryRun map[string]bool
+	}{
+		{"192.168.1.1", 80, map[string]bool{"default/deny-8080": true, "default/allow-internal": true}},
+		{"192.168.1.1", 8080, map[string]bool{"default/deny-8080": false, "default/allow-internal": true}},
+		{"172.16.0.1", 80, map[string]bool{"default/deny-8080": true, "default/allow-internal": false}},
+	}
+	for _, tt := range tests {
+		// nothing is enforced, the decisions are only reported
+		result := rbac.authorize(connTo(tt.src, tt.port))
+		assert.True(t, result.allow)
+		assert.Equal(t, tt.dryRun, dryRunOf(result), "%s:%d", tt.src, tt.port)
+	}
+
+	// the enforced deny policy takes precedence over the dry-run allow policy
+	rbac.SetDryRunPolicies([]string{"default/allow-internal"})
+	result := rbac.authorize(connTo("192.168.1.1", 8080))
+	assert.False(t, result.allow)
+	assert.Equal(t, map[string]bool{"default/allow-internal": false}, dryRunOf(result))
+}
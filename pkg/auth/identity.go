@@ -0,0 +1,177 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+)
+
+// IdentityVerifyMode decides what to do with a connection to a backend whose identity is not one
+// of the subject alt names of its services.
+type IdentityVerifyMode int
+
+const (
+	IdentityVerifyOff IdentityVerifyMode = iota
+	IdentityVerifyLog
+	IdentityVerifyDeny
+)
+
+// identityWarnInterval is how often a mismatch of the same backend and service is logged
+const identityWarnInterval = time.Minute
+
+var identityVerifyModes = map[string]IdentityVerifyMode{
+	"off":  IdentityVerifyOff,
+	"log":  IdentityVerifyLog,
+	"deny": IdentityVerifyDeny,
+}
+
+func parseIdentityVerifyMode(mode string) (IdentityVerifyMode, error) {
+	if m, ok := identityVerifyModes[strings.ToLower(mode)]; ok {
+		return m, nil
+	}
+	return IdentityVerifyOff, fmt.Errorf("invalid identity verify mode %q, valid values are [off, log, deny]", mode)
+}
+
+// SetIdentityVerifyMode sets the mode by its name, one of off, log and deny.
+func (r *Rbac) SetIdentityVerifyMode(mode string) error {
+	m, err := parseIdentityVerifyMode(mode)
+	if err != nil {
+		return err
+	}
+	r.identityVerifyMode = m
+	return nil
+}
+
+func identityOf(workload *workloadapi.Workload) Identity {
+	return Identity{
+		trustDomain:    workload.GetTrustDomain(),
+		namespace:      workload.GetNamespace(),
+		serviceAccount: workload.GetServiceAccount(),
+	}
+}
+
+// verifyServiceIdentity checks the identity of the destination workload against the subject alt
+// names of the services it backs on the destination port, a service without subject alt names
+// accepts any identity. The service the client connected to is unknown once the connection is
+// redirected to the backend, so the connection is only rejected if none of these services accepts
+// the identity. It returns false only if the connection has to be denied.
+func (r *Rbac) verifyServiceIdentity(conn *rbacConnection, dstWorkload *workloadapi.Workload) bool {
+	if r.identityVerifyMode == IdentityVerifyOff || r.serviceCache == nil {
+		return true
+	}
+
+	rejected, accepted := r.checkServiceIdentity(dstWorkload, conn.dstPort)
+	if len(rejected) == 0 || accepted {
+		return true
+	}
+
+	if r.identityWarns.allow(dstWorkload.GetUid(), time.Now()) {
+		dstIdentity := identityOf(dstWorkload)
+		log.Warnf("identity %s of workload %s is not allowed by the subject alt names of services %v, connection: %+v",
+			dstIdentity.String(), dstWorkload.GetUid(), rejected, conn)
+	}
+	return r.identityVerifyMode != IdentityVerifyDeny
+}
+
+// serviceIdentityVerified reports whether the identity of the workload is accepted by all its
+// services on all the ports, without logging. It is true when the verification is off.
+func (r *Rbac) serviceIdentityVerified(workload *workloadapi.Workload) bool {
+	if r.identityVerifyMode == IdentityVerifyOff || r.serviceCache == nil {
+		return true
+	}
+	rejected, _ := r.checkServiceIdentity(workload, 0)
+	return len(rejected) == 0
+}
+
+// checkServiceIdentity returns the services of the workload exposing the target port whose subject
+// alt names reject its identity, and whether any of them accepts it. A port of 0 matches all the
+// services.
+func (r *Rbac) checkServiceIdentity(workload *workloadapi.Workload, port uint32) (rejected []string, accepted bool) {
+	identity := identityOf(workload)
+	id := identity.String()
+	for serviceName, ports := range workload.GetServices() {
+		service := r.serviceCache.GetService(serviceName)
+		if port != 0 && !exposesTargetPort(service, ports, port) {
+			continue
+		}
+		sans := service.GetSubjectAltNames()
+		if len(sans) == 0 || matchSubjectAltName(id, sans) {
+			accepted = true
+			continue
+		}
+		rejected = append(rejected, serviceName)
+	}
+	return rejected, accepted
+}
+
+// exposesTargetPort reports whether the service forwards one of its ports to the target port of
+// the workload, the ports of the workload override the target ports of the service.
+func exposesTargetPort(service *workloadapi.Service, workloadPorts *workloadapi.PortList, port uint32) bool {
+	for _, p := range workloadPorts.GetPorts() {
+		if p.GetTargetPort() == port {
+			return true
+		}
+	}
+	for _, p := range service.GetPorts() {
+		if p.GetTargetPort() == port {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSubjectAltName reports whether the spiffe id is one of the subject alt names, which may be
+// given with or without the spiffe scheme.
+func matchSubjectAltName(id string, sans []string) bool {
+	id = strings.TrimPrefix(id, SPIFFE_PREFIX)
+	for _, san := range sans {
+		if strings.TrimPrefix(san, SPIFFE_PREFIX) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// logLimiter allows a log of the same key once per identityWarnInterval.
+type logLimiter struct {
+	mutex  sync.Mutex
+	logged map[string]time.Time
+}
+
+func (l *logLimiter) allow(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.logged == nil {
+		l.logged = make(map[string]time.Time)
+	}
+	if last, ok := l.logged[key]; ok && now.Sub(last) < identityWarnInterval {
+		return false
+	}
+	// forget the keys not logged recently, so that the workloads gone are not kept
+	for k, last := range l.logged {
+		if now.Sub(last) >= identityWarnInterval {
+			delete(l.logged, k)
+		}
+	}
+	l.logged[key] = now
+	return true
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestRbac_verifyServiceIdentity(t *testing.T) {
+	workload := &workloadapi.Workload{
+		Uid:            "cluster0//Pod/default/reviews",
+		Namespace:      "default",
+		TrustDomain:    "cluster.local",
+		ServiceAccount: "reviews",
+		Addresses:      [][]byte{{192, 168, 122, 2}},
+		Services: map[string]*workloadapi.PortList{
+			"default/reviews.default.svc.cluster.local": {},
+			"default/metrics.default.svc.cluster.local": {
+				Ports: []*workloadapi.Port{{ServicePort: 80, TargetPort: 15090}, {ServicePort: 81, TargetPort: 15091}},
+			},
+		},
+	}
+	ratings := []string{"spiffe://cluster.local/ns/default/sa/ratings"}
+
+	tests := []struct {
+		name        string
+		sans        []string
+		metricsSans []string
+		port        uint32
+		mode        string
+		want        bool
+		verified    bool
+	}{
+		{"no subject alt names", nil, nil, 9080, "deny", true, true},
+		{"matched", []string{"spiffe://cluster.local/ns/default/sa/reviews"}, nil, 9080, "deny", true, true},
+		{"matched without scheme", []string{"cluster.local/ns/default/sa/reviews"}, nil, 9080, "deny", true, true},
+		{"mismatched and denied", ratings, nil, 9080, "deny", false, false},
+		{"mismatched and logged", ratings, nil, 9080, "log", true, false},
+		{"mismatched and not verified", ratings, nil, 9080, "off", true, true},
+		{"mismatched by a service on another port", nil, ratings, 9080, "deny", true, false},
+		{"mismatched by the only service on the workload port", nil, ratings, 15091, "deny", false, false},
+		{"mismatched by one of the services on the port", ratings, nil, 15090, "deny", true, false},
+		{"not a service port", ratings, ratings, 8080, "deny", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workloadCache := cache.NewWorkloadCache()
+			workloadCache.AddWorkload(workload)
+			serviceCache := cache.NewServiceCache()
+			serviceCache.AddOrUpdateService(&workloadapi.Service{
+				Namespace:       "default",
+				Hostname:        "reviews.default.svc.cluster.local",
+				Ports:           []*workloadapi.Port{{ServicePort: 9080, TargetPort: 9080}, {ServicePort: 15090, TargetPort: 15090}},
+				SubjectAltNames: tt.sans,
+			})
+			serviceCache.AddOrUpdateService(&workloadapi.Service{
+				Namespace:       "default",
+				Hostname:        "metrics.default.svc.cluster.local",
+				Ports:           []*workloadapi.Port{{ServicePort: 80}},
+				SubjectAltNames: tt.metricsSans,
+			})
+
+			rbac := NewRbac(workloadCache, serviceCache)
+			assert.NoError(t, rbac.SetIdentityVerifyMode(tt.mode))
+			conn := &rbacConnection{dstIp: []byte{192, 168, 122, 2}, dstPort: tt.port}
+			assert.Equal(t, tt.want, rbac.doRbac(conn))
+			// the policies are offloaded only if all the services accept the identity
+			assert.Equal(t, tt.verified, rbac.serviceIdentityVerified(workload))
+		})
+	}
+}
+
+func Test_parseIdentityVerifyMode(t *testing.T) {
+	mode, err := parseIdentityVerifyMode("Deny")
+	assert.NoError(t, err)
+	assert.Equal(t, IdentityVerifyDeny, mode)
+
+	_, err = parseIdentityVerifyMode("reject")
+	assert.Error(t, err)
+}
+
+func Test_logLimiter(t *testing.T) {
+	var limiter logLimiter
+	now := time.Now()
+	assert.True(t, limiter.allow("a", now))
+	assert.False(t, limiter.allow("a", now.Add(time.Second)))
+	assert.True(t, limiter.allow("b", now.Add(time.Second)))
+	assert.True(t, limiter.allow("a", now.Add(identityWarnInterval+time.Second)))
+	// the keys not logged within the interval are forgotten
+	assert.NotContains(t, limiter.logged, "b")
+}
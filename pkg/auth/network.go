@@ -0,0 +1,139 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+// networkSubnet is a subnet of an interface attached to a network other than the local one
+type networkSubnet struct {
+	prefix  netip.Prefix
+	network string
+}
+
+// networks resolves the network of the addresses of a connection. An address belongs to the
+// network of the local node, unless it is in a subnet of an interface mapped to another network.
+type networks struct {
+	local string
+	// subnets are sorted by the prefix length, the longest comes first
+	subnets []networkSubnet
+}
+
+func (n *networks) of(ip []byte) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return n.local
+	}
+	addr = addr.Unmap()
+	for _, subnet := range n.subnets {
+		if subnet.prefix.Contains(addr) {
+			return subnet.network
+		}
+	}
+	return n.local
+}
+
+// interfaceSubnets returns the subnets of the addresses assigned to the interface
+var interfaceSubnets = func(name string) ([]netip.Prefix, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var out []netip.Prefix
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		bits, _ := ipNet.Mask.Size()
+		if ip.Is4In6() {
+			ip = ip.Unmap()
+		}
+		out = append(out, netip.PrefixFrom(ip, bits).Masked())
+	}
+	return out, nil
+}
+
+// parseInterfaceNetworks parses the mappings in the form of interface=network.
+func parseInterfaceNetworks(mappings []string) (map[string]string, error) {
+	out := make(map[string]string, len(mappings))
+	for _, mapping := range mappings {
+		iface, network, ok := strings.Cut(mapping, "=")
+		if !ok || iface == "" || network == "" {
+			return nil, fmt.Errorf("invalid interface network %q, should be interface=network", mapping)
+		}
+		out[iface] = network
+	}
+	return out, nil
+}
+
+// SetNetworks sets the network of the local node and the networks the interfaces of the node are
+// attached to in the form of interface=network, the workloads are looked up in the network their
+// addresses belong to.
+func (r *Rbac) SetNetworks(local string, mappings []string) error {
+	interfaceNetworks, err := parseInterfaceNetworks(mappings)
+	if err != nil {
+		return err
+	}
+
+	r.networks.local = local
+	r.networks.subnets = nil
+	for iface, network := range interfaceNetworks {
+		prefixes, err := interfaceSubnets(iface)
+		if err != nil {
+			log.Errorf("get subnets of interface %s failed, err:%s", iface, err)
+			continue
+		}
+		for _, prefix := range prefixes {
+			r.networks.subnets = append(r.networks.subnets, networkSubnet{prefix: prefix, network: network})
+		}
+	}
+	sort.SliceStable(r.networks.subnets, func(i, j int) bool {
+		return r.networks.subnets[i].prefix.Bits() > r.networks.subnets[j].prefix.Bits()
+	})
+	return nil
+}
+
+// getWorkloadByAddr looks up the workload of the address in the network. The workloads of a single
+// network mesh may carry no network at all, so they are looked up without it as a fallback.
+func (r *Rbac) getWorkloadByAddr(network string, ip []byte) *workloadapi.Workload {
+	var networkAddress cache.NetworkAddress
+	networkAddress.Network = network
+	networkAddress.Address, _ = netip.AddrFromSlice(ip)
+	workload := r.workloadCache.GetWorkloadByAddr(networkAddress)
+	if workload != nil || network == "" {
+		return workload
+	}
+	networkAddress.Network = ""
+	return r.workloadCache.GetWorkloadByAddr(networkAddress)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func Test_parseInterfaceNetworks(t *testing.T) {
+	networks, err := parseInterfaceNetworks([]string{"eth1=network2", "eth2=network3"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"eth1": "network2", "eth2": "network3"}, networks)
+
+	for _, mapping := range []string{"eth1", "=network2", "eth1="} {
+		_, err = parseInterfaceNetworks([]string{mapping})
+		assert.Error(t, err, mapping)
+	}
+}
+
+func TestRbac_networkScopedLookup(t *testing.T) {
+	subnets := map[string][]netip.Prefix{
+		"eth1": {netip.MustParsePrefix("10.1.0.0/16")},
+		"eth2": {netip.MustParsePrefix("10.1.2.0/24")},
+	}
+	orig := interfaceSubnets
+	interfaceSubnets = func(name string) ([]netip.Prefix, error) {
+		return subnets[name], nil
+	}
+	defer func() { interfaceSubnets = orig }()
+
+	workloadCache := cache.NewWorkloadCache()
+	// the same address is used in both networks
+	for _, network := range []string{"network1", "network2"} {
+		workloadCache.AddWorkload(&workloadapi.Workload{
+			Uid:            "cluster0//Pod/" + network + "/client",
+			Namespace:      network,
+			ServiceAccount: "client",
+			TrustDomain:    "cluster.local",
+			Network:        network,
+			Addresses:      [][]byte{netip.MustParseAddr("10.1.1.1").AsSlice()},
+		})
+	}
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/server",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := NewRbac(workloadCache, nil)
+	assert.NoError(t, rbac.SetNetworks("network1", []string{"eth1=network2", "eth2=network3", "eth3=network4"}))
+
+	assert.Equal(t, "network1", rbac.networks.of(netip.MustParseAddr("10.0.0.1").AsSlice()))
+	assert.Equal(t, "network2", rbac.networks.of(netip.MustParseAddr("10.1.1.1").AsSlice()))
+	// the longest prefix wins
+	assert.Equal(t, "network3", rbac.networks.of(netip.MustParseAddr("10.1.2.1").AsSlice()))
+
+	var buf bytes.Buffer
+	assert.NoError(t, binary.Write(&buf, binary.BigEndian, bpfSockTupleV4{
+		SrcAddr: binary.BigEndian.Uint32(netip.MustParseAddr("10.1.1.1").AsSlice()),
+		DstAddr: binary.BigEndian.Uint32(netip.MustParseAddr("10.0.0.1").AsSlice()),
+		SrcPort: 40000,
+		DstPort: 80,
+	}))
+	conn, err := rbac.buildConnV4(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "network2", conn.srcNetwork)
+	assert.Equal(t, "network1", conn.dstNetwork)
+	assert.Equal(t, "network2", conn.srcIdentity.namespace)
+
+	// the workloads without a network are still found
+	result := rbac.authorize(&conn)
+	assert.True(t, result.allow)
+	assert.Equal(t, "cluster0//Pod/default/server", result.dstWorkload.GetUid())
+
+	// the address is unknown in network3
+	assert.Nil(t, rbac.getWorkloadByAddr("network3", netip.MustParseAddr("10.1.1.1").AsSlice()))
+}
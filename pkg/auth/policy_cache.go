@@ -0,0 +1,63 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"sync"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+)
+
+// maxPolicyCacheSize bounds the entries left by removed workloads, the cache is dropped at once
+// when it is reached.
+const maxPolicyCacheSize = 65536
+
+// workloadPolicies are the compiled policies applied to a destination workload
+type workloadPolicies struct {
+	// workload is the object the entry is built from, the workload cache replaces the object on
+	// every update, so a different pointer means the workload changed
+	workload   *workloadapi.Workload
+	generation uint64
+	allow      []*compiledPolicy
+	deny       []*compiledPolicy
+	// dryRun are evaluated and reported, but never decide a connection
+	dryRun []*compiledPolicy
+}
+
+// policyCache caches the policies of each destination workload, keyed by workload uid. An entry
+// is valid until the workload or any policy changes.
+type policyCache struct {
+	mutex   sync.Mutex
+	entries map[string]*workloadPolicies
+}
+
+func (c *policyCache) get(ps *policyStore, workload *workloadapi.Workload) *workloadPolicies {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	uid := workload.GetUid()
+	if entry, ok := c.entries[uid]; ok && entry.workload == workload && entry.generation == ps.generation.Load() {
+		return entry
+	}
+
+	entry := ps.aggregate(workload)
+	if c.entries == nil || len(c.entries) >= maxPolicyCacheSize {
+		c.entries = make(map[string]*workloadPolicies)
+	}
+	c.entries[uid] = entry
+	return entry
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"strings"
+
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+)
+
+// compiledPolicy is the form of an authorization policy matched against connections, the
+// addresses are kept in prefix tries and the ports in sets, so nothing is parsed per connection.
+type compiledPolicy struct {
+	policy *security.Authorization
+	rules  []compiledRule
+}
+
+type compiledRule struct {
+	clauses []compiledClause
+}
+
+type compiledClause struct {
+	// matchAll is set for a clause without any match, the empty matches are dropped on compiling
+	matchAll bool
+	matches  []*compiledMatch
+}
+
+// compiledMatch leaves a field nil when it is not set in the policy
+type compiledMatch struct {
+	// index is the position of the match in its clause of the policy
+	index         int
+	dstIps        *ipTrie
+	notDstIps     *ipTrie
+	srcIps        *ipTrie
+	notSrcIps     *ipTrie
+	dstPorts      map[uint32]struct{}
+	notDstPorts   map[uint32]struct{}
+	principals    []*security.StringMatch
+	notPrincipals []*security.StringMatch
+	namespaces    []*security.StringMatch
+	notNamespaces []*security.StringMatch
+}
+
+func compilePolicy(policy *security.Authorization) *compiledPolicy {
+	cp := &compiledPolicy{policy: policy}
+	for _, rule := range policy.GetRules() {
+		var cr compiledRule
+		for _, clause := range rule.GetClauses() {
+			cc := compiledClause{matchAll: len(clause.GetMatches()) == 0}
+			for i, match := range clause.GetMatches() {
+				if isEmptyMatch(match) {
+					continue
+				}
+				cm := compileMatch(match)
+				cm.index = i
+				cc.matches = append(cc.matches, cm)
+			}
+			cr.clauses = append(cr.clauses, cc)
+		}
+		cp.rules = append(cp.rules, cr)
+	}
+	return cp
+}
+
+func compileMatch(match *security.Match) *compiledMatch {
+	return &compiledMatch{
+		dstIps:        newIpTrie(match.GetDestinationIps()),
+		notDstIps:     newIpTrie(match.GetNotDestinationIps()),
+		srcIps:        newIpTrie(match.GetSourceIps()),
+		notSrcIps:     newIpTrie(match.GetNotSourceIps()),
+		dstPorts:      newPortSet(match.GetDestinationPorts()),
+		notDstPorts:   newPortSet(match.GetNotDestinationPorts()),
+		principals:    match.GetPrincipals(),
+		notPrincipals: match.GetNotPrincipals(),
+		namespaces:    match.GetNamespaces(),
+		notNamespaces: match.GetNotNamespaces(),
+	}
+}
+
+func matchString(value string, match *security.StringMatch) bool {
+	switch t := match.GetMatchType().(type) {
+	case *security.StringMatch_Exact:
+		return value == t.Exact
+	case *security.StringMatch_Prefix:
+		return strings.HasPrefix(value, t.Prefix)
+	case *security.StringMatch_Suffix:
+		return strings.HasSuffix(value, t.Suffix)
+	case *security.StringMatch_Presence:
+		return value != ""
+	default:
+		// a match without any type only matches the empty value
+		return value == ""
+	}
+}
+
+// matchStrings returns true if ANY of the matches matches the value
+func matchStrings(value string, matches []*security.StringMatch) bool {
+	for _, match := range matches {
+		if matchString(value, match) {
+			return true
+		}
+	}
+	return false
+}
+
+func newPortSet(ports []uint32) map[uint32]struct{} {
+	if len(ports) == 0 {
+		return nil
+	}
+	set := make(map[uint32]struct{}, len(ports))
+	for _, port := range ports {
+		set[port] = struct{}{}
+	}
+	return set
+}
+
+// ipTrie is a binary trie of CIDRs, IPv4 and IPv6 prefixes are kept in separate roots. An IPv4
+// mapped IPv6 address is taken as IPv4 on both insertion and lookup.
+type ipTrie struct {
+	roots [2]*ipTrieNode
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	// end marks the last bit of a prefix, every address below it is contained
+	end bool
+}
+
+// newIpTrie returns nil for an empty address list, the invalid addresses are skipped.
+func newIpTrie(addresses []*security.Address) *ipTrie {
+	if len(addresses) == 0 {
+		return nil
+	}
+	t := &ipTrie{}
+	for _, addr := range addresses {
+		t.insert(addr.GetAddress(), addr.GetLength())
+	}
+	return t
+}
+
+func ipTrieKey(ip []byte) ([]byte, int, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil, 0, false
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.AsSlice(), 0, true
+	}
+	return addr.AsSlice(), 1, true
+}
+
+func (t *ipTrie) insert(ip []byte, length uint32) {
+	key, family, ok := ipTrieKey(ip)
+	if !ok || length > uint32(len(key)*8) {
+		return
+	}
+
+	if t.roots[family] == nil {
+		t.roots[family] = &ipTrieNode{}
+	}
+	node := t.roots[family]
+	for i := uint32(0); i < length; i++ {
+		bit := (key[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.end = true
+}
+
+func (t *ipTrie) contains(ip []byte) bool {
+	key, family, ok := ipTrieKey(ip)
+	if !ok {
+		return false
+	}
+
+	node := t.roots[family]
+	for i := 0; node != nil; i++ {
+		if node.end {
+			return true
+		}
+		if i == len(key)*8 {
+			break
+		}
+		node = node.children[(key[i/8]>>(7-i%8))&1]
+	}
+	return false
+}
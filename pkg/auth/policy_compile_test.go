@@ -0,0 +1,162 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestIpTrie(t *testing.T) {
+	trie := newIpTrie([]*security.Address{
+		{Address: netip.MustParseAddr("192.168.0.0").AsSlice(), Length: 16},
+		{Address: netip.MustParseAddr("10.0.0.1").AsSlice(), Length: 32},
+		{Address: netip.MustParseAddr("fd00::").AsSlice(), Length: 8},
+		// invalid prefix length, skipped
+		{Address: netip.MustParseAddr("172.16.0.0").AsSlice(), Length: 33},
+	})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.2", true},
+		{"192.169.1.2", false},
+		{"10.0.0.1", true},
+		{"10.0.0.2", false},
+		{"::ffff:192.168.1.2", true},
+		{"fd12::1", true},
+		{"fe80::1", false},
+		{"172.16.0.1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, trie.contains(netip.MustParseAddr(tt.ip).AsSlice()), tt.ip)
+	}
+
+	assert.Nil(t, newIpTrie(nil))
+	all := newIpTrie([]*security.Address{{Address: netip.MustParseAddr("0.0.0.0").AsSlice(), Length: 0}})
+	assert.True(t, all.contains(netip.MustParseAddr("8.8.8.8").AsSlice()))
+	assert.False(t, all.contains(netip.MustParseAddr("::1").AsSlice()))
+}
+
+func newTestDenyPolicy(name string, port uint32) *security.Authorization {
+	return &security.Authorization{
+		Name:      name,
+		Namespace: "default",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{{DestinationPorts: []uint32{port}}},
+			}},
+		}},
+	}
+}
+
+func TestRbac_policyCacheInvalidation(t *testing.T) {
+	workload := &workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{{192, 168, 122, 2}},
+	}
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(workload)
+	rbac := NewRbac(workloadCache, nil)
+	conn := &rbacConnection{
+		srcIdentity: Identity{trustDomain: "cluster.local", namespace: "default", serviceAccount: "sleep"},
+		dstIp:       []byte{192, 168, 122, 2},
+		dstPort:     8080,
+	}
+	assert.True(t, rbac.doRbac(conn))
+
+	// 1. a new policy takes effect on the cached workload
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny", 8080)))
+	assert.False(t, rbac.doRbac(conn))
+
+	// 2. an updated policy is compiled again
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny", 9090)))
+	assert.True(t, rbac.doRbac(conn))
+
+	// 3. the workload moved to another namespace, the namespace policy no longer applies
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny", 8080)))
+	assert.False(t, rbac.doRbac(conn))
+	moved := proto.Clone(workload).(*workloadapi.Workload)
+	moved.Namespace = "other"
+	workloadCache.AddWorkload(moved)
+	assert.True(t, rbac.doRbac(conn))
+
+	// 4. the policy is removed
+	workloadCache.AddWorkload(workload)
+	assert.False(t, rbac.doRbac(conn))
+	rbac.RemovePolicy("default/deny")
+	assert.True(t, rbac.doRbac(conn))
+}
+
+func BenchmarkRbac_doRbac(b *testing.B) {
+	workload := &workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{{192, 168, 122, 2}},
+	}
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(workload)
+	rbac := NewRbac(workloadCache, nil)
+
+	// a typical allow policy with a few CIDRs and ports, none of them matches the connection
+	var sourceIps []*security.Address
+	for i := 0; i < 16; i++ {
+		sourceIps = append(sourceIps, &security.Address{Address: []byte{10, byte(i), 0, 0}, Length: 16})
+	}
+	for i := 0; i < 8; i++ {
+		policy := &security.Authorization{
+			Name:      "allow-" + string(rune('a'+i)),
+			Namespace: "default",
+			Scope:     security.Scope_NAMESPACE,
+			Action:    security.Action_ALLOW,
+			Rules: []*security.Rule{{
+				Clauses: []*security.Clause{{
+					Matches: []*security.Match{{
+						SourceIps:        sourceIps,
+						DestinationPorts: []uint32{80, 443, 8080, 9090},
+					}},
+				}},
+			}},
+		}
+		if err := rbac.UpdatePolicy(policy); err != nil {
+			b.Fatal(err)
+		}
+	}
+	conn := &rbacConnection{
+		srcIdentity: Identity{trustDomain: "cluster.local", namespace: "default", serviceAccount: "sleep"},
+		srcIp:       []byte{192, 168, 122, 3},
+		dstIp:       []byte{192, 168, 122, 2},
+		dstPort:     8080,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rbac.doRbac(conn)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+)
+
+// PolicyDump is a policy in the policy store and the workloads it is attached to.
+type PolicyDump struct {
+	Policy *security.Authorization
+	// Workloads are the uids of the workloads referring to a workload selector policy, the global
+	// and namespace policies are attached through the namespace index instead
+	Workloads []string
+	DryRun    bool
+}
+
+// DumpPolicies returns every policy in the policy store ordered by namespace/name.
+func (r *Rbac) DumpPolicies() []PolicyDump {
+	if r == nil {
+		return nil
+	}
+
+	selectedBy := make(map[string][]string)
+	if r.workloadCache != nil {
+		for _, workload := range r.workloadCache.List() {
+			for _, name := range workload.GetAuthorizationPolicies() {
+				selectedBy[name] = append(selectedBy[name], workload.GetUid())
+			}
+		}
+	}
+
+	ps := r.policyStore
+	ps.rwLock.RLock()
+	defer ps.rwLock.RUnlock()
+
+	out := make([]PolicyDump, 0, len(ps.byKey))
+	for key, policy := range ps.byKey {
+		dump := PolicyDump{Policy: policy, DryRun: ps.isDryRun(policy)}
+		if policy.GetScope() == security.Scope_WORKLOAD_SELECTOR {
+			dump.Workloads = selectedBy[key]
+			slices.Sort(dump.Workloads)
+		}
+		out = append(out, dump)
+	}
+	slices.SortFunc(out, func(a, b PolicyDump) int {
+		return strings.Compare(a.Policy.ResourceName(), b.Policy.ResourceName())
+	})
+	return out
+}
+
+// PolicyNamesOf returns the names of the policies applied to the workload, from the workload
+// itself, its namespace and the root namespace.
+func (r *Rbac) PolicyNamesOf(workload *workloadapi.Workload) []string {
+	if r == nil {
+		return nil
+	}
+	r.policyStore.rwLock.RLock()
+	defer r.policyStore.rwLock.RUnlock()
+	return r.policyStore.policyNamesOf(workload)
+}
@@ -0,0 +1,465 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"errors"
+	"net/netip"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/nets"
+)
+
+const (
+	AUTHZ_ACTION_ALLOW = uint32(0)
+	AUTHZ_ACTION_DENY  = uint32(1)
+	// AUTHZ_RULE_PREFIX_LEN is the number of bits before SrcAddr in authzRuleKey, the LPM trie
+	// always matches them in full
+	AUTHZ_RULE_PREFIX_LEN = uint32(unsafe.Offsetof(authzRuleKey{}.SrcAddr)-unsafe.Sizeof(authzRuleKey{}.PrefixLen)) * 8
+	// maxOffloadTerms bounds the rules of one action on one address of a workload, the policies
+	// expanding to more are left to userspace
+	maxOffloadTerms = 1024
+)
+
+// authzKey mirrors authz_key of the map_of_authz bpf map
+type authzKey struct {
+	Addr [16]byte
+}
+
+// authzValue mirrors authz_value of the map_of_authz bpf map
+type authzValue struct {
+	HasAllow uint32
+}
+
+// authzRuleKey mirrors authz_rule_key of the map_of_authz_rule bpf map
+type authzRuleKey struct {
+	PrefixLen uint32
+	DstAddr   [16]byte
+	Action    uint32
+	// DstPort is in host byte order, 0 matches any port
+	DstPort uint32
+	SrcAddr [16]byte
+}
+
+// authzTerm is a source prefix and destination port pair a policy matches, an invalid prefix
+// matches any source and port 0 matches any port.
+type authzTerm struct {
+	src  netip.Prefix
+	port uint32
+}
+
+// policyMaps offloads the authorization policies of the local workloads into the bpf maps, so
+// that the sockops prog decides the connections to them without a round trip to userspace.
+//
+// Only the policies made of addresses and ports are offloaded. A workload with any policy that
+// needs the identity of the source is kept out of map_of_authz, and its connections are still
+// sent to Rbac.Run through the ringbuf.
+type policyMaps struct {
+	authzMap     *ebpf.Map
+	authzRuleMap *ebpf.Map
+	// workloads records the entries written for each workload uid
+	workloads map[string]*offloadedWorkload
+	// stale holds the entries left by the last run until the first sync, it is nil after it
+	stale *staleEntries
+}
+
+type staleEntries struct {
+	keys  map[authzKey]struct{}
+	rules map[authzRuleKey]struct{}
+}
+
+type offloadedWorkload struct {
+	// policies and verified are the state the entries are built from
+	policies *workloadPolicies
+	verified bool
+	keys     []authzKey
+	rules    []authzRuleKey
+}
+
+// SetPolicyMaps enables the offloading of the policies into the bpf maps. The entries left by the
+// last run keep deciding the connections in kernel until the first SyncPolicyMaps, which deletes
+// the ones it did not write again, so that a restart does not leave the workloads unprotected.
+func (r *Rbac) SetPolicyMaps(authzMap, authzRuleMap *ebpf.Map) {
+	if authzMap == nil || authzRuleMap == nil {
+		log.Warn("authz bpf maps are nil, authorization policies are not offloaded")
+		return
+	}
+	r.policyMaps = policyMaps{
+		authzMap:     authzMap,
+		authzRuleMap: authzRuleMap,
+		workloads:    make(map[string]*offloadedWorkload),
+		stale: &staleEntries{
+			keys:  make(map[authzKey]struct{}),
+			rules: make(map[authzRuleKey]struct{}),
+		},
+	}
+
+	var (
+		key     authzKey
+		ruleKey authzRuleKey
+		value   authzValue
+		rule    uint32
+	)
+	iter := authzMap.Iterate()
+	for iter.Next(&key, &value) {
+		r.policyMaps.stale.keys[key] = struct{}{}
+	}
+	iter = authzRuleMap.Iterate()
+	for iter.Next(&ruleKey, &rule) {
+		r.policyMaps.stale.rules[ruleKey] = struct{}{}
+	}
+
+	if r.auditLog.writesFile() {
+		r.disablePolicyMaps()
+	}
+}
+
+// disablePolicyMaps deletes all the entries of the bpf maps, including the ones left by the last
+// run, and stops the offloading, so that every connection is decided by Rbac.Run.
+func (r *Rbac) disablePolicyMaps() {
+	if r.policyMaps.authzMap == nil {
+		return
+	}
+	log.Info("authorization audit log is written, authorization policies are not offloaded")
+	for uid := range r.policyMaps.workloads {
+		r.policyMaps.remove(uid)
+	}
+	if r.policyMaps.stale != nil {
+		r.policyMaps.deleteStale()
+	}
+	r.policyMaps = policyMaps{}
+}
+
+// SyncPolicyMaps brings the bpf maps up to date with the local workloads and their policies, it
+// is called after each xDS response is handled.
+func (r *Rbac) SyncPolicyMaps() {
+	if r == nil || r.policyMaps.authzMap == nil {
+		return
+	}
+
+	seen := make(map[string]struct{})
+	for _, workload := range r.workloadCache.List() {
+		if r.nodeName != "" && workload.GetNode() != r.nodeName {
+			continue
+		}
+		uid := workload.GetUid()
+		seen[uid] = struct{}{}
+
+		policies := r.policyCache.get(r.policyStore, workload)
+		verified := r.serviceIdentityVerified(workload)
+		if old, ok := r.policyMaps.workloads[uid]; ok && old.policies == policies && old.verified == verified {
+			continue
+		}
+
+		r.policyMaps.remove(uid)
+		entry := &offloadedWorkload{policies: policies, verified: verified}
+		// a workload failing the identity verification is left to userspace, which logs or
+		// denies each connection to it
+		if verified {
+			entry.keys, entry.rules = offloadEntries(workload, policies)
+		}
+		if err := r.policyMaps.store(entry); err != nil {
+			log.Errorf("offload authorization policies of workload %s failed, err:%s", uid, err)
+			r.policyMaps.deleteEntries(entry.keys, entry.rules)
+			entry.keys, entry.rules = nil, nil
+		}
+		r.policyMaps.workloads[uid] = entry
+	}
+
+	for uid := range r.policyMaps.workloads {
+		if _, ok := seen[uid]; !ok {
+			r.policyMaps.remove(uid)
+		}
+	}
+
+	if r.policyMaps.stale != nil {
+		r.policyMaps.deleteStale()
+	}
+}
+
+// deleteStale deletes the entries of the last run which are not written by the first sync
+func (m *policyMaps) deleteStale() {
+	for _, entry := range m.workloads {
+		for i := range entry.keys {
+			delete(m.stale.keys, entry.keys[i])
+		}
+		for i := range entry.rules {
+			delete(m.stale.rules, entry.rules[i])
+		}
+	}
+
+	keys := make([]authzKey, 0, len(m.stale.keys))
+	for key := range m.stale.keys {
+		keys = append(keys, key)
+	}
+	rules := make([]authzRuleKey, 0, len(m.stale.rules))
+	for rule := range m.stale.rules {
+		rules = append(rules, rule)
+	}
+	m.deleteEntries(keys, rules)
+	m.stale = nil
+}
+
+// store writes the rules before the workload keys, so a workload is never decided in kernel with
+// a partial rule set.
+func (m *policyMaps) store(entry *offloadedWorkload) error {
+	for i := range entry.rules {
+		if err := m.authzRuleMap.Update(&entry.rules[i], uint32(0), ebpf.UpdateAny); err != nil {
+			return err
+		}
+	}
+	value := authzValue{}
+	if len(entry.policies.allow) != 0 {
+		value.HasAllow = 1
+	}
+	for i := range entry.keys {
+		if err := m.authzMap.Update(&entry.keys[i], &value, ebpf.UpdateAny); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *policyMaps) remove(uid string) {
+	entry, ok := m.workloads[uid]
+	if !ok {
+		return
+	}
+	delete(m.workloads, uid)
+	m.deleteEntries(entry.keys, entry.rules)
+}
+
+// deleteEntries deletes the workload keys before the rules, the reverse order of store
+func (m *policyMaps) deleteEntries(keys []authzKey, rules []authzRuleKey) {
+	for i := range keys {
+		if err := m.authzMap.Delete(&keys[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Errorf("delete authz entry %v failed, err:%s", keys[i].Addr, err)
+		}
+	}
+	for i := range rules {
+		if err := m.authzRuleMap.Delete(&rules[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Errorf("delete authz rule entry %+v failed, err:%s", rules[i], err)
+		}
+	}
+}
+
+// offloadEntries returns the map entries of the workload, or nothing if any of its policies can
+// not be decided in kernel.
+func offloadEntries(workload *workloadapi.Workload, policies *workloadPolicies) ([]authzKey, []authzRuleKey) {
+	var (
+		keys  []authzKey
+		rules []authzRuleKey
+	)
+	// the dry-run policies are only evaluated in userspace
+	if len(policies.dryRun) != 0 {
+		return nil, nil
+	}
+	for _, ip := range workload.GetAddresses() {
+		dst, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		dst = dst.Unmap()
+
+		key := authzKey{}
+		nets.CopyIpByteFromSlice(&key.Addr, dst.AsSlice())
+		allow, allowOk := offloadTerms(policies.allow, dst)
+		deny, denyOk := offloadTerms(policies.deny, dst)
+		if !allowOk || !denyOk {
+			log.Debugf("authorization policies of workload %s are not offloaded", workload.GetUid())
+			return nil, nil
+		}
+		for _, term := range allow {
+			rules = append(rules, newAuthzRuleKey(key.Addr, AUTHZ_ACTION_ALLOW, term))
+		}
+		for _, term := range deny {
+			rules = append(rules, newAuthzRuleKey(key.Addr, AUTHZ_ACTION_DENY, term))
+		}
+		keys = append(keys, key)
+	}
+	return keys, rules
+}
+
+func newAuthzRuleKey(dstAddr [16]byte, action uint32, term authzTerm) authzRuleKey {
+	ruleKey := authzRuleKey{
+		PrefixLen: AUTHZ_RULE_PREFIX_LEN,
+		DstAddr:   dstAddr,
+		Action:    action,
+		DstPort:   term.port,
+	}
+	if term.src.IsValid() {
+		ruleKey.PrefixLen += uint32(term.src.Bits())
+		nets.CopyIpByteFromSlice(&ruleKey.SrcAddr, term.src.Addr().AsSlice())
+	}
+	return ruleKey
+}
+
+// offloadTerms expands the policies into the terms matched by connections to dst, in the same
+// way matches does. ok is false if any policy needs more than addresses and ports.
+func offloadTerms(policies []*compiledPolicy, dst netip.Addr) ([]authzTerm, bool) {
+	var terms []authzTerm
+	for _, policy := range policies {
+		for _, rule := range policy.policy.GetRules() {
+			// a rule without clauses matches everything
+			ruleTerms := []authzTerm{{}}
+			for _, clause := range rule.GetClauses() {
+				var clauseTerms []authzTerm
+				if len(clause.GetMatches()) == 0 {
+					clauseTerms = []authzTerm{{}}
+				}
+				for _, match := range clause.GetMatches() {
+					if isEmptyMatch(match) {
+						continue
+					}
+					matchTerms, ok := offloadMatchTerms(match, dst)
+					if !ok {
+						return nil, false
+					}
+					clauseTerms = append(clauseTerms, matchTerms...)
+				}
+				ruleTerms = intersectTerms(ruleTerms, clauseTerms)
+				if len(ruleTerms) > maxOffloadTerms {
+					return nil, false
+				}
+			}
+			terms = append(terms, ruleTerms...)
+			if len(terms) > maxOffloadTerms {
+				return nil, false
+			}
+		}
+	}
+	return dedupTerms(terms), true
+}
+
+func offloadMatchTerms(match *security.Match, dst netip.Addr) ([]authzTerm, bool) {
+	// the identity of the source and the negative source addresses and ports are left to userspace
+	if match.GetPrincipals() != nil || match.GetNotPrincipals() != nil ||
+		match.GetNamespaces() != nil || match.GetNotNamespaces() != nil ||
+		match.GetNotSourceIps() != nil || match.GetNotDestinationPorts() != nil {
+		return nil, false
+	}
+
+	// the destination is known, the destination addresses are decided here
+	if match.GetDestinationIps() != nil && !containsAddr(match.GetDestinationIps(), dst) {
+		return nil, true
+	}
+	if match.GetNotDestinationIps() != nil && containsAddr(match.GetNotDestinationIps(), dst) {
+		return nil, true
+	}
+
+	srcs := []netip.Prefix{{}}
+	if match.GetSourceIps() != nil {
+		srcs = nil
+		for _, address := range match.GetSourceIps() {
+			// a prefix of the other family never matches
+			if prefix, ok := toPrefix(address); ok && prefix.Addr().Is4() == dst.Is4() {
+				srcs = append(srcs, prefix)
+			}
+		}
+	}
+	ports := []uint32{0}
+	if match.GetDestinationPorts() != nil {
+		ports = match.GetDestinationPorts()
+	}
+
+	terms := make([]authzTerm, 0, len(srcs)*len(ports))
+	for _, src := range srcs {
+		for _, port := range ports {
+			terms = append(terms, authzTerm{src: src, port: port})
+		}
+	}
+	return terms, true
+}
+
+// toPrefix converts the address the way ipTrie does, an IPv4 mapped prefix is taken as IPv4.
+func toPrefix(address *security.Address) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(address.GetAddress())
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	bits := int(address.GetLength())
+	if addr.Is4In6() {
+		if bits < 96 {
+			return netip.Prefix{}, false
+		}
+		addr, bits = addr.Unmap(), bits-96
+	}
+	prefix, err := addr.Prefix(bits)
+	return prefix, err == nil
+}
+
+func containsAddr(addresses []*security.Address, ip netip.Addr) bool {
+	for _, address := range addresses {
+		if prefix, ok := toPrefix(address); ok && prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectTerms returns the terms matched by both a and b. Two prefixes either nest or are
+// disjoint, so the intersection of two terms is a term or nothing.
+func intersectTerms(a, b []authzTerm) []authzTerm {
+	var out []authzTerm
+	for _, x := range a {
+		for _, y := range b {
+			if term, ok := intersectTerm(x, y); ok {
+				out = append(out, term)
+			}
+		}
+	}
+	return out
+}
+
+func intersectTerm(x, y authzTerm) (authzTerm, bool) {
+	term := x
+	switch {
+	case !y.src.IsValid():
+	case !x.src.IsValid():
+		term.src = y.src
+	case !x.src.Overlaps(y.src):
+		return term, false
+	case y.src.Bits() > x.src.Bits():
+		term.src = y.src
+	}
+
+	switch {
+	case y.port == 0:
+	case x.port == 0:
+		term.port = y.port
+	case x.port != y.port:
+		return term, false
+	}
+	return term, true
+}
+
+func dedupTerms(terms []authzTerm) []authzTerm {
+	seen := make(map[authzTerm]struct{}, len(terms))
+	out := terms[:0]
+	for _, term := range terms {
+		if _, ok := seen[term]; ok {
+			continue
+		}
+		seen[term] = struct{}{}
+		out = append(out, term)
+	}
+	return out
+}
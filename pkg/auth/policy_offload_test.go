@@ -0,0 +1,350 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func testAddress(prefix string) *security.Address {
+	p := netip.MustParsePrefix(prefix)
+	return &security.Address{Address: p.Addr().AsSlice(), Length: uint32(p.Bits())}
+}
+
+func testTerm(src string, port uint32) authzTerm {
+	term := authzTerm{port: port}
+	if src != "" {
+		term.src = netip.MustParsePrefix(src)
+	}
+	return term
+}
+
+func Test_offloadTerms(t *testing.T) {
+	dst := netip.MustParseAddr("10.0.0.1")
+	tests := []struct {
+		name   string
+		rules  []*security.Rule
+		want   []authzTerm
+		wantOk bool
+	}{
+		{
+			name:   "policy without rules matches nothing",
+			wantOk: true,
+		},
+		{
+			name:   "rule without clauses matches everything",
+			rules:  []*security.Rule{{}},
+			want:   []authzTerm{testTerm("", 0)},
+			wantOk: true,
+		},
+		{
+			name: "sources and ports of a match are expanded",
+			rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+				SourceIps:        []*security.Address{testAddress("192.168.0.0/16"), testAddress("fd00::/8")},
+				DestinationPorts: []uint32{80, 443},
+			}}}}}},
+			want:   []authzTerm{testTerm("192.168.0.0/16", 80), testTerm("192.168.0.0/16", 443)},
+			wantOk: true,
+		},
+		{
+			name: "clauses are intersected",
+			rules: []*security.Rule{{Clauses: []*security.Clause{
+				{Matches: []*security.Match{
+					{SourceIps: []*security.Address{testAddress("192.168.0.0/16")}},
+					{SourceIps: []*security.Address{testAddress("172.16.0.0/12")}},
+				}},
+				{Matches: []*security.Match{
+					{SourceIps: []*security.Address{testAddress("192.168.1.0/24")}, DestinationPorts: []uint32{80}},
+				}},
+			}}},
+			want:   []authzTerm{testTerm("192.168.1.0/24", 80)},
+			wantOk: true,
+		},
+		{
+			name: "destination addresses are decided on compiling",
+			rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{
+				{DestinationIps: []*security.Address{testAddress("10.0.1.0/24")}, DestinationPorts: []uint32{80}},
+				{NotDestinationIps: []*security.Address{testAddress("10.0.1.0/24")}, DestinationPorts: []uint32{8080}},
+			}}}}},
+			want:   []authzTerm{testTerm("", 8080)},
+			wantOk: true,
+		},
+		{
+			name: "principals are left to userspace",
+			rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+				Principals: []*security.StringMatch{{MatchType: &security.StringMatch_Exact{Exact: "cluster.local/ns/default/sa/sleep"}}},
+			}}}}}},
+			wantOk: false,
+		},
+		{
+			name: "negative source addresses are left to userspace",
+			rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+				NotSourceIps: []*security.Address{testAddress("192.168.0.0/16")},
+			}}}}}},
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &compiledPolicy{policy: &security.Authorization{Rules: tt.rules}}
+			got, ok := offloadTerms([]*compiledPolicy{policy}, dst)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func newTestPolicyMaps(t *testing.T) (*ebpf.Map, *ebpf.Map) {
+	authzMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_authz",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(unsafe.Sizeof(authzKey{})),
+		ValueSize:  uint32(unsafe.Sizeof(authzValue{})),
+		MaxEntries: 16,
+		Flags:      0x1, // BPF_F_NO_PREALLOC
+	})
+	assert.NoError(t, err)
+	authzRuleMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_authz_rule",
+		Type:       ebpf.LPMTrie,
+		KeySize:    uint32(unsafe.Sizeof(authzRuleKey{})),
+		ValueSize:  4,
+		MaxEntries: 16,
+		Flags:      0x1,
+	})
+	assert.NoError(t, err)
+	return authzMap, authzRuleMap
+}
+
+// lookupVerdict decides the connection the way the sockops prog does, ok is false if the workload
+// is left to userspace.
+func lookupVerdict(authzMap, authzRuleMap *ebpf.Map, src, dst string, port uint32) (allow, ok bool) {
+	var (
+		key   authzKey
+		value authzValue
+		rule  uint32
+	)
+	copy(key.Addr[:], netip.MustParseAddr(dst).AsSlice())
+	if authzMap.Lookup(&key, &value) != nil {
+		return false, false
+	}
+
+	ruleKey := authzRuleKey{PrefixLen: AUTHZ_RULE_PREFIX_LEN + 128, DstAddr: key.Addr}
+	copy(ruleKey.SrcAddr[:], netip.MustParseAddr(src).AsSlice())
+	matched := func(action uint32) bool {
+		ruleKey.Action = action
+		for _, p := range []uint32{port, 0} {
+			ruleKey.DstPort = p
+			if authzRuleMap.Lookup(&ruleKey, &rule) == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	if matched(AUTHZ_ACTION_DENY) {
+		return false, true
+	}
+	return value.HasAllow == 0 || matched(AUTHZ_ACTION_ALLOW), true
+}
+
+func TestRbac_SyncPolicyMaps(t *testing.T) {
+	authzMap, authzRuleMap := newTestPolicyMaps(t)
+	defer authzMap.Close()
+	defer authzRuleMap.Close()
+
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := NewRbac(workloadCache, nil)
+	rbac.nodeName = ""
+	rbac.SetPolicyMaps(authzMap, authzRuleMap)
+
+	// no policy, every connection is allowed in kernel
+	rbac.SyncPolicyMaps()
+	allow, ok := lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 80)
+	assert.True(t, ok)
+	assert.True(t, allow)
+
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "allow-internal",
+		Namespace: "default",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+			SourceIps: []*security.Address{testAddress("192.168.0.0/16")},
+		}}}}}},
+	}))
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny-8080", 8080)))
+	rbac.SyncPolicyMaps()
+
+	tests := []struct {
+		src   string
+		port  uint32
+		allow bool
+	}{
+		{"192.168.1.1", 80, true},
+		{"192.168.1.1", 8080, false},
+		{"172.16.0.1", 80, false},
+	}
+	for _, tt := range tests {
+		allow, ok = lookupVerdict(authzMap, authzRuleMap, tt.src, "10.0.0.1", tt.port)
+		assert.True(t, ok)
+		assert.Equal(t, tt.allow, allow, "%s:%d", tt.src, tt.port)
+
+		conn := &rbacConnection{
+			srcIp:   netip.MustParseAddr(tt.src).AsSlice(),
+			dstIp:   netip.MustParseAddr("10.0.0.1").AsSlice(),
+			dstPort: tt.port,
+		}
+		assert.Equal(t, rbac.doRbac(conn), allow, "kernel and userspace disagree on %s:%d", tt.src, tt.port)
+	}
+
+	// a principal based policy takes the workload back to userspace
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "allow-sleep",
+		Namespace: "default",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+			Principals: []*security.StringMatch{{MatchType: &security.StringMatch_Exact{Exact: "cluster.local/ns/default/sa/sleep"}}},
+		}}}}}},
+	}))
+	rbac.SyncPolicyMaps()
+	_, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 80)
+	assert.False(t, ok)
+	var ruleKey authzRuleKey
+	var rule uint32
+	assert.False(t, authzRuleMap.Iterate().Next(&ruleKey, &rule))
+
+	// the entries of a removed workload are deleted
+	rbac.RemovePolicy("default/allow-sleep")
+	rbac.SyncPolicyMaps()
+	_, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 80)
+	assert.True(t, ok)
+	workloadCache.DeleteWorkload("cluster0//Pod/default/reviews")
+	rbac.SyncPolicyMaps()
+	_, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 80)
+	assert.False(t, ok)
+	assert.False(t, authzRuleMap.Iterate().Next(&ruleKey, &rule))
+}
+
+func TestRbac_SetPolicyMapsKeepsLastRun(t *testing.T) {
+	authzMap, authzRuleMap := newTestPolicyMaps(t)
+	defer authzMap.Close()
+	defer authzRuleMap.Close()
+
+	// the entries of the last run deny port 8080 of 10.0.0.1 and everything to 10.0.0.2
+	for _, dst := range []string{"10.0.0.1", "10.0.0.2"} {
+		key := authzKey{}
+		copy(key.Addr[:], netip.MustParseAddr(dst).AsSlice())
+		assert.NoError(t, authzMap.Update(&key, &authzValue{}, ebpf.UpdateAny))
+	}
+	var dst1, dst2 [16]byte
+	copy(dst1[:], netip.MustParseAddr("10.0.0.1").AsSlice())
+	copy(dst2[:], netip.MustParseAddr("10.0.0.2").AsSlice())
+	for _, rule := range []authzRuleKey{
+		newAuthzRuleKey(dst1, AUTHZ_ACTION_DENY, testTerm("", 8080)),
+		newAuthzRuleKey(dst2, AUTHZ_ACTION_DENY, testTerm("", 0)),
+	} {
+		assert.NoError(t, authzRuleMap.Update(&rule, uint32(0), ebpf.UpdateAny))
+	}
+
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := NewRbac(workloadCache, nil)
+	rbac.nodeName = ""
+	rbac.SetPolicyMaps(authzMap, authzRuleMap)
+
+	// the connections are still decided by the last run until the first sync
+	allow, ok := lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 8080)
+	assert.True(t, ok)
+	assert.False(t, allow)
+	allow, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.2", 80)
+	assert.True(t, ok)
+	assert.False(t, allow)
+
+	// the sync rewrites the entries of the workloads and deletes the others
+	rbac.SyncPolicyMaps()
+	allow, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 8080)
+	assert.True(t, ok)
+	assert.True(t, allow)
+	_, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.2", 80)
+	assert.False(t, ok)
+	var ruleKey authzRuleKey
+	var rule uint32
+	assert.False(t, authzRuleMap.Iterate().Next(&ruleKey, &rule))
+	assert.Nil(t, rbac.policyMaps.stale)
+}
+
+func TestRbac_SetAuditLogDisablesPolicyMaps(t *testing.T) {
+	authzMap, authzRuleMap := newTestPolicyMaps(t)
+	defer authzMap.Close()
+	defer authzRuleMap.Close()
+
+	// an entry left by the last run
+	key := authzKey{}
+	copy(key.Addr[:], netip.MustParseAddr("10.0.0.2").AsSlice())
+	assert.NoError(t, authzMap.Update(&key, &authzValue{}, ebpf.UpdateAny))
+
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac := NewRbac(workloadCache, nil)
+	rbac.nodeName = ""
+	rbac.SetPolicyMaps(authzMap, authzRuleMap)
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny-8080", 8080)))
+	rbac.SyncPolicyMaps()
+	_, ok := lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 8080)
+	assert.True(t, ok)
+
+	// the records only kept in memory leave the offloading on
+	rbac.SetAuditLog(NewAuditLog("", false))
+	_, ok = lookupVerdict(authzMap, authzRuleMap, "192.168.1.1", "10.0.0.1", 8080)
+	assert.True(t, ok)
+
+	auditLog := NewAuditLog(filepath.Join(t.TempDir(), "audit.log"), false)
+	defer auditLog.Close()
+	rbac.SetAuditLog(auditLog)
+	rbac.SyncPolicyMaps()
+	var value authzValue
+	assert.False(t, authzMap.Iterate().Next(&key, &value))
+	var ruleKey authzRuleKey
+	var rule uint32
+	assert.False(t, authzRuleMap.Iterate().Next(&ruleKey, &rule))
+}
@@ -18,10 +18,13 @@ package auth
 
 import (
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"istio.io/istio/pkg/util/sets"
 
+	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/api/v2/workloadapi/security"
 )
 
@@ -32,6 +35,18 @@ type policyStore struct {
 	// byNamespace maintains a mapping of namespace (or "" for global) to policy names
 	byNamespace map[string]sets.Set[string]
 
+	// compiled maintains a mapping of ns/name to the compiled policy, a policy missing here is
+	// compiled on first use
+	compiled map[string]*compiledPolicy
+
+	// dryRun holds the names of the policies evaluated without being enforced, in the form of
+	// namespace/name, or namespace/* for all the policies in the namespace
+	dryRun sets.Set[string]
+
+	// generation is increased on every change, the cached policies of workloads built from an
+	// older generation are stale
+	generation atomic.Uint64
+
 	rwLock sync.RWMutex
 }
 
@@ -39,36 +54,61 @@ func newPolicyStore() *policyStore {
 	return &policyStore{
 		byKey:       make(map[string]*security.Authorization),
 		byNamespace: make(map[string]sets.Set[string]),
+		compiled:    make(map[string]*compiledPolicy),
 	}
 }
 
-func (ps *policyStore) updatePolicy(authPolicy *security.Authorization) error {
-	if authPolicy == nil {
-		return nil
+func (ps *policyStore) storeCompiled(key string, authPolicy *security.Authorization) *compiledPolicy {
+	if ps.compiled == nil {
+		ps.compiled = make(map[string]*compiledPolicy)
 	}
-	key := authPolicy.ResourceName()
+	cp := compilePolicy(authPolicy)
+	ps.compiled[key] = cp
+	return cp
+}
 
-	ps.rwLock.Lock()
-	defer ps.rwLock.Unlock()
-	var ns string
+// indexNamespace returns the key of the policy in byNamespace, the namespace of a namespace
+// policy or "" for a global one. A workload selector policy is not indexed by namespace, it is
+// found through the policy names of the workloads it selects.
+func indexNamespace(authPolicy *security.Authorization) (string, bool) {
 	switch authPolicy.GetScope() {
-	case security.Scope_WORKLOAD_SELECTOR:
-		ps.byKey[key] = authPolicy
-		return nil
 	case security.Scope_GLOBAL:
-		ns = ""
+		return "", true
 	case security.Scope_NAMESPACE:
-		ns = authPolicy.GetNamespace()
+		return authPolicy.GetNamespace(), true
+	default:
+		return "", false
+	}
+}
+
+func (ps *policyStore) updatePolicy(authPolicy *security.Authorization) error {
+	if authPolicy == nil {
+		return nil
+	}
+	switch authPolicy.GetScope() {
+	case security.Scope_GLOBAL, security.Scope_NAMESPACE, security.Scope_WORKLOAD_SELECTOR:
 	default:
 		return fmt.Errorf("invalid scope %v of authorization policy", authPolicy.GetScope())
 	}
+	key := authPolicy.ResourceName()
+
+	ps.rwLock.Lock()
+	defer ps.rwLock.Unlock()
+	defer ps.generation.Add(1)
 
-	if s, ok := ps.byNamespace[ns]; !ok {
-		ps.byNamespace[ns] = sets.New(key)
-	} else {
-		s.Insert(key)
+	// the scope of the policy may change, the index of the old one is dropped first
+	if old, ok := ps.byKey[key]; ok {
+		ps.unindex(key, old)
+	}
+	if ns, ok := indexNamespace(authPolicy); ok {
+		if s, ok := ps.byNamespace[ns]; !ok {
+			ps.byNamespace[ns] = sets.New(key)
+		} else {
+			s.Insert(key)
+		}
 	}
 	ps.byKey[key] = authPolicy
+	ps.storeCompiled(key, authPolicy)
 	return nil
 }
 
@@ -83,18 +123,17 @@ func (ps *policyStore) removePolicy(policyKey string) {
 	}
 	// remove authPolicy from byKey
 	delete(ps.byKey, policyKey)
+	delete(ps.compiled, policyKey)
+	ps.unindex(policyKey, authPolicy)
+	ps.generation.Add(1)
+}
 
-	var ns string
-	switch authPolicy.Scope {
-	case security.Scope_GLOBAL:
-		ns = ""
-	case security.Scope_NAMESPACE:
-		ns = authPolicy.GetNamespace()
-	default:
+// unindex removes the policy key from byNamespace
+func (ps *policyStore) unindex(policyKey string, authPolicy *security.Authorization) {
+	ns, ok := indexNamespace(authPolicy)
+	if !ok {
 		return
 	}
-
-	// remove authPolicy key from byNamespace
 	if s, ok := ps.byNamespace[ns]; ok {
 		s.Delete(policyKey)
 		if s.IsEmpty() {
@@ -103,6 +142,18 @@ func (ps *policyStore) removePolicy(policyKey string) {
 	}
 }
 
+// setDryRun replaces the dry-run policy names, the policies already aggregated are rebuilt.
+func (ps *policyStore) setDryRun(names []string) {
+	ps.rwLock.Lock()
+	defer ps.rwLock.Unlock()
+	ps.dryRun = sets.New(names...)
+	ps.generation.Add(1)
+}
+
+func (ps *policyStore) isDryRun(authPolicy *security.Authorization) bool {
+	return ps.dryRun.Contains(authPolicy.ResourceName()) || ps.dryRun.Contains(authPolicy.GetNamespace()+"/*")
+}
+
 // getAllPolicies returns a copied set of all policy names
 func (ps *policyStore) getAllPolicies() map[string]string {
 	ps.rwLock.RLock()
@@ -125,3 +176,42 @@ func (ps *policyStore) getByNamespace(namespace string) []string {
 	}
 	return nil
 }
+
+// policyNamesOf returns the names of the policies applied to the workload, the caller must hold
+// the lock.
+func (ps *policyStore) policyNamesOf(workload *workloadapi.Workload) []string {
+	policyNames := slices.Clone(workload.GetAuthorizationPolicies())
+	policyNames = append(policyNames, ps.byNamespace[workload.GetNamespace()].UnsortedList()...)
+	return append(policyNames, ps.byNamespace[""].UnsortedList()...)
+}
+
+// aggregate collects the compiled policies applied to the workload by action, from
+// the workload itself, its namespace and the root namespace.
+func (ps *policyStore) aggregate(workload *workloadapi.Workload) *workloadPolicies {
+	ps.rwLock.Lock()
+	defer ps.rwLock.Unlock()
+
+	out := &workloadPolicies{
+		workload:   workload,
+		generation: ps.generation.Load(),
+	}
+	for _, policyName := range ps.policyNamesOf(workload) {
+		policy, ok := ps.byKey[policyName]
+		if !ok {
+			continue
+		}
+		cp := ps.compiled[policyName]
+		if cp == nil || cp.policy != policy {
+			cp = ps.storeCompiled(policyName, policy)
+		}
+
+		if ps.isDryRun(policy) {
+			out.dryRun = append(out.dryRun, cp)
+		} else if policy.Action == security.Action_ALLOW {
+			out.allow = append(out.allow, cp)
+		} else if policy.Action == security.Action_DENY {
+			out.deny = append(out.deny, cp)
+		}
+	}
+	return out
+}
@@ -19,6 +19,8 @@ package auth
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+
 	"kmesh.net/kmesh/api/v2/workloadapi/security"
 )
 
@@ -114,3 +116,41 @@ func Test_policyStore_removePolicy(t *testing.T) {
 		})
 	}
 }
+
+func Test_policyStore_scopeChange(t *testing.T) {
+	ps := newPolicyStore()
+	policy := &security.Authorization{
+		Name:      "auth-name",
+		Namespace: "ns-name",
+		Scope:     security.Scope_NAMESPACE,
+	}
+	assert.NoError(t, ps.updatePolicy(policy))
+	assert.Equal(t, []string{"ns-name/auth-name"}, ps.getByNamespace("ns-name"))
+
+	// a policy turned into a workload selector one is no longer applied to the whole namespace
+	assert.NoError(t, ps.updatePolicy(&security.Authorization{
+		Name:      "auth-name",
+		Namespace: "ns-name",
+		Scope:     security.Scope_WORKLOAD_SELECTOR,
+	}))
+	assert.Empty(t, ps.byNamespace)
+	assert.Contains(t, ps.getAllPolicies(), "ns-name/auth-name")
+
+	assert.NoError(t, ps.updatePolicy(&security.Authorization{
+		Name:      "auth-name",
+		Namespace: "ns-name",
+		Scope:     security.Scope_GLOBAL,
+	}))
+	assert.Equal(t, []string{"ns-name/auth-name"}, ps.getByNamespace(""))
+
+	ps.removePolicy("ns-name/auth-name")
+	assert.Empty(t, ps.byKey)
+	assert.Empty(t, ps.byNamespace)
+	assert.Empty(t, ps.compiled)
+
+	// an invalid policy leaves the store untouched
+	generation := ps.generation.Load()
+	assert.Error(t, ps.updatePolicy(&security.Authorization{Name: "auth-name", Scope: 3}))
+	assert.Empty(t, ps.byKey)
+	assert.Equal(t, generation, ps.generation.Load())
+}
@@ -21,9 +21,9 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
-	"net"
-	"net/netip"
+	"os"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/cilium/ebpf"
@@ -31,6 +31,7 @@ import (
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
 	"kmesh.net/kmesh/pkg/logger"
 )
@@ -54,7 +55,25 @@ var (
 type Rbac struct {
 	policyStore   *policyStore
 	workloadCache cache.WorkloadCache
-	notifyFunc    notifyFunc
+	// serviceCache provides the subject alt names the backends of a service are verified against
+	serviceCache       cache.ServiceCache
+	identityVerifyMode IdentityVerifyMode
+	identityWarns      logLimiter
+	// policyCache caches the compiled policies of the destination workloads
+	policyCache policyCache
+	// policyMaps holds the policies of the workloads on nodeName offloaded into the bpf maps
+	policyMaps policyMaps
+	nodeName   string
+	// networks resolves the networks the workloads of a connection are looked up in
+	networks networks
+	// auditLog records the decisions, it is nil if auditing is disabled
+	auditLog                 *AuditLog
+	unknownDestinationPolicy UnknownDestinationPolicy
+	syncGate                 syncGate
+	pending                  pendingConns
+	// deniedTupleTTL is how long a denied tuple is kept in map_of_auth, 0 never expires it
+	deniedTupleTTL time.Duration
+	notifyFunc     notifyFunc
 }
 
 type Identity struct {
@@ -65,9 +84,12 @@ type Identity struct {
 
 type rbacConnection struct {
 	srcIdentity Identity
+	srcNetwork  string
 	dstNetwork  string
 	// srcIp is big endian
 	srcIp []byte
+	// srcPort is little endian
+	srcPort uint32
 	// dstIp ip is big endian
 	dstIp []byte
 	// dstPort is little endian
@@ -90,12 +112,18 @@ type bpfSockTupleV6 struct {
 	DstPort uint16
 }
 
-func NewRbac(workloadCache cache.WorkloadCache) *Rbac {
-	return &Rbac{
-		policyStore:   newPolicyStore(),
-		workloadCache: workloadCache,
-		notifyFunc:    xdpNotifyConnRst,
-	}
+func NewRbac(workloadCache cache.WorkloadCache, serviceCache cache.ServiceCache) *Rbac {
+	r := &Rbac{
+		policyStore:        newPolicyStore(),
+		workloadCache:      workloadCache,
+		serviceCache:       serviceCache,
+		identityVerifyMode: IdentityVerifyLog,
+		nodeName:           os.Getenv("NODE_NAME"),
+		deniedTupleTTL:     constants.AuthDeniedTupleTTL,
+		notifyFunc:         xdpNotifyConnRst,
+	}
+	r.syncGate.deadline = time.Now().Add(rbacSyncTimeout)
+	return r
 }
 
 func (r *Rbac) Run(ctx context.Context, mapOfTuple, mapOfAuth *ebpf.Map) {
@@ -114,6 +142,11 @@ func (r *Rbac) Run(ctx context.Context, mapOfTuple, mapOfAuth *ebpf.Map) {
 		}
 	}()
 
+	go r.retryPendingLoop(ctx)
+	if mapOfAuth != nil {
+		go r.sweepAuthMapLoop(ctx, mapOfAuth)
+	}
+
 	rec := ringbuf.Record{}
 	var conn rbacConnection
 	for {
@@ -146,12 +179,8 @@ func (r *Rbac) Run(ctx context.Context, mapOfTuple, mapOfAuth *ebpf.Map) {
 				continue
 			}
 
-			if !r.doRbac(&conn) {
-				log.Infof("Auth denied for connection: %+v", conn)
-				// If conn is denied, write tuples into XDP map, which includes source/destination IP/Port
-				if err = r.notifyFunc(mapOfAuth, msgType, tupleData); err != nil {
-					log.Error("authmap update FAILED, err: ", err)
-				}
+			if !r.decide(&conn, msgType, tupleData, mapOfAuth, true) {
+				r.deferConn(&conn, msgType, tupleData, mapOfAuth)
 			}
 		}
 	}
@@ -173,280 +202,181 @@ func (r *Rbac) GetAllPolicies() map[string]string {
 	return r.policyStore.getAllPolicies()
 }
 
+// rbacResult is the decision on a connection and the reason of it
+type rbacResult struct {
+	allow       bool
+	reason      string
+	dstWorkload *workloadapi.Workload
+	// policy and rule are the matched ones, policy is nil if the decision is a default
+	policy *compiledPolicy
+	rule   int
+	// dryRun are the decisions the dry-run policies would make
+	dryRun []dryRunResult
+}
+
+const (
+	reasonNoWorkload      = "default deny: no workload"
+	reasonServiceIdentity = "deny: service identity rejected"
+	reasonDenyPolicy      = "deny: deny policy matched"
+	reasonNoAllowPolicy   = "default allow: no allow policy"
+	reasonAllowPolicy     = "allow: allow policy matched"
+	reasonNoAllowMatched  = "default deny: no allow policy matched"
+)
+
 func (r *Rbac) doRbac(conn *rbacConnection) bool {
-	var networkAddress cache.NetworkAddress
-	networkAddress.Network = conn.dstNetwork
-	networkAddress.Address, _ = netip.AddrFromSlice(conn.dstIp)
-	dstWorkload := r.workloadCache.GetWorkloadByAddr(networkAddress)
-	// If no workload found, deny
+	return r.authorize(conn).allow
+}
+
+func (r *Rbac) authorize(conn *rbacConnection) rbacResult {
+	dstWorkload := r.getWorkloadByAddr(conn.dstNetwork, conn.dstIp)
+	// If no workload found, deny unless configured otherwise
 	if dstWorkload == nil {
-		return false
+		if r.unknownDestinationPolicy == UnknownDestinationAllow {
+			return rbacResult{allow: true, reason: reasonUnknownAllowed}
+		}
+		return rbacResult{reason: reasonNoWorkload}
 	}
 
-	// TODO: maybe cache them for performance issue
-	allowPolicies, denyPolicies := r.aggregate(dstWorkload)
+	// The backend must be one of the identities its services expect
+	if !r.verifyServiceIdentity(conn, dstWorkload) {
+		return rbacResult{reason: reasonServiceIdentity, dstWorkload: dstWorkload}
+	}
 
+	policies := r.policyCache.get(r.policyStore, dstWorkload)
+	result := evaluate(conn, policies)
+	result.dstWorkload = dstWorkload
+	if len(policies.dryRun) != 0 {
+		evaluateDryRun(conn, policies, &result)
+	}
+	return result
+}
+
+// evaluate decides the connection with the enforced policies
+func evaluate(conn *rbacConnection, policies *workloadPolicies) rbacResult {
 	// 1. If there is ANY deny policy, deny the request
-	for _, denyPolicy := range denyPolicies {
-		if matches(conn, denyPolicy) {
-			return false
+	for _, denyPolicy := range policies.deny {
+		if rule, ok := matches(conn, denyPolicy); ok {
+			return rbacResult{reason: reasonDenyPolicy, policy: denyPolicy, rule: rule}
 		}
 	}
 
 	// 2. If there is NO allow policy for the workload, allow the request
-	if len(allowPolicies) == 0 {
-		return true
+	if len(policies.allow) == 0 {
+		return rbacResult{allow: true, reason: reasonNoAllowPolicy}
 	}
 
 	// 3. If there is ANY allow policy matched, allow the request
-	for _, allowPolicy := range allowPolicies {
-		if matches(conn, allowPolicy) {
-			return true
+	for _, allowPolicy := range policies.allow {
+		if rule, ok := matches(conn, allowPolicy); ok {
+			return rbacResult{allow: true, reason: reasonAllowPolicy, policy: allowPolicy, rule: rule}
 		}
 	}
 
 	// 4. If 1,2 and 3 unsatisfied, deny the request
-	return false
+	return rbacResult{reason: reasonNoAllowMatched}
 }
 
-func (r *Rbac) aggregate(workload *workloadapi.Workload) (allowPolicies, denyPolicies []*security.Authorization) {
-	allowPolicies = make([]*security.Authorization, 0)
-	denyPolicies = make([]*security.Authorization, 0)
-
-	// Collect policy names from workload,  namespace and global(root namespace)
-	policyNames := workload.GetAuthorizationPolicies()
-	policyNames = append(policyNames, r.policyStore.getByNamespace(workload.Namespace)...)
-	policyNames = append(policyNames, r.policyStore.getByNamespace("")...)
-
-	for _, policyName := range policyNames {
-		if policy, ok := r.policyStore.byKey[policyName]; ok {
-			if policy.Action == security.Action_ALLOW {
-				allowPolicies = append(allowPolicies, policy)
-			} else if policy.Action == security.Action_DENY {
-				denyPolicies = append(denyPolicies, policy)
-			}
-		}
-	}
-	return
-}
-
-func matches(conn *rbacConnection, policy *security.Authorization) bool {
-	if policy.GetRules() == nil {
-		return false
+// matches returns the index of the first rule of the policy matching the connection
+func matches(conn *rbacConnection, policy *compiledPolicy) (int, bool) {
+	if policy.rules == nil {
+		return 0, false
 	}
 
 	// If ANY rule matches, it's a match
-	for _, rule := range policy.GetRules() {
+	for i := range policy.rules {
 		ruleMatch := true
 		// If ALL clause matches, it's a match
-		for _, clause := range rule.GetClauses() {
-			clauseMatch := false
-			// If ANY match matches, it's a match
-			for _, match := range clause.GetMatches() {
-				if isEmptyMatch(match) {
-					continue
-				}
-
-				// Values of specific type are OR-ed. If multiple types are set, they are AND-ed
-				// If one type fails to match, we do a short circuit
-				if matchDstIp(conn.dstIp, match) && matchSrcIp(conn.srcIp, match) &&
-					matchDstPort(conn.dstPort, match) && matchPrincipal(conn.srcIdentity.String(), match) &&
-					matchNamespace(conn.srcIdentity.namespace, match) {
-					clauseMatch = true
-					break
-				}
-				// Continue to try next match
-			}
-
-			if len(clause.GetMatches()) == 0 {
-				clauseMatch = true
-			}
-			ruleMatch = ruleMatch && clauseMatch
-			if !ruleMatch {
+		for j := range policy.rules[i].clauses {
+			if matchClause(conn, &policy.rules[i].clauses[j]) == clauseMismatch {
+				ruleMatch = false
 				break
 			}
 		}
 		if ruleMatch {
-			return true
+			return i, true
 		}
 	}
-	return false
+	return 0, false
 }
 
-func matchDstIp(dstIp []byte, match *security.Match) bool {
-	var pm, nm bool
+const (
+	// clauseMatchAll is returned by matchClause for a clause without matches
+	clauseMatchAll = -1
+	clauseMismatch = -2
+)
+
+// matchClause returns the index of the first match of the clause matching the connection
+func matchClause(conn *rbacConnection, clause *compiledClause) int {
+	if clause.matchAll {
+		return clauseMatchAll
+	}
+	principal := conn.srcIdentity.principal()
+	// If ANY match matches, it's a match
+	for _, match := range clause.matches {
+		// Values of specific type are OR-ed. If multiple types are set, they are AND-ed
+		// If one type fails to match, we do a short circuit
+		if matchDstIp(conn.dstIp, match) && matchSrcIp(conn.srcIp, match) &&
+			matchDstPort(conn.dstPort, match) && matchPrincipal(principal, match) &&
+			matchNamespace(conn.srcIdentity.namespace, match) {
+			return match.index
+		}
+		// Continue to try next match
+	}
+	return clauseMismatch
+}
+
+func matchDstIp(dstIp []byte, match *compiledMatch) bool {
 	// Positive match means if ANY destination IP in destination_ips contains dstIp, it does match
 	// If there is no destination IP in destination_ips, it does match
-	if len(match.GetDestinationIps()) == 0 {
-		pm = true
-	} else {
-		pm = internalMatchDstIp(dstIp, match.GetDestinationIps())
-	}
+	pm := match.dstIps == nil || match.dstIps.contains(dstIp)
 	// Negative match means if ANY destination IP in not_destination_ips contains dstIp, it does NOT match
 	// If there is no destination IP in destination_ips, it does match
-	if len(match.GetNotDestinationIps()) == 0 {
-		nm = true
-	} else {
-		nm = !internalMatchDstIp(dstIp, match.GetNotDestinationIps())
-	}
+	nm := match.notDstIps == nil || !match.notDstIps.contains(dstIp)
 	return pm && nm
 }
 
-func matchSrcIp(srcIp []byte, match *security.Match) bool {
-	var pm, nm bool
+func matchSrcIp(srcIp []byte, match *compiledMatch) bool {
 	// Positive match means if ANY source IP in source_ips contains srcIp, it does match
 	// If there is no source IP in source_ips, it does match
-	if len(match.GetSourceIps()) == 0 {
-		pm = true
-	} else {
-		pm = internalMatchSrcIp(srcIp, match.GetSourceIps())
-	}
+	pm := match.srcIps == nil || match.srcIps.contains(srcIp)
 	// Negative match means if ANY source IP in not_source_ips contains srcIp, it does NOT match
 	// If there is no source IP in not_source_ips, it does match
-	if len(match.GetNotSourceIps()) == 0 {
-		nm = true
-	} else {
-		nm = !internalMatchSrcIp(srcIp, match.GetNotSourceIps())
-	}
+	nm := match.notSrcIps == nil || !match.notSrcIps.contains(srcIp)
 	return pm && nm
 }
 
-func matchDstPort(dstPort uint32, match *security.Match) bool {
-	var pm, nm bool
+func matchDstPort(dstPort uint32, match *compiledMatch) bool {
 	// Positive match means if ANY destination port in destination_ports equals to dstPort, it does match
 	// If there is no destination port in destination_ports, it does match
-	if len(match.GetDestinationPorts()) == 0 {
-		pm = true
-	} else {
-		pm = internalMatchDstPort(dstPort, match.GetDestinationPorts())
-	}
+	_, found := match.dstPorts[dstPort]
+	pm := match.dstPorts == nil || found
 	// Negative match means if ANY destination port in not_destination_ports equals to dstPort, it does NOT match
 	// If there is no destination port in not_destination_ports, it does match
-	if len(match.GetNotDestinationPorts()) == 0 {
-		nm = true
-	} else {
-		nm = !internalMatchDstPort(dstPort, match.GetNotDestinationPorts())
-	}
+	_, found = match.notDstPorts[dstPort]
+	nm := match.notDstPorts == nil || !found
 	return pm && nm
 }
 
-func matchPrincipal(srcId string, match *security.Match) bool {
-	// Source identity must start with "spiffe://"
-	if !strings.HasPrefix(srcId, SPIFFE_PREFIX) {
-		return false
-	}
-
-	var pm, nm bool
-	// Positive match means if ANY principal pattern in principals matches srcId, it does match
+func matchPrincipal(principal string, match *compiledMatch) bool {
+	// Positive match means if ANY principal pattern in principals matches the principal, it does match
 	// If there is no principal pattern in principals, it does match
-	if len(match.GetPrincipals()) == 0 {
-		pm = true
-	} else {
-		pm = internalMatchPrincipal(srcId, match.GetPrincipals())
-	}
-	// Negative match means if ANY principal pattern in not_principals matches srcId, it does NOT match
+	pm := len(match.principals) == 0 || matchStrings(principal, match.principals)
+	// Negative match means if ANY principal pattern in not_principals matches the principal, it does NOT match
 	// If there is no principal pattern in not_principals, it does match
-	if len(match.GetNotPrincipals()) == 0 {
-		nm = true
-	} else {
-		nm = !internalMatchPrincipal(srcId, match.GetNotPrincipals())
-	}
+	nm := len(match.notPrincipals) == 0 || !matchStrings(principal, match.notPrincipals)
 	return pm && nm
 }
 
-func matchNamespace(srcNs string, match *security.Match) bool {
-	var pm, nm bool
+func matchNamespace(srcNs string, match *compiledMatch) bool {
 	// Positive match means if ANY namespace pattern in namespaces matches srcNs, it does match
 	// If there is no namespace pattern in namespaces, it does match
-	if len(match.GetNamespaces()) == 0 {
-		pm = true
-	} else {
-		pm = internalMatchNamespace(srcNs, match.GetNamespaces())
-	}
+	pm := len(match.namespaces) == 0 || matchStrings(srcNs, match.namespaces)
 	// Negative match means if ANY namespace pattern in not_namespaces matches srcNs, it does NOT match
 	// If there is no namespace pattern in not_namespaces, it does match
-	if len(match.GetNotNamespaces()) == 0 {
-		nm = true
-	} else {
-		nm = !internalMatchNamespace(srcNs, match.GetNotNamespaces())
-	}
+	nm := len(match.notNamespaces) == 0 || !matchStrings(srcNs, match.notNamespaces)
 	return pm && nm
 }
 
-func internalMatchDstIp(dstIp []byte, addresses []*security.Address) bool {
-	for _, addr := range addresses {
-		_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", net.IP(addr.GetAddress()).String(), addr.GetLength()))
-		if err != nil {
-			continue
-		}
-		if ipNet.Contains(dstIp) {
-			return true
-		}
-	}
-	return false
-}
-
-func internalMatchSrcIp(srcIp []byte, addresses []*security.Address) bool {
-	for _, addr := range addresses {
-		_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", net.IP(addr.GetAddress()).String(), addr.GetLength()))
-		if err != nil {
-			continue
-		}
-		if ipNet.Contains(srcIp) {
-			return true
-		}
-	}
-	return false
-}
-
-func internalMatchDstPort(checkDstPort uint32, dstPorts []uint32) bool {
-	for _, port := range dstPorts {
-		if checkDstPort == port {
-			return true
-		}
-	}
-	return false
-}
-
-func internalMatchPrincipal(srcId string, principals []*security.StringMatch) bool {
-	srcId = strings.TrimPrefix(srcId, SPIFFE_PREFIX)
-	m := false
-	for _, principal := range principals {
-		if len(principal.GetPrefix()) > 0 {
-			m = strings.HasPrefix(srcId, principal.GetPrefix())
-		} else if len(principal.GetSuffix()) > 0 {
-			m = strings.HasSuffix(srcId, principal.GetSuffix())
-		} else if len(principal.GetExact()) > 0 {
-			m = srcId == principal.GetExact()
-		} else {
-			m = len(srcId) == 0
-		}
-		if m {
-			return true
-		}
-	}
-	return false
-}
-
-func internalMatchNamespace(srcNs string, namespaces []*security.StringMatch) bool {
-	m := false
-	for _, ns := range namespaces {
-		if len(ns.GetPrefix()) > 0 {
-			m = strings.HasPrefix(srcNs, ns.GetPrefix())
-		} else if len(ns.GetSuffix()) > 0 {
-			m = strings.HasSuffix(srcNs, ns.GetSuffix())
-		} else if len(ns.GetExact()) > 0 {
-			m = srcNs == ns.GetExact()
-		} else {
-			m = len(srcNs) == 0
-		}
-		if m {
-			return true
-		}
-	}
-	return false
-}
-
 func (r *Rbac) buildConnV4(buf *bytes.Buffer) (rbacConnection, error) {
 	var (
 		conn    rbacConnection
@@ -459,8 +389,11 @@ func (r *Rbac) buildConnV4(buf *bytes.Buffer) (rbacConnection, error) {
 	// srcIp and dstIp are big endian, and dstPort is little endian, which is consistent with authorization policy flushed to Kmesh
 	conn.srcIp = binary.BigEndian.AppendUint32(conn.srcIp, tupleV4.SrcAddr)
 	conn.dstIp = binary.BigEndian.AppendUint32(conn.dstIp, tupleV4.DstAddr)
+	conn.srcPort = uint32(tupleV4.SrcPort)
 	conn.dstPort = uint32(tupleV4.DstPort)
-	conn.srcIdentity = r.getIdentityByIp(conn.srcIp)
+	conn.srcNetwork = r.networks.of(conn.srcIp)
+	conn.dstNetwork = r.networks.of(conn.dstIp)
+	conn.srcIdentity = r.getIdentityByIp(conn.srcNetwork, conn.srcIp)
 	return conn, nil
 }
 
@@ -479,8 +412,11 @@ func (r *Rbac) buildConnV6(buf *bytes.Buffer) (rbacConnection, error) {
 		conn.srcIp = binary.BigEndian.AppendUint32(conn.srcIp, tupleV6.SrcAddr[i])
 		conn.dstIp = binary.BigEndian.AppendUint32(conn.dstIp, tupleV6.DstAddr[i])
 	}
+	conn.srcPort = uint32(tupleV6.SrcPort)
 	conn.dstPort = uint32(tupleV6.DstPort)
-	conn.srcIdentity = r.getIdentityByIp(conn.srcIp)
+	conn.srcNetwork = r.networks.of(conn.srcIp)
+	conn.dstNetwork = r.networks.of(conn.dstIp)
+	conn.srcIdentity = r.getIdentityByIp(conn.srcNetwork, conn.srcIp)
 	return conn, nil
 }
 
@@ -488,6 +424,15 @@ func (id *Identity) String() string {
 	return fmt.Sprintf(SPIFFE_PREFIX+"%s/ns/%s/sa/%s", id.trustDomain, id.namespace, id.serviceAccount)
 }
 
+// principal is the identity without the spiffe prefix as the principals of the policies are, it
+// is empty for an unknown identity
+func (id *Identity) principal() string {
+	if *id == (Identity{}) {
+		return ""
+	}
+	return strings.TrimPrefix(id.String(), SPIFFE_PREFIX)
+}
+
 func isEmptyMatch(m *security.Match) bool {
 	return m.GetDestinationIps() == nil && m.GetNotDestinationIps() == nil &&
 		m.GetSourceIps() == nil && m.GetNotSourceIps() == nil &&
@@ -497,17 +442,11 @@ func isEmptyMatch(m *security.Match) bool {
 }
 
 // todo : get identity form tls connection
-func (r *Rbac) getIdentityByIp(ip []byte) Identity {
-	var networkAddress cache.NetworkAddress
-	networkAddress.Address, _ = netip.AddrFromSlice(ip)
-	workload := r.workloadCache.GetWorkloadByAddr(networkAddress)
+func (r *Rbac) getIdentityByIp(network string, ip []byte) Identity {
+	workload := r.getWorkloadByAddr(network, ip)
 	if workload == nil {
-		log.Warnf("get workload from ip %v FAILED", ip)
+		log.Warnf("get workload from ip %v in network %q FAILED", ip, network)
 		return Identity{}
 	}
-	return Identity{
-		trustDomain:    workload.GetTrustDomain(),
-		namespace:      workload.GetNamespace(),
-		serviceAccount: workload.GetServiceAccount(),
-	}
+	return identityOf(workload)
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/asm"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"istio.io/istio/pkg/util/sets"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
@@ -1278,6 +1279,91 @@ var (
 		},
 	}
 
+	policy10_1 = &security.Authorization{
+		Name:      ALLOW_AUTH,
+		Namespace: GLOBAL_NAMESPACE,
+		Scope:     security.Scope_WORKLOAD_SELECTOR,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{
+			{
+				Clauses: []*security.Clause{
+					{
+						Matches: []*security.Match{
+							{
+								Principals: []*security.StringMatch{
+									{
+										MatchType: &security.StringMatch_Presence{
+											Presence: &emptypb.Empty{},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	policy10_2 = &security.Authorization{
+		Name:      DENY_AUTH,
+		Namespace: GLOBAL_NAMESPACE,
+		Scope:     security.Scope_WORKLOAD_SELECTOR,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{
+			{
+				Clauses: []*security.Clause{
+					{
+						Matches: []*security.Match{
+							{
+								NotNamespaces: []*security.StringMatch{
+									{
+										MatchType: &security.StringMatch_Exact{
+											Exact: "trusted",
+										},
+									},
+								},
+								NotPrincipals: []*security.StringMatch{
+									{
+										MatchType: &security.StringMatch_Suffix{
+											Suffix: "/sa/admin",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	policy10_3 = &security.Authorization{
+		Name:      DENY_AUTH,
+		Namespace: GLOBAL_NAMESPACE,
+		Scope:     security.Scope_WORKLOAD_SELECTOR,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{
+			{
+				Clauses: []*security.Clause{
+					{
+						Matches: []*security.Match{
+							{
+								NotSourceIps: []*security.Address{
+									{
+										Address: []byte{192, 168, 122, 0},
+										Length:  24,
+									},
+								},
+								NotDestinationPorts: []uint32{8080},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
 	byNamespaceAllow = map[string]sets.Set[string]{GLOBAL_NAMESPACE: sets.New(ALLOW_POLICY)}
 
 	byNamespaceDeny = map[string]sets.Set[string]{GLOBAL_NAMESPACE: sets.New(DENY_POLICY)}
@@ -1962,6 +2048,181 @@ func TestRbac_doRbac(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"10-1-1. Principal presence allow match, allow",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{ALLOW_POLICY: policy10_1},
+					byNamespace: byNamespaceAllow,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIdentity: Identity{
+						trustDomain:    "cluster.local",
+						namespace:      "default",
+						serviceAccount: "sleep",
+					},
+					srcIp: []byte{192, 168, 122, 5},
+					dstIp: []byte{192, 168, 122, 2},
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			true,
+		},
+		{
+			"10-1-2. Principal presence allow match without identity, deny",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{ALLOW_POLICY: policy10_1},
+					byNamespace: byNamespaceAllow,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIp: []byte{192, 168, 122, 5},
+					dstIp: []byte{192, 168, 122, 2},
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			false,
+		},
+		{
+			"10-2-1. Not namespace deny match, deny",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{DENY_POLICY: policy10_2},
+					byNamespace: byNamespaceDeny,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIdentity: Identity{
+						trustDomain:    "cluster.local",
+						namespace:      "default",
+						serviceAccount: "sleep",
+					},
+					srcIp: []byte{192, 168, 122, 5},
+					dstIp: []byte{192, 168, 122, 2},
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			false,
+		},
+		{
+			"10-2-2. Not namespace deny mismatch, allow",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{DENY_POLICY: policy10_2},
+					byNamespace: byNamespaceDeny,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIdentity: Identity{
+						trustDomain:    "cluster.local",
+						namespace:      "trusted",
+						serviceAccount: "sleep",
+					},
+					srcIp: []byte{192, 168, 122, 5},
+					dstIp: []byte{192, 168, 122, 2},
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			true,
+		},
+		{
+			"10-2-3. Not principal deny mismatch, allow",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{DENY_POLICY: policy10_2},
+					byNamespace: byNamespaceDeny,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIdentity: Identity{
+						trustDomain:    "cluster.local",
+						namespace:      "default",
+						serviceAccount: "admin",
+					},
+					srcIp: []byte{192, 168, 122, 5},
+					dstIp: []byte{192, 168, 122, 2},
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			true,
+		},
+		{
+			"10-3-1. Not source IP and not destination port deny match, deny",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{DENY_POLICY: policy10_3},
+					byNamespace: byNamespaceDeny,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIp:   []byte{10, 0, 0, 1},
+					dstIp:   []byte{192, 168, 122, 2},
+					dstPort: 80,
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			false,
+		},
+		{
+			"10-3-2. Not source IP deny mismatch, allow",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{DENY_POLICY: policy10_3},
+					byNamespace: byNamespaceDeny,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIp:   []byte{192, 168, 122, 5},
+					dstIp:   []byte{192, 168, 122, 2},
+					dstPort: 80,
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			true,
+		},
+		{
+			"10-3-3. Not destination port deny mismatch, allow",
+			fields{
+				&policyStore{
+					byKey:       map[string]*security.Authorization{DENY_POLICY: policy10_3},
+					byNamespace: byNamespaceDeny,
+				},
+			},
+			args{
+				conn: &rbacConnection{
+					srcIp:   []byte{10, 0, 0, 1},
+					dstIp:   []byte{192, 168, 122, 2},
+					dstPort: 8080,
+				},
+				workload: &workloadapi.Workload{
+					Addresses: [][]byte{{192, 168, 122, 2}},
+				},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1995,7 +2256,7 @@ func Test_handleAuthorizationTypeResponse(t *testing.T) {
 		Rules:     []*security.Rule{},
 	}
 
-	rbac := NewRbac(nil) // Initialize your rbac object here
+	rbac := NewRbac(nil, nil) // Initialize your rbac object here
 
 	err := rbac.UpdatePolicy(policy1)
 	assert.NoError(t, err)
@@ -2112,7 +2373,7 @@ func prepareMaps(t *testing.T, msgType uint32) (mapOfTuple, mapOfAuth *ebpf.Map)
 		Name:       "map_of_auth",
 		Type:       ebpf.Hash,
 		KeySize:    uint32(unsafe.Sizeof(bpfSockTupleV6{})),
-		ValueSize:  uint32(unsafe.Sizeof(uint32(0))),
+		ValueSize:  uint32(unsafe.Sizeof(uint64(0))),
 		MaxEntries: 4096,
 	})
 	if err != nil {
@@ -2196,14 +2457,14 @@ func TestRbac_Run(t *testing.T) {
 		r.Run(ctx, mapOfTuple, mapOfAuth)
 
 		// Do lookup
-		var val uint32
+		var val uint64
 		err := mapOfAuth.Lookup(tt.args.lookupKey, &val)
 		if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
 			t.Fatal("Do lookup failed, err: ", err)
 		}
 
-		// Judge results
-		found := val == 1
+		// Judge results, the value is the time of the denial
+		found := val != 0
 		if found != tt.wantFound {
 			t.Errorf("want %v, but got %v", tt.wantFound, found)
 		}
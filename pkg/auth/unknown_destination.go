@@ -0,0 +1,216 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+// UnknownDestinationPolicy decides what to do with a connection to a destination which is not
+// found in the workload cache.
+type UnknownDestinationPolicy int
+
+const (
+	UnknownDestinationDeny UnknownDestinationPolicy = iota
+	UnknownDestinationAllow
+	// UnknownDestinationRetry holds the connection and decides it again after the caches are
+	// updated, it is denied if the destination is still unknown after pendingRetryTimeout
+	UnknownDestinationRetry
+)
+
+const (
+	// rbacSyncTimeout bounds the wait for the initial sync, the enforcement starts anyway after it
+	rbacSyncTimeout     = 30 * time.Second
+	pendingRetryTimeout = 10 * time.Second
+	maxPendingConns     = 4096
+
+	reasonNotSynced      = "allow: authorization not synced"
+	reasonUnknownAllowed = "allow: unknown destination"
+)
+
+var unknownDestinationPolicies = map[string]UnknownDestinationPolicy{
+	"deny":  UnknownDestinationDeny,
+	"allow": UnknownDestinationAllow,
+	"retry": UnknownDestinationRetry,
+}
+
+func parseUnknownDestinationPolicy(policy string) (UnknownDestinationPolicy, error) {
+	if p, ok := unknownDestinationPolicies[strings.ToLower(policy)]; ok {
+		return p, nil
+	}
+	return UnknownDestinationDeny, fmt.Errorf("invalid unknown destination policy %q, valid values are [allow, deny, retry]", policy)
+}
+
+// SetUnknownDestinationPolicy sets the policy by its name, one of allow, deny and retry.
+func (r *Rbac) SetUnknownDestinationPolicy(policy string) error {
+	p, err := parseUnknownDestinationPolicy(policy)
+	if err != nil {
+		return err
+	}
+	r.unknownDestinationPolicy = p
+	return nil
+}
+
+// syncGate holds back the enforcement until both the addresses and the authorization policies of
+// the first xDS responses are handled, a zero gate is always open.
+type syncGate struct {
+	addressSynced atomic.Bool
+	policySynced  atomic.Bool
+	deadline      time.Time
+}
+
+func (g *syncGate) open() bool {
+	return (g.addressSynced.Load() && g.policySynced.Load()) || time.Now().After(g.deadline)
+}
+
+// SetAddressSynced marks the workload cache synced with the first address response.
+func (r *Rbac) SetAddressSynced() {
+	if r != nil {
+		r.syncGate.addressSynced.Store(true)
+	}
+}
+
+// SetPolicySynced marks the policy store synced with the first authorization response.
+func (r *Rbac) SetPolicySynced() {
+	if r != nil {
+		r.syncGate.policySynced.Store(true)
+	}
+}
+
+// Ready returns whether the authorization policies are enforced.
+func (r *Rbac) Ready() bool {
+	return r.syncGate.open()
+}
+
+// pendingConn is a connection deferred until the caches are updated
+type pendingConn struct {
+	conn    rbacConnection
+	msgType uint32
+	tuple   []byte
+	since   time.Time
+}
+
+type pendingConns struct {
+	mutex sync.Mutex
+	// mapOfAuth is the map the denied connections are notified to, it is set by Run
+	mapOfAuth *ebpf.Map
+	conns     []pendingConn
+}
+
+// decide makes the decision on the connection and enforces it, unless the connection is deferred
+// by the sync gate or the unknown destination policy. It returns false for a deferred connection,
+// deferrable is false if the connection has to be decided now.
+//
+// Before the initial sync the connections are only allowed by the allow policy, the others defer
+// them and decide them with the caches as they are once they can no longer be deferred.
+func (r *Rbac) decide(conn *rbacConnection, msgType uint32, tuple []byte, mapOfAuth *ebpf.Map, deferrable bool) bool {
+	var result rbacResult
+	switch {
+	case !r.Ready() && r.unknownDestinationPolicy == UnknownDestinationAllow:
+		result = rbacResult{allow: true, reason: reasonNotSynced}
+	case !r.Ready() && deferrable:
+		return false
+	default:
+		result = r.authorize(conn)
+		if result.reason == reasonNoWorkload && r.unknownDestinationPolicy == UnknownDestinationRetry && deferrable {
+			return false
+		}
+	}
+
+	reportDryRun(conn, &result)
+	r.auditLog.record(conn, &result)
+	if result.allow {
+		telemetry.RecordAuthzDecision(AuditDecisionAllow)
+	} else {
+		telemetry.RecordAuthzDecision(AuditDecisionDeny)
+		log.Infof("Auth denied for connection: %+v, reason: %s", conn, result.reason)
+		// If conn is denied, write tuples into XDP map, which includes source/destination IP/Port
+		if err := r.notifyFunc(mapOfAuth, msgType, tuple); err != nil {
+			log.Error("authmap update FAILED, err: ", err)
+		}
+	}
+	return true
+}
+
+// deferConn queues the connection to be decided by RetryPending, a connection which does not fit
+// in the queue is decided at once.
+func (r *Rbac) deferConn(conn *rbacConnection, msgType uint32, tuple []byte, mapOfAuth *ebpf.Map) {
+	r.pending.mutex.Lock()
+	r.pending.mapOfAuth = mapOfAuth
+	if len(r.pending.conns) < maxPendingConns {
+		r.pending.conns = append(r.pending.conns, pendingConn{
+			conn:    *conn,
+			msgType: msgType,
+			// the tuple is read from a buffer reused by the ringbuf reader
+			tuple: append([]byte(nil), tuple...),
+			since: time.Now(),
+		})
+		r.pending.mutex.Unlock()
+		return
+	}
+	r.pending.mutex.Unlock()
+
+	log.Warnf("too many pending connections, decide connection %+v now", *conn)
+	r.decide(conn, msgType, tuple, mapOfAuth, false)
+}
+
+func (r *Rbac) retryPendingLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.RetryPending()
+		}
+	}
+}
+
+// RetryPending decides the deferred connections again, it is called after the caches are updated.
+// A connection still deferred after pendingRetryTimeout is decided with the caches as they are.
+func (r *Rbac) RetryPending() {
+	if r == nil {
+		return
+	}
+
+	r.pending.mutex.Lock()
+	defer r.pending.mutex.Unlock()
+	if len(r.pending.conns) == 0 {
+		return
+	}
+
+	kept := r.pending.conns[:0]
+	for i := range r.pending.conns {
+		p := &r.pending.conns[i]
+		deferrable := time.Since(p.since) < pendingRetryTimeout
+		if !r.decide(&p.conn, p.msgType, p.tuple, r.pending.mapOfAuth, deferrable) {
+			kept = append(kept, *p)
+		}
+	}
+	clear(r.pending.conns[len(kept):])
+	r.pending.conns = kept
+}
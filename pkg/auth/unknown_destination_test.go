@@ -0,0 +1,119 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestRbac_unknownDestination(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	rbac := NewRbac(workloadCache, nil)
+	var denied []uint32
+	rbac.notifyFunc = func(mapOfAuth *ebpf.Map, msgType uint32, tuple []byte) error {
+		denied = append(denied, uint32(tuple[0]))
+		return nil
+	}
+	connTo := func(dst string, port uint32) *rbacConnection {
+		return &rbacConnection{
+			srcIp:   netip.MustParseAddr("192.168.1.1").AsSlice(),
+			dstIp:   netip.MustParseAddr(dst).AsSlice(),
+			dstPort: port,
+		}
+	}
+	connect := func(dst string, port uint32) {
+		if !rbac.decide(connTo(dst, port), 0, []byte{byte(port)}, nil, true) {
+			rbac.deferConn(connTo(dst, port), 0, []byte{byte(port)}, nil)
+		}
+	}
+
+	// the connections are held until both the addresses and the policies are synced
+	assert.NoError(t, rbac.SetUnknownDestinationPolicy("deny"))
+	connect("10.0.0.1", 1)
+	rbac.SetAddressSynced()
+	assert.False(t, rbac.Ready())
+	connect("10.0.0.1", 2)
+	assert.Empty(t, denied)
+	assert.Len(t, rbac.pending.conns, 2)
+	rbac.SetPolicySynced()
+	assert.True(t, rbac.Ready())
+	rbac.RetryPending()
+	assert.Empty(t, rbac.pending.conns)
+	connect("10.0.0.1", 3)
+	assert.Equal(t, []uint32{1, 2, 3}, denied)
+
+	assert.NoError(t, rbac.SetUnknownDestinationPolicy("allow"))
+	connect("10.0.0.1", 4)
+	assert.Equal(t, []uint32{1, 2, 3}, denied)
+	assert.Equal(t, reasonUnknownAllowed, rbac.authorize(connTo("10.0.0.1", 4)).reason)
+
+	// the connections are decided again once the destinations are known
+	assert.NoError(t, rbac.SetUnknownDestinationPolicy("retry"))
+	assert.NoError(t, rbac.UpdatePolicy(newTestDenyPolicy("deny-8080", 8080)))
+	connect("10.0.0.1", 5)
+	connect("10.0.0.2", 6)
+	assert.Len(t, rbac.pending.conns, 2)
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/reviews",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	rbac.RetryPending()
+	assert.Len(t, rbac.pending.conns, 1)
+	assert.Equal(t, []uint32{1, 2, 3}, denied)
+
+	// the destinations still unknown after the timeout are denied
+	rbac.pending.conns[0].since = time.Now().Add(-pendingRetryTimeout)
+	rbac.RetryPending()
+	assert.Empty(t, rbac.pending.conns)
+	assert.Equal(t, []uint32{1, 2, 3, 6}, denied)
+}
+
+func TestRbac_decideNotSynced(t *testing.T) {
+	rbac := NewRbac(cache.NewWorkloadCache(), nil)
+	var denied int
+	rbac.notifyFunc = func(mapOfAuth *ebpf.Map, msgType uint32, tuple []byte) error {
+		denied++
+		return nil
+	}
+	conn := &rbacConnection{dstIp: netip.MustParseAddr("10.0.0.1").AsSlice()}
+
+	// only the allow policy lets the connections through before the initial sync
+	assert.NoError(t, rbac.SetUnknownDestinationPolicy("allow"))
+	assert.True(t, rbac.decide(conn, 0, nil, nil, true))
+	assert.Zero(t, denied)
+
+	// the others hold them, and decide them with the caches as they are if they can not wait
+	for _, policy := range []string{"deny", "retry"} {
+		assert.NoError(t, rbac.SetUnknownDestinationPolicy(policy))
+		assert.False(t, rbac.decide(conn, 0, nil, nil, true), policy)
+	}
+	assert.True(t, rbac.decide(conn, 0, nil, nil, false))
+	assert.Equal(t, 1, denied)
+
+	// the enforcement starts anyway once the initial sync times out
+	rbac.syncGate.deadline = time.Now().Add(-time.Second)
+	assert.True(t, rbac.Ready())
+}
@@ -17,9 +17,20 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+const (
+	minAuthMapSweepPeriod = time.Second
+	authMapName           = "map_of_auth"
 )
 
 type notifyFunc func(mapOfAuth *ebpf.Map, msgType uint32, key []byte) error
@@ -36,6 +47,118 @@ func xdpNotifyConnRst(mapOfAuth *ebpf.Map, msgType uint32, key []byte) error {
 		}
 	}
 	// Insert the socket tuple into the auth map, so xdp_auth_handler can know that socket with
-	// this tuple is denied by policy, note that IP and port are big endian in auth map
-	return mapOfAuth.Update(key, uint32(1), ebpf.UpdateAny)
+	// this tuple is denied by policy, note that IP and port are big endian in auth map. The value
+	// is the time of the denial, in the same clock as bpf_ktime_get_ns
+	err := mapOfAuth.Update(key, monotonicNow(), ebpf.UpdateAny)
+	if err != nil {
+		telemetry.RecordMapUpdateError(authMapName)
+	}
+	if errors.Is(err, unix.E2BIG) {
+		telemetry.RecordMapOverflow(authMapName)
+	}
+	return err
+}
+
+// monotonicNow returns the nanoseconds of CLOCK_MONOTONIC, which bpf_ktime_get_ns reads
+func monotonicNow() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return uint64(ts.Nano())
+}
+
+// SetDeniedTupleTTL sets how long a denied tuple is kept in map_of_auth, 0 keeps it until the xdp
+// prog resets the connection or the connection is closed.
+func (r *Rbac) SetDeniedTupleTTL(ttl time.Duration) {
+	r.deniedTupleTTL = ttl
+}
+
+// sweepAuthMapLoop removes the expired tuples from map_of_auth periodically. The xdp prog deletes a
+// tuple once it resets the connection, and the sockops prog deletes it when the connection closes,
+// but a tuple denied after the connection closed, or of a client never sending another packet, is
+// left behind. Such a tuple would reset a later connection reusing it, and fill up the map.
+func (r *Rbac) sweepAuthMapLoop(ctx context.Context, mapOfAuth *ebpf.Map) {
+	if r.deniedTupleTTL <= 0 {
+		return
+	}
+	period := max(r.deniedTupleTTL/2, minAuthMapSweepPeriod)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			entries, expired := sweepAuthMap(mapOfAuth, r.deniedTupleTTL, monotonicNow())
+			telemetry.RecordMapEntries(authMapName, entries, int(mapOfAuth.MaxEntries()))
+			telemetry.RecordMapExpired(authMapName, expired)
+		}
+	}
+}
+
+// expiredTuple is a tuple of map_of_auth found expired and the denial time it was found with.
+type expiredTuple struct {
+	key      []byte
+	deniedAt uint64
+}
+
+// sweepAuthMap deletes the tuples denied at least ttl before now, it returns the number of the
+// tuples left and of the ones deleted.
+func sweepAuthMap(mapOfAuth *ebpf.Map, ttl time.Duration, now uint64) (int, int) {
+	var (
+		key      = make([]byte, mapOfAuth.KeySize())
+		deniedAt uint64
+		expired  []expiredTuple
+		entries  int
+	)
+	iter := mapOfAuth.Iterate()
+	for iter.Next(key, &deniedAt) {
+		if now >= deniedAt && now-deniedAt >= uint64(ttl) {
+			expired = append(expired, expiredTuple{key: append([]byte(nil), key...), deniedAt: deniedAt})
+			continue
+		}
+		entries++
+	}
+	if err := iter.Err(); err != nil {
+		log.Errorf("iterate %s failed, err:%s", authMapName, err)
+	}
+
+	// the keys are deleted after the iteration, which restarts on a deleted key
+	kept, deleted := deleteExpiredTuples(mapOfAuth, expired)
+	return entries + kept, deleted
+}
+
+// deleteExpiredTuples deletes the expired tuples unless they are denied again since the
+// iteration, it returns the number of the tuples kept and of the ones deleted. A denial
+// recorded between the lookup and the delete is still lost, the sweep is best effort.
+func deleteExpiredTuples(mapOfAuth *ebpf.Map, expired []expiredTuple) (int, int) {
+	var (
+		deniedAt uint64
+		kept     int
+		deleted  int
+	)
+	for _, tuple := range expired {
+		if err := mapOfAuth.Lookup(tuple.key, &deniedAt); err != nil {
+			// the tuple may be deleted by the bpf progs in the meantime
+			if !errors.Is(err, ebpf.ErrKeyNotExist) {
+				log.Errorf("lookup expired tuple from %s failed, err:%s", authMapName, err)
+				kept++
+			}
+			continue
+		}
+		if deniedAt != tuple.deniedAt {
+			kept++
+			continue
+		}
+		if err := mapOfAuth.Delete(tuple.key); err != nil {
+			if !errors.Is(err, ebpf.ErrKeyNotExist) {
+				log.Errorf("delete expired tuple from %s failed, err:%s", authMapName, err)
+				kept++
+			}
+			continue
+		}
+		deleted++
+	}
+	return kept, deleted
 }
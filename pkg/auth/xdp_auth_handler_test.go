@@ -0,0 +1,115 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuthMap(t *testing.T) *ebpf.Map {
+	mapOfAuth, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "map_of_auth",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(TUPLE_LEN),
+		ValueSize:  uint32(unsafe.Sizeof(uint64(0))),
+		MaxEntries: 16,
+	})
+	if err != nil {
+		t.Fatal("Create mapOfAuth failed, err: ", err)
+	}
+	return mapOfAuth
+}
+
+func TestSweepAuthMap(t *testing.T) {
+	mapOfAuth := newTestAuthMap(t)
+	defer mapOfAuth.Close()
+
+	tuple := func(port byte) []byte {
+		key := make([]byte, TUPLE_LEN)
+		key[IPV4_TUPLE_LENGTH-1] = port
+		return key
+	}
+	ttl := 30 * time.Second
+	now := uint64(time.Hour)
+	deniedAt := map[byte]uint64{
+		1: now - uint64(time.Minute),
+		2: now - uint64(ttl),
+		3: now - uint64(time.Second),
+		// denied after the sweep started
+		4: now + uint64(time.Second),
+	}
+	for port, at := range deniedAt {
+		assert.NoError(t, mapOfAuth.Put(tuple(port), at))
+	}
+
+	entries, expired := sweepAuthMap(mapOfAuth, ttl, now)
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, 2, expired)
+	for port, wantFound := range map[byte]bool{1: false, 2: false, 3: true, 4: true} {
+		var at uint64
+		err := mapOfAuth.Lookup(tuple(port), &at)
+		assert.Equal(t, wantFound, !errors.Is(err, ebpf.ErrKeyNotExist), "port %d", port)
+	}
+}
+
+func TestXdpNotifyConnRst_timestamp(t *testing.T) {
+	mapOfAuth := newTestAuthMap(t)
+	defer mapOfAuth.Close()
+
+	key := make([]byte, TUPLE_LEN)
+	before := monotonicNow()
+	assert.NoError(t, xdpNotifyConnRst(mapOfAuth, MSG_TYPE_IPV4, key))
+	var deniedAt uint64
+	assert.NoError(t, mapOfAuth.Lookup(key, &deniedAt))
+	assert.GreaterOrEqual(t, deniedAt, before)
+	assert.LessOrEqual(t, deniedAt, monotonicNow())
+
+	// the tuple is not expired until the ttl passes
+	entries, expired := sweepAuthMap(mapOfAuth, time.Hour, monotonicNow())
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, 0, expired)
+}
+
+func TestDeleteExpiredTuples_deniedAgain(t *testing.T) {
+	mapOfAuth := newTestAuthMap(t)
+	defer mapOfAuth.Close()
+
+	key := make([]byte, TUPLE_LEN)
+	gone := make([]byte, TUPLE_LEN)
+	gone[0] = 1
+	expired := []expiredTuple{{key: key, deniedAt: 1}, {key: gone, deniedAt: 1}}
+	// the tuple is denied again after the sweep found it expired
+	assert.NoError(t, mapOfAuth.Put(key, uint64(2)))
+
+	kept, deleted := deleteExpiredTuples(mapOfAuth, expired)
+	assert.Equal(t, 1, kept)
+	assert.Equal(t, 0, deleted)
+	var deniedAt uint64
+	assert.NoError(t, mapOfAuth.Lookup(key, &deniedAt))
+	assert.Equal(t, uint64(2), deniedAt)
+
+	assert.NoError(t, mapOfAuth.Put(key, uint64(1)))
+	kept, deleted = deleteExpiredTuples(mapOfAuth, expired)
+	assert.Equal(t, 0, kept)
+	assert.Equal(t, 1, deleted)
+}
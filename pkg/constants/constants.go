@@ -16,6 +16,8 @@
 
 package constants
 
+import "time"
+
 const (
 	AdsMode      = "ads"
 	WorkloadMode = "workload"
@@ -51,6 +53,14 @@ const (
 	INBOUND  = uint32(1)
 	OUTBOUND = uint32(2)
 
+	// KmeshWaypointPort is the default port kmesh waypoints listen on, it is used instead of the
+	// HboneMtlsPort
+	KmeshWaypointPort = 15019
+
+	// AuthDeniedTupleTTL is how long a denied tuple stays in map_of_auth if the xdp prog never
+	// resets a packet of it, long enough for the client to send the next packet
+	AuthDeniedTupleTTL = 30 * time.Second
+
 	Cgroup2Path = "/mnt/kmesh_cgroup2"
 	BpfFsPath   = "/sys/fs/bpf"
 )
@@ -34,6 +34,7 @@ import (
 	core_v2 "kmesh.net/kmesh/api/v2/core"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/config"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/utils/hash"
 )
 
@@ -117,6 +118,7 @@ func (p *processor) processAdsResponse(resp *service_discovery_v3.DiscoveryRespo
 	default:
 		err = fmt.Errorf("unsupported type url %s", resp.GetTypeUrl())
 	}
+	telemetry.RecordXdsPush(resp.GetTypeUrl(), err)
 
 	if err != nil {
 		log.Error(err)
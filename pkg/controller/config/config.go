@@ -24,6 +24,7 @@ import (
 	structpb "github.com/golang/protobuf/ptypes/struct"
 	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/model"
+	"istio.io/istio/pkg/network"
 	"istio.io/istio/pkg/util/protomarshal"
 	"istio.io/pkg/env"
 
@@ -67,6 +68,7 @@ func NewXDSConfig(mode string) *XdsConfig {
 	sa := env.Register("SERVICE_ACCOUNT", "", "").Get()
 	nodeName := env.Register("NODE_NAME", "", "").Get()
 	meshID := env.Register("MESH_ID", "cluster.local", "").Get()
+	nodeNetwork := env.Register("NETWORK", "", "").Get()
 
 	ip := localHostIPv4
 	if podIP != "" {
@@ -86,6 +88,7 @@ func NewXDSConfig(mode string) *XdsConfig {
 	c.Metadata.Labels = nil
 	c.Metadata.MeshID = meshID
 	c.Metadata.NodeName = nodeName
+	c.Metadata.Network = network.ID(nodeNetwork)
 	c.Metadata.NodeMetadata.ServiceAccount = sa
 
 	return c
@@ -19,13 +19,17 @@ package controller
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/auth"
 	"kmesh.net/kmesh/pkg/bpf"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/bypass"
+	"kmesh.net/kmesh/pkg/controller/config"
 	manage "kmesh.net/kmesh/pkg/controller/manage"
 	"kmesh.net/kmesh/pkg/controller/security"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/dns"
 	"kmesh.net/kmesh/pkg/logger"
 	"kmesh.net/kmesh/pkg/utils"
@@ -44,6 +48,17 @@ type Controller struct {
 	enableSecretManager bool
 	bpfFsPath           string
 	enableBpfLog        bool
+	waypointPort        uint32
+	identityVerifyMode  string
+	auditLogFile        string
+	auditAllowed        bool
+	dryRunPolicies      []string
+	unknownDestination  string
+	interfaceNetworks   []string
+	deniedTupleTTL      time.Duration
+	enableAccessLog     bool
+	accessLogFormat     telemetry.AccessLogFormat
+	metricDroppedLabels []string
 }
 
 func NewController(opts *options.BootstrapConfigs, bpfWorkloadObj *bpf.BpfKmeshWorkload, bpfFsPath string, enableBpfLog bool) *Controller {
@@ -54,6 +69,17 @@ func NewController(opts *options.BootstrapConfigs, bpfWorkloadObj *bpf.BpfKmeshW
 		enableSecretManager: opts.SecretManagerConfig.Enable,
 		bpfFsPath:           bpfFsPath,
 		enableBpfLog:        enableBpfLog,
+		waypointPort:        opts.WaypointConfig.Port,
+		identityVerifyMode:  opts.AuthConfig.IdentityVerify,
+		auditLogFile:        opts.AuthConfig.AuditLogFile,
+		auditAllowed:        opts.AuthConfig.AuditAllowed,
+		dryRunPolicies:      opts.AuthConfig.DryRunPolicies,
+		unknownDestination:  opts.AuthConfig.UnknownDestination,
+		interfaceNetworks:   opts.AuthConfig.InterfaceNetworks,
+		deniedTupleTTL:      opts.AuthConfig.DeniedTupleTTL,
+		enableAccessLog:     opts.TelemetryConfig.EnableAccessLog,
+		accessLogFormat:     opts.TelemetryConfig.AccessLogFormatType,
+		metricDroppedLabels: opts.TelemetryConfig.MetricDroppedLabels,
 	}
 }
 
@@ -97,6 +123,24 @@ func (c *Controller) Start(stopCh <-chan struct{}) error {
 	c.client = NewXdsClient(c.mode, c.bpfWorkloadObj)
 
 	if c.client.WorkloadController != nil {
+		c.client.WorkloadController.SetWaypointPort(c.waypointPort)
+		if err := c.client.WorkloadController.Rbac.SetIdentityVerifyMode(c.identityVerifyMode); err != nil {
+			return err
+		}
+		c.client.WorkloadController.Rbac.SetAuditLog(auth.NewAuditLog(c.auditLogFile, c.auditAllowed))
+		c.client.WorkloadController.Rbac.SetDryRunPolicies(c.dryRunPolicies)
+		if err := c.client.WorkloadController.Rbac.SetUnknownDestinationPolicy(c.unknownDestination); err != nil {
+			return err
+		}
+		c.client.WorkloadController.Rbac.SetDeniedTupleTTL(c.deniedTupleTTL)
+		nodeNetwork := string(config.GetConfig(c.mode).Metadata.Network)
+		if err := c.client.WorkloadController.Rbac.SetNetworks(nodeNetwork, c.interfaceNetworks); err != nil {
+			return err
+		}
+		c.client.WorkloadController.AccessLogger.SetAccessLog(c.enableAccessLog, c.accessLogFormat)
+		if err := telemetry.DropTrafficLabels(c.metricDroppedLabels); err != nil {
+			return fmt.Errorf("invalid metric labels: %v", err)
+		}
 		c.client.WorkloadController.Run(ctx)
 	}
 
@@ -119,6 +163,11 @@ func (c *Controller) Stop() {
 	cancel()
 	if c.client != nil {
 		c.client.Close()
+		if c.client.WorkloadController != nil {
+			if err := c.client.WorkloadController.Rbac.AuditLog().Close(); err != nil {
+				log.Errorf("close authorization audit log failed: %v", err)
+			}
+		}
 	}
 }
 
@@ -24,6 +24,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 
 	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/logger"
 )
 
@@ -194,8 +195,8 @@ func (s *SecretManager) rotateCerts() {
 	}
 }
 
-// addCert signs a cert for the identity and cache it.
-func (s *SecretManager) fetchCert(identity string) {
+// addCert signs a cert for the identity and cache it, a failed fetch is retried later.
+func (s *SecretManager) fetchCert(identity string) error {
 	newCert, err := s.caClient.FetchCert(identity)
 	if err != nil {
 		log.Errorf("fetchCert for [%v] error: %v", identity, err)
@@ -203,11 +204,12 @@ func (s *SecretManager) fetchCert(identity string) {
 		time.AfterFunc(time.Second, func() {
 			s.SendCertRequest(identity, RETRY)
 		})
-		return
+		return err
 	}
 
 	// Save the new certificate in the map and add a record to the rotate queue
 	s.StoreCert(identity, newCert)
+	return nil
 }
 
 // Set the removed to true for the items in the certsRotateQueue priority queue.
@@ -242,7 +244,9 @@ func (s *SecretManager) rotateCert(identity string) {
 		log.Debugf("cert %s expire at %T, skip rotate now", identity, certificate.cert.ExpireTime)
 	}
 
-	go s.fetchCert(identity)
+	go func() {
+		telemetry.RecordCertRotation(s.fetchCert(identity))
+	}()
 }
 
 func (s *SecretManager) retryFetchCert(identity string) {
@@ -0,0 +1,290 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"golang.org/x/sys/unix"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+// AccessLogFormat is the format the access log lines are written in.
+type AccessLogFormat int
+
+const (
+	AccessLogText AccessLogFormat = iota
+	AccessLogJSON
+)
+
+var accessLogFormats = map[string]AccessLogFormat{
+	"text": AccessLogText,
+	"json": AccessLogJSON,
+}
+
+func ParseAccessLogFormat(format string) (AccessLogFormat, error) {
+	if f, ok := accessLogFormats[strings.ToLower(format)]; ok {
+		return f, nil
+	}
+	return AccessLogText, fmt.Errorf("invalid access log format %q, valid values are [text, json]", format)
+}
+
+func (f AccessLogFormat) String() string {
+	if f == AccessLogJSON {
+		return "json"
+	}
+	return "text"
+}
+
+// accessLogRecord is the struct access_log reported by the bpf progs when a connection closes
+type accessLogRecord struct {
+	// Tuple is a struct bpf_sock_tuple, src is the local end of the socket, all fields are big endian
+	Tuple         [36]byte
+	_             [4]byte
+	Duration      uint64
+	CloseNs       uint64
+	Family        uint32
+	Protocol      uint32
+	Direction     uint8
+	_             [3]byte
+	SentBytes     uint32
+	ReceivedBytes uint32
+	ConnSuccess   uint32
+}
+
+// AccessLogEntry is a connection in the access log, src is the client and dst is the server.
+type AccessLogEntry struct {
+	StartTime     time.Time `json:"start_time"`
+	Protocol      string    `json:"protocol"`
+	Direction     string    `json:"direction"`
+	ResponseFlags string    `json:"response_flags"`
+	SrcAddr       string    `json:"src.addr"`
+	SrcWorkload   string    `json:"src.workload,omitempty"`
+	SrcNamespace  string    `json:"src.namespace,omitempty"`
+	SrcIdentity   string    `json:"src.identity,omitempty"`
+	DstAddr       string    `json:"dst.addr"`
+	DstWorkload   string    `json:"dst.workload,omitempty"`
+	DstNamespace  string    `json:"dst.namespace,omitempty"`
+	DstIdentity   string    `json:"dst.identity,omitempty"`
+	// SentBytes and ReceivedBytes are counted on the socket of kmesh's side, the client for an
+	// outbound connection and the server for an inbound one
+	SentBytes     uint32 `json:"bytes_sent"`
+	ReceivedBytes uint32 `json:"bytes_received"`
+	DurationMs    uint64 `json:"duration_ms"`
+}
+
+// AccessLogger writes a line for each connection closed on the node, the records of the bpf progs
+// are always drained so that the ringbuf does not fill up while the access log is disabled.
+type AccessLogger struct {
+	workloadCache cache.WorkloadCache
+
+	mutex   sync.Mutex
+	enabled bool
+	format  AccessLogFormat
+	writer  io.Writer
+}
+
+func NewAccessLogger(workloadCache cache.WorkloadCache) *AccessLogger {
+	return &AccessLogger{
+		workloadCache: workloadCache,
+		writer:        os.Stdout,
+	}
+}
+
+// SetAccessLog enables or disables the access log and sets its format, it can be called at any time.
+func (a *AccessLogger) SetAccessLog(enabled bool, format AccessLogFormat) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.enabled = enabled
+	a.format = format
+}
+
+// AccessLog returns whether the access log is enabled and its format.
+func (a *AccessLogger) AccessLog() (bool, AccessLogFormat) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.enabled, a.format
+}
+
+func (a *AccessLogger) Run(ctx context.Context, mapOfAccessLog *ebpf.Map) {
+	if a == nil || mapOfAccessLog == nil {
+		return
+	}
+
+	reader, err := ringbuf.NewReader(mapOfAccessLog)
+	if err != nil {
+		log.Errorf("open access log ringbuf map FAILED, err: %v", err)
+		return
+	}
+	// the reader blocks in ReadInto until it is closed
+	go func() {
+		<-ctx.Done()
+		if err := reader.Close(); err != nil {
+			log.Errorf("access log ringbuf reader Close FAILED, err: %v", err)
+		}
+	}()
+
+	rec := ringbuf.Record{}
+	for {
+		if err := reader.ReadInto(&rec); err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			log.Errorf("access log ringbuf reader FAILED to read, err: %v", err)
+			continue
+		}
+		if enabled, _ := a.AccessLog(); !enabled {
+			continue
+		}
+
+		var record accessLogRecord
+		if err := binary.Read(bytes.NewReader(rec.RawSample), binary.LittleEndian, &record); err != nil {
+			log.Errorf("decode access log record FAILED, err: %v", err)
+			continue
+		}
+		a.write(a.buildEntry(&record, bpfTimeToWall(record.CloseNs)))
+	}
+}
+
+// buildEntry enriches the record with the workloads of its ends, closeTime is the wall time the
+// connection closed at.
+func (a *AccessLogger) buildEntry(record *accessLogRecord, closeTime time.Time) *AccessLogEntry {
+	local, remote := record.addrs()
+	entry := &AccessLogEntry{
+		StartTime:     closeTime.Add(-time.Duration(record.Duration)).UTC(),
+		Protocol:      "TCP",
+		Direction:     "-",
+		ResponseFlags: "-",
+		SrcAddr:       local.String(),
+		DstAddr:       remote.String(),
+		SentBytes:     record.SentBytes,
+		ReceivedBytes: record.ReceivedBytes,
+		DurationMs:    record.Duration / uint64(time.Millisecond),
+	}
+	if record.Protocol != unix.IPPROTO_TCP {
+		entry.Protocol = fmt.Sprintf("%d", record.Protocol)
+	}
+	if record.ConnSuccess == 0 {
+		// the connection to the upstream failed, as UF of envoy
+		entry.ResponseFlags = "UF"
+	}
+
+	srcAddr, dstAddr := local, remote
+	switch uint32(record.Direction) {
+	case constants.OUTBOUND:
+		entry.Direction = "OUTBOUND"
+	case constants.INBOUND:
+		entry.Direction = "INBOUND"
+		// kmesh's side is the server of an inbound connection
+		srcAddr, dstAddr = remote, local
+		entry.SrcAddr, entry.DstAddr = remote.String(), local.String()
+	}
+
+	if workload := a.getWorkload(srcAddr.Addr()); workload != nil {
+		entry.SrcWorkload, entry.SrcNamespace = workload.GetWorkloadName(), workload.GetNamespace()
+		entry.SrcIdentity = buildPrincipal(workload)
+	}
+	if workload := a.getWorkload(dstAddr.Addr()); workload != nil {
+		entry.DstWorkload, entry.DstNamespace = workload.GetWorkloadName(), workload.GetNamespace()
+		entry.DstIdentity = buildPrincipal(workload)
+	}
+	return entry
+}
+
+// addrs returns the local and the remote end of the socket
+func (r *accessLogRecord) addrs() (netip.AddrPort, netip.AddrPort) {
+	if r.Family == unix.AF_INET {
+		src := netip.AddrFrom4([4]byte(r.Tuple[0:4]))
+		dst := netip.AddrFrom4([4]byte(r.Tuple[4:8]))
+		return netip.AddrPortFrom(src, binary.BigEndian.Uint16(r.Tuple[8:10])),
+			netip.AddrPortFrom(dst, binary.BigEndian.Uint16(r.Tuple[10:12]))
+	}
+	src := netip.AddrFrom16([16]byte(r.Tuple[0:16])).Unmap()
+	dst := netip.AddrFrom16([16]byte(r.Tuple[16:32])).Unmap()
+	return netip.AddrPortFrom(src, binary.BigEndian.Uint16(r.Tuple[32:34])),
+		netip.AddrPortFrom(dst, binary.BigEndian.Uint16(r.Tuple[34:36]))
+}
+
+func (a *AccessLogger) getWorkload(addr netip.Addr) *workloadapi.Workload {
+	if a.workloadCache == nil {
+		return nil
+	}
+	return a.workloadCache.GetWorkloadByAddr(cache.NetworkAddress{Address: addr})
+}
+
+func (a *AccessLogger) write(entry *AccessLogEntry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var line []byte
+	if a.format == AccessLogJSON {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			log.Errorf("marshal access log entry FAILED, err: %v", err)
+			return
+		}
+		line = append(data, '\n')
+	} else {
+		line = []byte(entry.String() + "\n")
+	}
+	if _, err := a.writer.Write(line); err != nil {
+		log.Errorf("write access log FAILED, err: %v", err)
+	}
+}
+
+// String formats the entry as a text access log line.
+func (e *AccessLogEntry) String() string {
+	return fmt.Sprintf("[%s] %s %s %s src.addr=%s src.workload=%s src.namespace=%s src.identity=%s "+
+		"dst.addr=%s dst.workload=%s dst.namespace=%s dst.identity=%s bytes_sent=%d bytes_received=%d duration=%dms",
+		e.StartTime.Format(time.RFC3339Nano), e.Protocol, e.Direction, e.ResponseFlags,
+		e.SrcAddr, orDash(e.SrcWorkload), orDash(e.SrcNamespace), orDash(e.SrcIdentity),
+		e.DstAddr, orDash(e.DstWorkload), orDash(e.DstNamespace), orDash(e.DstIdentity),
+		e.SentBytes, e.ReceivedBytes, e.DurationMs)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// bpfTimeToWall converts a bpf_ktime_get_ns timestamp to the wall time
+func bpfTimeToWall(ns uint64) time.Time {
+	now := time.Now()
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return now
+	}
+	return now.Add(-time.Duration(uint64(ts.Nano()) - ns))
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+// rawAccessLog lays out a struct access_log as the bpf progs do
+func rawAccessLog(tuple []byte, family uint32, direction uint8, success uint32) []byte {
+	raw := make([]byte, 80)
+	copy(raw, tuple)
+	binary.LittleEndian.PutUint64(raw[40:], uint64(1500*time.Millisecond)) // duration
+	binary.LittleEndian.PutUint64(raw[48:], 123456789)                     // close_ns
+	binary.LittleEndian.PutUint32(raw[56:], family)
+	binary.LittleEndian.PutUint32(raw[60:], unix.IPPROTO_TCP)
+	raw[64] = direction
+	binary.LittleEndian.PutUint32(raw[68:], 100) // sent_bytes
+	binary.LittleEndian.PutUint32(raw[72:], 200) // received_bytes
+	binary.LittleEndian.PutUint32(raw[76:], success)
+	return raw
+}
+
+func decodeAccessLog(t *testing.T, raw []byte) *accessLogRecord {
+	var record accessLogRecord
+	assert.Equal(t, len(raw), binary.Size(&record))
+	assert.NoError(t, binary.Read(bytes.NewReader(raw), binary.LittleEndian, &record))
+	return &record
+}
+
+func TestAccessLogger_buildEntry(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:            "cluster0//Pod/default/client",
+		Namespace:      "default",
+		WorkloadName:   "client",
+		TrustDomain:    "cluster.local",
+		ServiceAccount: "sleep",
+		Addresses:      [][]byte{netip.MustParseAddr("10.0.0.1").AsSlice()},
+	})
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:          "cluster0//Pod/default/server",
+		Namespace:    "default",
+		WorkloadName: "server",
+		Addresses:    [][]byte{netip.MustParseAddr("fd00::2").AsSlice()},
+	})
+	a := NewAccessLogger(workloadCache)
+	closeTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// an outbound connection from the client 10.0.0.1:40000 to 10.0.0.2:8080
+	tuple := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x9c, 0x40, 0x1f, 0x90}
+	entry := a.buildEntry(decodeAccessLog(t, rawAccessLog(tuple, unix.AF_INET, uint8(constants.OUTBOUND), 1)), closeTime)
+	assert.Equal(t, &AccessLogEntry{
+		StartTime:     closeTime.Add(-1500 * time.Millisecond),
+		Protocol:      "TCP",
+		Direction:     "OUTBOUND",
+		ResponseFlags: "-",
+		SrcAddr:       "10.0.0.1:40000",
+		SrcWorkload:   "client",
+		SrcNamespace:  "default",
+		SrcIdentity:   "spiffe://cluster.local/ns/default/sa/sleep",
+		DstAddr:       "10.0.0.2:8080",
+		SentBytes:     100,
+		ReceivedBytes: 200,
+		DurationMs:    1500,
+	}, entry)
+
+	// a failed inbound connection on the server fd00::2:8080 from fd00::1:40000
+	tuple = make([]byte, 36)
+	copy(tuple, netip.MustParseAddr("fd00::2").AsSlice())
+	copy(tuple[16:], netip.MustParseAddr("fd00::1").AsSlice())
+	copy(tuple[32:], []byte{0x1f, 0x90, 0x9c, 0x40})
+	entry = a.buildEntry(decodeAccessLog(t, rawAccessLog(tuple, unix.AF_INET6, uint8(constants.INBOUND), 0)), closeTime)
+	assert.Equal(t, "INBOUND", entry.Direction)
+	assert.Equal(t, "UF", entry.ResponseFlags)
+	assert.Equal(t, "[fd00::1]:40000", entry.SrcAddr)
+	assert.Equal(t, "[fd00::2]:8080", entry.DstAddr)
+	assert.Equal(t, "", entry.SrcWorkload)
+	assert.Equal(t, "server", entry.DstWorkload)
+	assert.Equal(t, "-", entry.DstIdentity)
+}
+
+func TestAccessLogger_write(t *testing.T) {
+	entry := &AccessLogEntry{
+		StartTime:     time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Protocol:      "TCP",
+		Direction:     "OUTBOUND",
+		ResponseFlags: "-",
+		SrcAddr:       "10.0.0.1:40000",
+		SrcWorkload:   "client",
+		SrcNamespace:  "default",
+		DstAddr:       "10.0.0.2:8080",
+		SentBytes:     100,
+		ReceivedBytes: 200,
+		DurationMs:    1500,
+	}
+	var out bytes.Buffer
+	a := NewAccessLogger(nil)
+	a.writer = &out
+
+	a.write(entry)
+	assert.Equal(t, "[2024-06-01T12:00:00Z] TCP OUTBOUND - src.addr=10.0.0.1:40000 src.workload=client src.namespace=default src.identity=- "+
+		"dst.addr=10.0.0.2:8080 dst.workload=- dst.namespace=- dst.identity=- bytes_sent=100 bytes_received=200 duration=1500ms\n", out.String())
+
+	out.Reset()
+	a.SetAccessLog(true, AccessLogJSON)
+	a.write(entry)
+	var decoded AccessLogEntry
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, *entry, decoded)
+
+	enabled, format := a.AccessLog()
+	assert.True(t, enabled)
+	assert.Equal(t, "json", format.String())
+}
+
+func TestParseAccessLogFormat(t *testing.T) {
+	format, err := ParseAccessLogFormat("JSON")
+	assert.NoError(t, err)
+	assert.Equal(t, AccessLogJSON, format)
+	format, err = ParseAccessLogFormat("text")
+	assert.NoError(t, err)
+	assert.Equal(t, AccessLogText, format)
+	_, err = ParseAccessLogFormat("xml")
+	assert.Error(t, err)
+}
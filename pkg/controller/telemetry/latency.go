@@ -0,0 +1,143 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// latencyBuckets is METRIC_LATENCY_BUCKETS of the bpf progs
+const latencyBuckets = 6
+
+// The upper bounds of the buckets in seconds, they are the connect_latency_bounds and the
+// conn_duration_bounds of the bpf progs.
+var (
+	connectLatencyBounds     = []float64{0.001, 0.005, 0.025, 0.1, 1}
+	connectionDurationBounds = []float64{0.1, 1, 10, 60, 600}
+)
+
+// latencyHistogram is the struct latency_hist of the bpf map, the last bucket counts the values
+// above all the bounds.
+type latencyHistogram struct {
+	Buckets [latencyBuckets]uint64
+	SumNs   uint64
+}
+
+// decreased returns whether any bucket or the sum is lower than the one of last
+func (h *latencyHistogram) decreased(last *latencyHistogram) bool {
+	for i := range h.Buckets {
+		if h.Buckets[i] < last.Buckets[i] {
+			return true
+		}
+	}
+	return h.SumNs < last.SumNs
+}
+
+func (h *latencyHistogram) sub(last *latencyHistogram) latencyHistogram {
+	delta := latencyHistogram{SumNs: h.SumNs - last.SumNs}
+	for i := range h.Buckets {
+		delta.Buckets[i] = h.Buckets[i] - last.Buckets[i]
+	}
+	return delta
+}
+
+func (h *latencyHistogram) count() uint64 {
+	var count uint64
+	for _, n := range h.Buckets {
+		count += n
+	}
+	return count
+}
+
+// histogramVec is a histogram vector whose values are bucketed by the bpf progs, a
+// prometheus.HistogramVec can only observe the values one by one.
+type histogramVec struct {
+	desc   *prometheus.Desc
+	bounds []float64
+
+	mutex  sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	buckets     [latencyBuckets]uint64
+	sum         float64
+}
+
+func newHistogramVec(name, help string, bounds []float64, labels []string) *histogramVec {
+	return &histogramVec{
+		desc:   prometheus.NewDesc(name, help, labels, nil),
+		bounds: bounds,
+		series: make(map[string]*histogramSeries),
+	}
+}
+
+// add adds the increments of the bpf histogram to the series of the label values
+func (h *histogramVec) add(labelValues []string, delta *latencyHistogram) {
+	if delta.count() == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{labelValues: labelValues}
+		h.series[key] = series
+	}
+	for i := range delta.Buckets {
+		series.buckets[i] += delta.Buckets[i]
+	}
+	series.sum += time.Duration(delta.SumNs).Seconds()
+}
+
+func (h *histogramVec) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.series = make(map[string]*histogramSeries)
+}
+
+func (h *histogramVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *histogramVec) Collect(ch chan<- prometheus.Metric) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, series := range h.series {
+		// the prometheus buckets are cumulative, the values above all the bounds are only in the count
+		buckets := make(map[float64]uint64, len(h.bounds))
+		var count uint64
+		for i, bound := range h.bounds {
+			count += series.buckets[i]
+			buckets[bound] = count
+		}
+		count += series.buckets[len(h.bounds)]
+		metric, err := prometheus.NewConstHistogram(h.desc, count, series.sum, buckets, series.labelValues...)
+		if err != nil {
+			log.Errorf("build histogram %s FAILED, err: %v", h.desc, err)
+			continue
+		}
+		ch <- metric
+	}
+}
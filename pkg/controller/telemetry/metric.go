@@ -20,9 +20,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net/netip"
 	"reflect"
+	"sort"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/ringbuf"
@@ -32,31 +35,52 @@ import (
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
 )
 
+// metricPrunePeriod is how often the last values of the entries evicted from the bpf map are dropped
+const metricPrunePeriod = time.Minute
+
 type MetricController struct {
 	workloadCache cache.WorkloadCache
+	// serviceCache resolves the services the destination workloads are reached through
+	serviceCache cache.ServiceCache
+	// last holds the values of the bpf map entries when they were last read, the counters are
+	// increased by the difference
+	last      map[metricKey]metricValue
+	lastPrune time.Time
 }
 
+// metricKey is the struct metric_key of the bpf map
 type metricKey struct {
-	SrcIp [4]uint32
-	DstIp [4]uint32
+	SrcIp     [4]uint32
+	DstIp     [4]uint32
+	Direction uint32
+	DstPort   uint32
 }
 
+// metricValue is the struct metric_data of the bpf map
 type metricValue struct {
 	Direction        uint32
-	ConnectionOpen   uint32
-	ConnectionClose  uint32
-	ConnectionFailed uint32
-	SentBytes        uint32
-	ReceivedBytes    uint32
+	_                uint32
+	ConnectionOpen   uint64
+	ConnectionClose  uint64
+	ConnectionFailed uint64
+	SentBytes        uint64
+	ReceivedBytes    uint64
+	ConnectLatency   latencyHistogram
+	Duration         latencyHistogram
 }
 
+// requestMetric holds the increments of the counters since the entry was last read
 type requestMetric struct {
 	src              [4]uint32
 	dst              [4]uint32
-	connectionOpened uint32
-	connectionClosed uint32
-	receivedBytes    uint32
-	sentBytes        uint32
+	dstPort          uint32
+	connectionOpened uint64
+	connectionClosed uint64
+	connectionFailed uint64
+	receivedBytes    uint64
+	sentBytes        uint64
+	connectLatency   latencyHistogram
+	duration         latencyHistogram
 	success          bool
 }
 
@@ -89,9 +113,12 @@ type commonTrafficLabels struct {
 	connectionSecurityPolicy string
 }
 
-func NewMetric(workloadCache cache.WorkloadCache) *MetricController {
+func NewMetric(workloadCache cache.WorkloadCache, serviceCache cache.ServiceCache) *MetricController {
 	return &MetricController{
 		workloadCache: workloadCache,
+		serviceCache:  serviceCache,
+		last:          make(map[metricKey]metricValue),
+		lastPrune:     time.Now(),
 	}
 }
 
@@ -111,14 +138,7 @@ func (m *MetricController) Run(ctx context.Context, mapOfMetricNotify, mapOfMetr
 		}
 	}()
 
-	// Register metrics to Prometheus and start Prometheus server
-	go RunPrometheusClient(ctx)
-
 	rec := ringbuf.Record{}
-	key := metricKey{}
-	value := metricValue{}
-	data := requestMetric{}
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -128,40 +148,99 @@ func (m *MetricController) Run(ctx context.Context, mapOfMetricNotify, mapOfMetr
 				log.Errorf("ringbuf reader FAILED to read, err: %v", err)
 				continue
 			}
+			m.updateMetric(rec.RawSample, mapOfMetric)
+			m.prune(mapOfMetric)
+		}
+	}
+}
 
-			buf := bytes.NewBuffer(rec.RawSample)
-			if err := binary.Read(buf, binary.LittleEndian, &key); err != nil {
-				log.Error("get metric key FAILED, err:", err)
-				continue
-			}
+// updateMetric reports the increments of the bpf map entry whose key is notified in rawKey
+func (m *MetricController) updateMetric(rawKey []byte, mapOfMetric *ebpf.Map) {
+	key := metricKey{}
+	value := metricValue{}
 
-			if err := mapOfMetric.Lookup(&key, &value); err != nil {
-				log.Error("get bpf map of metric FAILED, err:", err)
-				continue
-			}
+	buf := bytes.NewBuffer(rawKey)
+	if err := binary.Read(buf, binary.LittleEndian, &key); err != nil {
+		log.Error("get metric key FAILED, err:", err)
+		return
+	}
 
-			data.src = key.SrcIp
-			data.dst = key.DstIp
-			data.connectionClosed = value.ConnectionClose
-			data.connectionOpened = value.ConnectionOpen
-			data.sentBytes = value.SentBytes
-			data.receivedBytes = value.ReceivedBytes
-			data.success = true
-
-			commonTrafficLabels, err := m.buildMetric(&data)
-			if err != nil {
-				log.Warnf("reporter records error")
-			}
+	if err := mapOfMetric.Lookup(&key, &value); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			// evicted before it is read, the increments since the last read are lost
+			delete(m.last, key)
+		} else {
+			log.Error("get bpf map of metric FAILED, err:", err)
+		}
+		return
+	}
 
-			commonTrafficLabels.direction = "-"
-			if value.Direction == constants.INBOUND {
-				commonTrafficLabels.direction = "INBOUND"
-			}
-			if value.Direction == constants.OUTBOUND {
-				commonTrafficLabels.direction = "OUTBOUND"
-			}
+	delta := m.delta(key, value)
+	data := requestMetric{
+		src:              key.SrcIp,
+		dst:              key.DstIp,
+		dstPort:          key.DstPort,
+		connectionClosed: delta.ConnectionClose,
+		connectionOpened: delta.ConnectionOpen,
+		connectionFailed: delta.ConnectionFailed,
+		sentBytes:        delta.SentBytes,
+		receivedBytes:    delta.ReceivedBytes,
+		connectLatency:   delta.ConnectLatency,
+		duration:         delta.Duration,
+		success:          true,
+	}
+
+	commonTrafficLabels, err := m.buildMetric(&data)
+	if err != nil {
+		log.Warnf("reporter records error")
+	}
+
+	commonTrafficLabels.direction = "-"
+	if value.Direction == constants.INBOUND {
+		commonTrafficLabels.direction = "INBOUND"
+	}
+	if value.Direction == constants.OUTBOUND {
+		commonTrafficLabels.direction = "OUTBOUND"
+	}
+
+	buildMetricsToPrometheus(data, commonTrafficLabels)
+}
+
+// delta returns the increments of the entry since it was last read. An entry evicted from the LRU
+// map starts over from zero, so a counter lower than the last read one means the entry was
+// recreated and all of its value is new.
+func (m *MetricController) delta(key metricKey, value metricValue) metricValue {
+	last, ok := m.last[key]
+	m.last[key] = value
+	if !ok || value.ConnectionOpen < last.ConnectionOpen || value.ConnectionClose < last.ConnectionClose ||
+		value.ConnectionFailed < last.ConnectionFailed || value.SentBytes < last.SentBytes ||
+		value.ReceivedBytes < last.ReceivedBytes || value.ConnectLatency.decreased(&last.ConnectLatency) ||
+		value.Duration.decreased(&last.Duration) {
+		return value
+	}
+	return metricValue{
+		Direction:        value.Direction,
+		ConnectionOpen:   value.ConnectionOpen - last.ConnectionOpen,
+		ConnectionClose:  value.ConnectionClose - last.ConnectionClose,
+		ConnectionFailed: value.ConnectionFailed - last.ConnectionFailed,
+		SentBytes:        value.SentBytes - last.SentBytes,
+		ReceivedBytes:    value.ReceivedBytes - last.ReceivedBytes,
+		ConnectLatency:   value.ConnectLatency.sub(&last.ConnectLatency),
+		Duration:         value.Duration.sub(&last.Duration),
+	}
+}
+
+// prune drops the last values of the entries evicted from the bpf map every metricPrunePeriod
+func (m *MetricController) prune(mapOfMetric *ebpf.Map) {
+	if time.Since(m.lastPrune) < metricPrunePeriod {
+		return
+	}
+	m.lastPrune = time.Now()
 
-			buildMetricsToPrometheus(data, commonTrafficLabels)
+	var value metricValue
+	for key := range m.last {
+		if err := mapOfMetric.Lookup(&key, &value); errors.Is(err, ebpf.ErrKeyNotExist) {
+			delete(m.last, key)
 		}
 	}
 }
@@ -173,11 +252,15 @@ func (m *MetricController) buildMetric(data *requestMetric) (commonTrafficLabels
 		srcAddr = binary.LittleEndian.AppendUint32(srcAddr, data.src[i])
 	}
 
-	dstWorkload, dstIP := m.getWorkloadByAddress(restoreIPv4(dstAddr))
+	dstWorkload, _ := m.getWorkloadByAddress(restoreIPv4(dstAddr))
 	srcWorkload, _ := m.getWorkloadByAddress(restoreIPv4(srcAddr))
 
 	trafficLabels := buildMetricFromWorkload(dstWorkload, srcWorkload)
-	trafficLabels.destinationService = dstIP
+	if svc := m.getServiceByWorkloadPort(dstWorkload, data.dstPort); svc != nil {
+		trafficLabels.destinationService = svc.GetHostname()
+		trafficLabels.destinationServiceName = svc.GetName()
+		trafficLabels.destinationServiceNamespace = svc.GetNamespace()
+	}
 
 	trafficLabels.requestProtocol = "tcp"
 	trafficLabels.responseFlags = "-"
@@ -197,6 +280,36 @@ func (m *MetricController) getWorkloadByAddress(address []byte) (*workloadapi.Wo
 	return workload, networkAddr.Address.String()
 }
 
+// getServiceByWorkloadPort returns the service of the workload whose target port is port, the
+// connections are redirected to the backends before they are observed so the service is not
+// known from the address.
+func (m *MetricController) getServiceByWorkloadPort(workload *workloadapi.Workload, port uint32) *workloadapi.Service {
+	if workload == nil || m.serviceCache == nil {
+		return nil
+	}
+	names := make([]string, 0, len(workload.GetServices()))
+	for name := range workload.GetServices() {
+		names = append(names, name)
+	}
+	// the first one in order if several services of the workload share the target port
+	sort.Strings(names)
+	for _, name := range names {
+		for _, p := range workload.GetServices()[name].GetPorts() {
+			targetPort := p.GetTargetPort()
+			if targetPort == 0 {
+				targetPort = p.GetServicePort()
+			}
+			if targetPort != port {
+				continue
+			}
+			if svc := m.serviceCache.GetService(name); svc != nil {
+				return svc
+			}
+		}
+	}
+	return nil
+}
+
 func buildMetricFromWorkload(dstWorkload, srcWorkload *workloadapi.Workload) commonTrafficLabels {
 	if dstWorkload == nil || srcWorkload == nil {
 		return commonTrafficLabels{}
@@ -237,10 +350,23 @@ func buildPrincipal(workload *workloadapi.Workload) string {
 
 func buildMetricsToPrometheus(data requestMetric, labels commonTrafficLabels) {
 	commonLabels := commonTrafficLabels2map(&labels)
-	tcpConnectionOpened.With(commonLabels).Set(float64(data.connectionOpened))
-	tcpConnectionClosed.With(commonLabels).Set(float64(data.connectionClosed))
-	tcpReceivedBytes.With(commonLabels).Set(float64(data.receivedBytes))
-	tcpSentBytes.With(commonLabels).Set(float64(data.sentBytes))
+	for _, name := range droppedLabels {
+		delete(commonLabels, name)
+	}
+	tcpConnectionOpened.With(commonLabels).Add(float64(data.connectionOpened))
+	tcpConnectionClosed.With(commonLabels).Add(float64(data.connectionClosed))
+	tcpConnectionFailed.With(commonLabels).Add(float64(data.connectionFailed))
+	tcpReceivedBytes.With(commonLabels).Add(float64(data.receivedBytes))
+	tcpSentBytes.With(commonLabels).Add(float64(data.sentBytes))
+
+	latencyLabelValues := []string{
+		orDash(labels.sourceWorkload),
+		orDash(labels.sourceWorkloadNamespace),
+		orDash(labels.destinationWorkload),
+		orDash(labels.destinationWorkloadNamespace),
+	}
+	tcpConnectLatency.add(latencyLabelValues, &data.connectLatency)
+	tcpConnectionDuration.add(latencyLabelValues, &data.duration)
 }
 
 func commonTrafficLabels2map(labels *commonTrafficLabels) map[string]string {
@@ -17,15 +17,18 @@
 package telemetry
 
 import (
-	"context"
+	"encoding/binary"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/cilium/ebpf"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
 )
 
@@ -171,7 +174,7 @@ func TestCommonTrafficLabels2map(t *testing.T) {
 }
 
 func TestBuildMetricsToPrometheus(t *testing.T) {
-	metrics := []*prometheus.GaugeVec{
+	metrics := []*prometheus.CounterVec{
 		tcpConnectionClosed,
 		tcpConnectionOpened,
 		tcpReceivedBytes,
@@ -235,21 +238,22 @@ func TestBuildMetricsToPrometheus(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ctx, cancel := context.WithCancel(context.Background())
-			go RunPrometheusClient(ctx)
+			// the counters are accumulated across the tests
+			for _, metric := range metrics {
+				metric.Reset()
+			}
 			buildMetricsToPrometheus(tt.args.data, tt.args.labels)
 			commonLabels := commonTrafficLabels2map(&tt.args.labels)
 			for index, metric := range metrics {
-				if gauge, err := metric.GetMetricWith(commonLabels); err != nil {
+				if counter, err := metric.GetMetricWith(commonLabels); err != nil {
 					t.Errorf("use labels to get %v failed", metric)
 				} else {
 					var m dto.Metric
-					gauge.Write(&m)
-					value := m.Gauge.Value
+					counter.Write(&m)
+					value := m.Counter.Value
 					assert.Equal(t, tt.want[index], *value)
 				}
 			}
-			cancel()
 		})
 	}
 }
@@ -376,6 +380,16 @@ func TestMetricBuildMetric(t *testing.T) {
 		Addresses: [][]byte{
 			{192, 168, 224, 22},
 		},
+		Services: map[string]*workloadapi.PortList{
+			"kmesh-system/kmesh.kmesh-system.svc.cluster.local": {
+				Ports: []*workloadapi.Port{{ServicePort: 80, TargetPort: 8080}},
+			},
+		},
+	}
+	svc := &workloadapi.Service{
+		Name:      "kmesh-svc",
+		Namespace: "kmesh-system",
+		Hostname:  "kmesh.kmesh-system.svc.cluster.local",
 	}
 	srcWorkload := &workloadapi.Workload{
 		Namespace:         "kmesh-system",
@@ -406,10 +420,51 @@ func TestMetricBuildMetric(t *testing.T) {
 				data: &requestMetric{
 					src:              [4]uint32{521736970, 0, 0, 0},
 					dst:              [4]uint32{383822016, 0, 0, 0},
-					connectionOpened: uint32(16),
-					connectionClosed: uint32(8),
-					sentBytes:        uint32(156),
-					receivedBytes:    uint32(1024),
+					dstPort:          8080,
+					connectionOpened: uint64(16),
+					connectionClosed: uint64(8),
+					sentBytes:        uint64(156),
+					receivedBytes:    uint64(1024),
+					success:          true,
+				},
+			},
+			want: commonTrafficLabels{
+				sourceWorkload:               "kmesh-daemon",
+				sourceCanonicalService:       "srcCanonical",
+				sourceCanonicalRevision:      "srcVersion",
+				sourceWorkloadNamespace:      "kmesh-system",
+				sourcePrincipal:              "spiffe://cluster.local/ns/kmesh-system/sa/default",
+				sourceApp:                    "srcCanonical",
+				sourceVersion:                "srcVersion",
+				sourceCluster:                "Kubernetes",
+				destinationService:           "kmesh.kmesh-system.svc.cluster.local",
+				destinationServiceNamespace:  "kmesh-system",
+				destinationServiceName:       "kmesh-svc",
+				destinationWorkload:          "kmesh-daemon",
+				destinationCanonicalService:  "dstCanonical",
+				destinationCanonicalRevision: "dstVersion",
+				destinationWorkloadNamespace: "kmesh-system",
+				destinationPrincipal:         "spiffe://cluster.local/ns/kmesh-system/sa/default",
+				destinationApp:               "dstCanonical",
+				destinationVersion:           "dstVersion",
+				destinationCluster:           "Kubernetes",
+				requestProtocol:              "tcp",
+				responseFlags:                "-",
+				connectionSecurityPolicy:     "mutual_tls",
+			},
+			wantErr: false,
+		},
+		{
+			name: "the service is unknown for a port not of it",
+			args: args{
+				data: &requestMetric{
+					src:              [4]uint32{521736970, 0, 0, 0},
+					dst:              [4]uint32{383822016, 0, 0, 0},
+					dstPort:          9090,
+					connectionOpened: uint64(16),
+					connectionClosed: uint64(8),
+					sentBytes:        uint64(156),
+					receivedBytes:    uint64(1024),
 					success:          true,
 				},
 			},
@@ -422,7 +477,6 @@ func TestMetricBuildMetric(t *testing.T) {
 				sourceApp:                    "srcCanonical",
 				sourceVersion:                "srcVersion",
 				sourceCluster:                "Kubernetes",
-				destinationService:           "192.168.224.22",
 				destinationServiceNamespace:  "kmesh-system",
 				destinationServiceName:       "kmesh",
 				destinationWorkload:          "kmesh-daemon",
@@ -442,8 +496,11 @@ func TestMetricBuildMetric(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			serviceCache := cache.NewServiceCache()
+			serviceCache.AddOrUpdateService(svc)
 			m := MetricController{
 				workloadCache: cache.NewWorkloadCache(),
+				serviceCache:  serviceCache,
 			}
 			m.workloadCache.AddWorkload(dstWorkload)
 			m.workloadCache.AddWorkload(srcWorkload)
@@ -491,3 +548,200 @@ func TestByteToIpByte(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricController_delta(t *testing.T) {
+	m := NewMetric(cache.NewWorkloadCache(), cache.NewServiceCache())
+	key := metricKey{SrcIp: [4]uint32{1}, DstIp: [4]uint32{2}, Direction: 2, DstPort: 80}
+	// a counter beyond 32 bits does not wrap
+	big := uint64(1) << 33
+
+	tests := []struct {
+		name  string
+		value metricValue
+		want  metricValue
+	}{
+		{
+			name:  "first read reports the whole value",
+			value: metricValue{ConnectionOpen: 2, ConnectionClose: 1, SentBytes: big, ReceivedBytes: 10},
+			want:  metricValue{ConnectionOpen: 2, ConnectionClose: 1, SentBytes: big, ReceivedBytes: 10},
+		},
+		{
+			name:  "the increments since the last read",
+			value: metricValue{ConnectionOpen: 3, ConnectionClose: 2, SentBytes: big + 100, ReceivedBytes: 10},
+			want:  metricValue{ConnectionOpen: 1, ConnectionClose: 1, SentBytes: 100},
+		},
+		{
+			name:  "an entry recreated after eviction starts over",
+			value: metricValue{ConnectionOpen: 1, SentBytes: 50},
+			want:  metricValue{ConnectionOpen: 1, SentBytes: 50},
+		},
+		{
+			name:  "the recreated entry is the base of the next read",
+			value: metricValue{ConnectionOpen: 1, ConnectionClose: 1, SentBytes: 80},
+			want:  metricValue{ConnectionClose: 1, SentBytes: 30},
+		},
+		{
+			name: "the increments of the histograms",
+			value: metricValue{ConnectionOpen: 2, ConnectionClose: 1, SentBytes: 80,
+				ConnectLatency: latencyHistogram{Buckets: [latencyBuckets]uint64{1: 1}, SumNs: 2000000}},
+			want: metricValue{ConnectionOpen: 1,
+				ConnectLatency: latencyHistogram{Buckets: [latencyBuckets]uint64{1: 1}, SumNs: 2000000}},
+		},
+		{
+			name:  "a histogram lower than the last read one starts over",
+			value: metricValue{ConnectionOpen: 2, ConnectionClose: 1, SentBytes: 80},
+			want:  metricValue{ConnectionOpen: 2, ConnectionClose: 1, SentBytes: 80},
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, m.delta(key, tt.value), tt.name)
+	}
+}
+
+// rawMetricValue lays out a struct metric_data as the bpf progs do
+func rawMetricValue(opened, closed uint64, connect, duration latencyHistogram) []byte {
+	raw := make([]byte, 160)
+	binary.LittleEndian.PutUint32(raw[0:], constants.OUTBOUND)
+	binary.LittleEndian.PutUint64(raw[8:], opened)
+	binary.LittleEndian.PutUint64(raw[16:], closed)
+	// connect_latency follows the 5 counters and duration follows connect_latency
+	for i, hist := range []latencyHistogram{connect, duration} {
+		offset := 48 + i*56
+		for j, n := range hist.Buckets {
+			binary.LittleEndian.PutUint64(raw[offset+j*8:], n)
+		}
+		binary.LittleEndian.PutUint64(raw[offset+48:], hist.SumNs)
+	}
+	return raw
+}
+
+func TestMetricController_updateMetric(t *testing.T) {
+	assert.Equal(t, 160, binary.Size(metricValue{}))
+	mapOfMetric, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "map_of_metrics",
+		Type:       ebpf.LRUHash,
+		KeySize:    uint32(binary.Size(metricKey{})),
+		ValueSize:  uint32(binary.Size(metricValue{})),
+		MaxEntries: 16,
+	})
+	if err != nil {
+		t.Fatal("Create map_of_metrics failed, err: ", err)
+	}
+	defer mapOfMetric.Close()
+
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:          "cluster0//Pod/default/sleep",
+		Namespace:    "default",
+		WorkloadName: "sleep",
+		Addresses:    [][]byte{{10, 0, 0, 1}},
+	})
+	workloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:          "cluster0//Pod/default/tcp-echo",
+		Namespace:    "default",
+		WorkloadName: "tcp-echo",
+		Addresses:    [][]byte{{10, 0, 0, 2}},
+	})
+	m := NewMetric(workloadCache, cache.NewServiceCache())
+	tcpConnectLatency.Reset()
+	tcpConnectionDuration.Reset()
+
+	// the struct metric_key notified in the ringbuf
+	rawKey := make([]byte, 40)
+	copy(rawKey[0:], []byte{10, 0, 0, 1})
+	copy(rawKey[16:], []byte{10, 0, 0, 2})
+	binary.LittleEndian.PutUint32(rawKey[32:], constants.OUTBOUND)
+	binary.LittleEndian.PutUint32(rawKey[36:], 9000)
+
+	// a connection established in 2ms, which lasted 20s
+	connect := latencyHistogram{Buckets: [latencyBuckets]uint64{1: 1}, SumNs: 2000000}
+	duration := latencyHistogram{Buckets: [latencyBuckets]uint64{3: 1}, SumNs: 20000000000}
+	assert.NoError(t, mapOfMetric.Put(rawKey, rawMetricValue(1, 1, connect, duration)))
+	m.updateMetric(rawKey, mapOfMetric)
+	// the same connection read again is not observed twice
+	m.updateMetric(rawKey, mapOfMetric)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(tcpConnectLatency, tcpConnectionDuration)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, families, 2)
+	for _, family := range families {
+		assert.Len(t, family.GetMetric(), 1)
+		metric := family.GetMetric()[0]
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, map[string]string{
+			"source_workload":                "sleep",
+			"source_workload_namespace":      "default",
+			"destination_workload":           "tcp-echo",
+			"destination_workload_namespace": "default",
+		}, labels)
+
+		histogram := metric.GetHistogram()
+		assert.Equal(t, uint64(1), histogram.GetSampleCount())
+		buckets := histogram.GetBucket()
+		switch family.GetName() {
+		case "kmesh_tcp_connect_latency_seconds":
+			assert.Equal(t, 0.002, histogram.GetSampleSum())
+			assert.Len(t, buckets, len(connectLatencyBounds))
+			// 2ms is in the 5ms bucket and all the ones above it
+			assert.Equal(t, 0.005, buckets[1].GetUpperBound())
+			assert.Equal(t, uint64(0), buckets[0].GetCumulativeCount())
+			assert.Equal(t, uint64(1), buckets[1].GetCumulativeCount())
+			assert.Equal(t, uint64(1), buckets[len(buckets)-1].GetCumulativeCount())
+		case "kmesh_tcp_connection_duration_seconds":
+			assert.Equal(t, float64(20), histogram.GetSampleSum())
+			assert.Equal(t, float64(60), buckets[3].GetUpperBound())
+			assert.Equal(t, uint64(0), buckets[2].GetCumulativeCount())
+			assert.Equal(t, uint64(1), buckets[3].GetCumulativeCount())
+		default:
+			t.Errorf("unexpected metric %s", family.GetName())
+		}
+	}
+
+	// a connection above all the bounds is only in the count
+	connect.Buckets[5], connect.SumNs = 1, connect.SumNs+2000000000
+	duration.Buckets[5], duration.SumNs = 1, duration.SumNs+3600000000000
+	assert.NoError(t, mapOfMetric.Put(rawKey, rawMetricValue(2, 2, connect, duration)))
+	m.updateMetric(rawKey, mapOfMetric)
+	families, err = registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		histogram := family.GetMetric()[0].GetHistogram()
+		assert.Equal(t, uint64(2), histogram.GetSampleCount())
+		assert.Equal(t, uint64(1), histogram.GetBucket()[len(histogram.GetBucket())-1].GetCumulativeCount())
+	}
+}
+
+func TestMetricController_prune(t *testing.T) {
+	mapOfMetric, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "map_of_metrics",
+		Type:       ebpf.LRUHash,
+		KeySize:    uint32(binary.Size(metricKey{})),
+		ValueSize:  uint32(binary.Size(metricValue{})),
+		MaxEntries: 16,
+	})
+	if err != nil {
+		t.Fatal("Create map_of_metrics failed, err: ", err)
+	}
+	defer mapOfMetric.Close()
+
+	m := NewMetric(cache.NewWorkloadCache(), cache.NewServiceCache())
+	kept := metricKey{DstPort: 80}
+	evicted := metricKey{DstPort: 8080}
+	assert.NoError(t, mapOfMetric.Put(&kept, &metricValue{ConnectionOpen: 1}))
+	m.delta(kept, metricValue{ConnectionOpen: 1})
+	m.delta(evicted, metricValue{ConnectionOpen: 1})
+
+	// nothing is pruned within the period
+	m.prune(mapOfMetric)
+	assert.Len(t, m.last, 2)
+
+	m.lastPrune = time.Now().Add(-metricPrunePeriod)
+	m.prune(mapOfMetric)
+	assert.Len(t, m.last, 1)
+	assert.Contains(t, m.last, kept)
+}
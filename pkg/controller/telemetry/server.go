@@ -0,0 +1,121 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	DefaultMetricsAddr = ":15020"
+	metricsPath        = "/status/metric"
+
+	metricsShutdownTimeout = 5 * time.Second
+)
+
+// MetricsServer serves the metrics of kmesh in the prometheus format.
+type MetricsServer struct {
+	server   *http.Server
+	listener net.Listener
+	// done is closed when the server is shut down
+	done chan struct{}
+}
+
+// NewMetricsServer creates a server listening on addr, an empty addr disables it. It must be
+// created after DropTrafficLabels, the traffic metrics are registered as they are now.
+func NewMetricsServer(addr string) *MetricsServer {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		tcpConnectionOpened, tcpConnectionClosed, tcpConnectionFailed, tcpReceivedBytes, tcpSentBytes,
+		tcpConnectLatency, tcpConnectionDuration,
+		bpfMapOverflow, bpfMapEntries, bpfMapMaxEntries, bpfMapExpired, bpfMapUpdateErrors,
+		authzDryRun, authzDecisions, xdsPushes, certRotations, hashNames, hashNameCollisions, hashNameMaxProbe,
+		plainTcpTunnelConnections,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		Registry: registry,
+	}))
+	return &MetricsServer{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Start listens on the address and serves the metrics until ctx is done, it fails if the address
+// cannot be listened on.
+func (s *MetricsServer) Start(ctx context.Context) error {
+	if s.server.Addr == "" {
+		log.Info("metrics server is disabled")
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on metrics address %s failed: %v", s.server.Addr, err)
+	}
+	s.listener = listener
+	s.done = make(chan struct{})
+	log.Infof("serve metrics on %s%s", listener.Addr(), metricsPath)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("metrics server FAILED, err: %v", err)
+		}
+	}()
+	go func() {
+		defer close(s.done)
+		<-ctx.Done()
+		// Shutdown waits for the scrapes in flight, unlike the Serve returning at once
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("metrics server Shutdown FAILED, err: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the server is shut down, it returns at once if the server is not started.
+func (s *MetricsServer) Wait() {
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// Addr returns the address the server listens on, it is nil before Start.
+func (s *MetricsServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
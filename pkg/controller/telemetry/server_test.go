@@ -0,0 +1,107 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsServer(t *testing.T) {
+	server := NewMetricsServer("127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, server.Start(ctx))
+	// the counters are accumulated across the tests
+	for _, metric := range []*prometheus.CounterVec{xdsPushes, authzDecisions, certRotations, bpfMapUpdateErrors} {
+		metric.Reset()
+	}
+
+	testlabels := map[string]string{
+		"direction":                      "INBOUND",
+		"source_workload":                "sleep",
+		"source_canonical_service":       "sleep",
+		"source_canonical_revision":      "latest",
+		"source_workload_namespace":      "ambient-demo",
+		"source_principal":               "spiffe://cluster.local/ns/ambient-demo/sa/sleep",
+		"source_app":                     "sleep",
+		"source_version":                 "latest",
+		"source_cluster":                 "Kubernetes",
+		"destination_service":            "tcp-echo.ambient-demo.svc.cluster.local",
+		"destination_service_namespace":  "ambient-demo",
+		"destination_service_name":       "tcp-echo",
+		"destination_workload":           "tcp-echo",
+		"destination_canonical_service":  "tcp-echo",
+		"destination_canonical_revision": "v1",
+		"destination_workload_namespace": "ambient-demo",
+		"destination_principal":          "spiffe://cluster.local/ns/ambient-demo/sa/default",
+		"destination_app":                "tcp-echo",
+		"destination_version":            "v1",
+		"destination_cluster":            "Kubernetes",
+		"request_protocol":               "tcp",
+		"response_flags":                 "-",
+		"connection_security_policy":     "mutual_tls",
+	}
+
+	tcpConnectionClosed.With(testlabels).Add(2)
+	tcpConnectionOpened.With(testlabels).Add(4)
+	tcpReceivedBytes.With(testlabels).Add(12.64)
+	tcpSentBytes.With(testlabels).Add(11.45)
+	RecordXdsPush("type.googleapis.com/istio.workload.Address", nil)
+	RecordAuthzDecision("deny")
+	RecordCertRotation(errors.New("csr rejected"))
+	RecordMapUpdateError("map_of_auth")
+
+	rsp, err := http.Get("http://" + server.Addr().String() + metricsPath)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	for _, metric := range []string{
+		"go_goroutines",
+		"process_cpu_seconds_total",
+		"kmesh_tcp_connections_opened_total",
+		"kmesh_tcp_sent_bytes_total",
+		`kmesh_xds_pushes_total{result="success",type="istio.workload.Address"} 1`,
+		`kmesh_authz_decisions_total{decision="deny"} 1`,
+		`kmesh_cert_rotations_total{result="failure"} 1`,
+		`kmesh_bpf_map_update_errors_total{map="map_of_auth"} 1`,
+	} {
+		assert.Contains(t, string(body), metric)
+	}
+
+	// the address in use is reported rather than retried
+	assert.Error(t, NewMetricsServer(server.Addr().String()).Start(ctx))
+
+	cancel()
+	server.Wait()
+	_, err = http.Get("http://" + server.Addr().String() + metricsPath)
+	assert.Error(t, err)
+}
+
+func TestMetricsServer_disabled(t *testing.T) {
+	server := NewMetricsServer("")
+	assert.NoError(t, server.Start(context.Background()))
+	assert.Nil(t, server.Addr())
+	server.Wait()
+}
@@ -17,19 +17,17 @@
 package telemetry
 
 import (
-	"context"
-	"net/http"
-	"sync"
+	"fmt"
+	"path"
+	"slices"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"kmesh.net/kmesh/pkg/logger"
 )
 
 var (
 	log = logger.NewLoggerField("pkg/telemetry")
-	mu  sync.Mutex
 
 	trafficLabels = []string{
 		"direction",
@@ -85,52 +83,222 @@ var (
 )
 
 var (
-	tcpConnectionOpened = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	tcpConnectionOpened, tcpConnectionClosed, tcpConnectionFailed, tcpReceivedBytes, tcpSentBytes = newTrafficMetrics(trafficLabels)
+
+	// droppedLabels are the traffic labels left out of the metrics to reduce their cardinality
+	droppedLabels []string
+
+	// latencyLabels are the labels of the latency histograms, which have a series for each pair of
+	// source and destination workloads
+	latencyLabels = []string{
+		"source_workload",
+		"source_workload_namespace",
+		"destination_workload",
+		"destination_workload_namespace",
+	}
+
+	tcpConnectLatency = newHistogramVec("kmesh_tcp_connect_latency_seconds",
+		"The latency of establishing the outbound TCP connections", connectLatencyBounds, latencyLabels)
+
+	tcpConnectionDuration = newHistogramVec("kmesh_tcp_connection_duration_seconds",
+		"The duration of the TCP connections from connect to close", connectionDurationBounds, latencyLabels)
+
+	bpfMapOverflow = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_bpf_map_overflow_total",
+			Help: "The total number of entries failed to be written because the bpf map is full",
+		}, []string{"map"})
+
+	bpfMapEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_bpf_map_entries",
+			Help: "The number of entries in the bpf map when it was last swept",
+		}, []string{"map"})
+
+	bpfMapMaxEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_bpf_map_max_entries",
+			Help: "The maximum number of entries the bpf map can hold",
+		}, []string{"map"})
+
+	bpfMapExpired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_bpf_map_expired_total",
+			Help: "The total number of expired entries removed from the bpf map",
+		}, []string{"map"})
+
+	authzDryRun = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_dry_run_total",
+			Help: "The total number of connections a dry-run authorization policy would allow or deny if it was enforced",
+		}, []string{"policy", "decision"})
+
+	bpfMapUpdateErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_bpf_map_update_errors_total",
+			Help: "The total number of failed updates of the bpf map",
+		}, []string{"map"})
+
+	authzDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_decisions_total",
+			Help: "The total number of connections allowed or denied by the authorization policies",
+		}, []string{"decision"})
+
+	xdsPushes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_xds_pushes_total",
+			Help: "The total number of xDS responses handled, by resource type and result",
+		}, []string{"type", "result"})
+
+	hashNames = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kmesh_hash_names",
+			Help: "The number of names mapped to the ids of the bpf maps",
+		})
+
+	hashNameCollisions = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kmesh_hash_name_collisions",
+			Help: "The number of names not mapped to their own hash value because of a collision",
+		})
+
+	hashNameMaxProbe = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kmesh_hash_name_max_probe",
+			Help: "The longest distance between the hash value of a name and its id",
+		})
+
+	certRotations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_cert_rotations_total",
+			Help: "The total number of workload certificates rotated, by result",
+		}, []string{"result"})
+
+	plainTcpTunnelConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_plain_tcp_tunnel_connections_total",
+			Help: "The total number of connections sent as plain TCP to the backends expecting the tunnel, which kmesh does not speak",
+		}, []string{"tunnel"})
+)
+
+func newTrafficMetrics(labels []string) (opened, closed, failed, received, sent *prometheus.CounterVec) {
+	opened = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "kmesh_tcp_connections_opened_total",
 		Help: "The total number of TCP connections opened",
-	}, trafficLabels)
+	}, labels)
 
-	tcpConnectionClosed = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
+	closed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Name: "kmesh_tcp_connections_closed_total",
 			Help: "The total number of TCP connections closed",
-		}, trafficLabels)
+		}, labels)
 
-	tcpReceivedBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
+	failed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_tcp_connections_failed_total",
+			Help: "The total number of TCP connections failed to be established",
+		}, labels)
+
+	received = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Name: "kmesh_tcp_received_bytes_total",
 			Help: "The size of total bytes received during request in case of a TCP connection",
-		}, trafficLabels)
+		}, labels)
 
-	tcpSentBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
+	sent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Name: "kmesh_tcp_sent_bytes_total",
 			Help: "The size of total bytes sent during response in case of a TCP connection",
-		}, trafficLabels)
-)
+		}, labels)
+	return
+}
 
-func RunPrometheusClient(ctx context.Context) {
-	registry := prometheus.NewRegistry()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			runPrometheusClient(registry)
+// CheckTrafficLabels returns an error if a name is not a label of the traffic metrics.
+func CheckTrafficLabels(names []string) error {
+	for _, name := range names {
+		if !slices.Contains(trafficLabels, name) {
+			return fmt.Errorf("unknown traffic metric label %q", name)
 		}
 	}
+	return nil
+}
+
+// DropTrafficLabels leaves the labels out of the traffic metrics, such as the principals and the
+// revisions whose values multiply the series on a large cluster. It must be called before the
+// metric controller runs.
+func DropTrafficLabels(names []string) error {
+	if err := CheckTrafficLabels(names); err != nil {
+		return err
+	}
+	labels := make([]string, 0, len(trafficLabels))
+	for _, label := range trafficLabels {
+		if !slices.Contains(names, label) {
+			labels = append(labels, label)
+		}
+	}
+	droppedLabels = slices.Clone(names)
+	tcpConnectionOpened, tcpConnectionClosed, tcpConnectionFailed, tcpReceivedBytes, tcpSentBytes = newTrafficMetrics(labels)
+	return nil
+}
+
+// RecordMapOverflow counts an entry that could not be stored in the named bpf map because it is full.
+func RecordMapOverflow(mapName string) {
+	bpfMapOverflow.WithLabelValues(mapName).Inc()
+}
+
+// RecordMapEntries reports the occupancy of the named bpf map.
+func RecordMapEntries(mapName string, entries, maxEntries int) {
+	bpfMapEntries.WithLabelValues(mapName).Set(float64(entries))
+	bpfMapMaxEntries.WithLabelValues(mapName).Set(float64(maxEntries))
+}
+
+// RecordMapExpired counts the expired entries removed from the named bpf map.
+func RecordMapExpired(mapName string, expired int) {
+	bpfMapExpired.WithLabelValues(mapName).Add(float64(expired))
+}
+
+// RecordAuthzDryRun counts a connection the dry-run policy would make the decision on.
+func RecordAuthzDryRun(policy, decision string) {
+	authzDryRun.WithLabelValues(policy, decision).Inc()
+}
+
+// RecordMapUpdateError counts a failed update of the named bpf map.
+func RecordMapUpdateError(mapName string) {
+	bpfMapUpdateErrors.WithLabelValues(mapName).Inc()
+}
+
+// RecordAuthzDecision counts a connection the authorization policies made the decision on.
+func RecordAuthzDecision(decision string) {
+	authzDecisions.WithLabelValues(decision).Inc()
+}
+
+// RecordXdsPush counts an xDS response of the type url, err is the error of handling it.
+func RecordXdsPush(typeUrl string, err error) {
+	// type.googleapis.com/istio.workload.Address is counted as istio.workload.Address
+	xdsPushes.WithLabelValues(path.Base(typeUrl), resultOf(err)).Inc()
+}
+
+// RecordCertRotation counts a certificate rotation, err is the error of fetching the new one.
+func RecordCertRotation(err error) {
+	certRotations.WithLabelValues(resultOf(err)).Inc()
+}
+
+// RecordPlainTcpTunnelConnections counts the connections sent as plain TCP to the backends expecting the tunnel.
+func RecordPlainTcpTunnelConnections(tunnel string, count uint64) {
+	plainTcpTunnelConnections.WithLabelValues(tunnel).Add(float64(count))
+}
+
+// RecordHashNameStats reports the collision statistics of the names mapped to the bpf map ids.
+func RecordHashNameStats(names, collisions int, maxProbe uint32) {
+	hashNames.Set(float64(names))
+	hashNameCollisions.Set(float64(collisions))
+	hashNameMaxProbe.Set(float64(maxProbe))
 }
 
-func runPrometheusClient(registry *prometheus.Registry) {
-	// ensure not occur matche the same requests as /status/metric panic
-	mu.Lock()
-	defer mu.Unlock()
-	registry.MustRegister(tcpConnectionOpened, tcpConnectionClosed, tcpReceivedBytes, tcpSentBytes)
-
-	http.Handle("/status/metric", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
-		Registry: registry,
-	}))
-	if err := http.ListenAndServe(":15020", nil); err != nil {
-		log.Fatalf("start prometheus client port failed: %v", err)
+func resultOf(err error) string {
+	if err != nil {
+		return "failure"
 	}
+	return "success"
 }
@@ -17,68 +17,46 @@
 package telemetry
 
 import (
-	"context"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestRegisterMetrics(t *testing.T) {
-	registry := prometheus.NewRegistry()
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				runPrometheusClient(registry)
-			}
-		}
-	}()
+func TestDropTrafficLabels(t *testing.T) {
+	assert.Error(t, DropTrafficLabels([]string{"source_ip"}))
 
-	exportMetrics := []*prometheus.GaugeVec{
-		tcpConnectionClosed,
-		tcpConnectionOpened,
-		tcpReceivedBytes,
-		tcpSentBytes,
-	}
+	dropped := []string{"source_principal", "destination_principal", "source_canonical_revision", "destination_canonical_revision"}
+	assert.NoError(t, DropTrafficLabels(dropped))
+	defer func() {
+		assert.NoError(t, DropTrafficLabels(nil))
+	}()
 
-	testlabels := map[string]string{
-		"direction":                      "INBOUND",
-		"source_workload":                "sleep",
-		"source_canonical_service":       "sleep",
-		"source_canonical_revision":      "latest",
-		"source_workload_namespace":      "ambient-demo",
-		"source_principal":               "spiffe://cluster.local/ns/ambient-demo/sa/sleep",
-		"source_app":                     "sleep",
-		"source_version":                 "latest",
-		"source_cluster":                 "Kubernetes",
-		"destination_service":            "tcp-echo.ambient-demo.svc.cluster.local",
-		"destination_service_namespace":  "ambient-demo",
-		"destination_service_name":       "tcp-echo",
-		"destination_workload":           "tcp-echo",
-		"destination_canonical_service":  "tcp-echo",
-		"destination_canonical_revision": "v1",
-		"destination_workload_namespace": "ambient-demo",
-		"destination_principal":          "spiffe://cluster.local/ns/ambient-demo/sa/default",
-		"destination_app":                "tcp-echo",
-		"destination_version":            "v1",
-		"destination_cluster":            "Kubernetes",
-		"request_protocol":               "tcp",
-		"response_flags":                 "-",
-		"connection_security_policy":     "mutual_tls",
+	labels := commonTrafficLabels{
+		direction:            "OUTBOUND",
+		sourcePrincipal:      "spiffe://cluster.local/ns/default/sa/sleep",
+		destinationService:   "tcp-echo.default.svc.cluster.local",
+		destinationPrincipal: "spiffe://cluster.local/ns/default/sa/default",
 	}
+	buildMetricsToPrometheus(requestMetric{connectionOpened: 2, connectionFailed: 1}, labels)
 
-	tcpConnectionClosed.With(testlabels).Set(2)
-	tcpConnectionOpened.With(testlabels).Set(4)
-	tcpReceivedBytes.With(testlabels).Set(12.64)
-	tcpSentBytes.With(testlabels).Set(11.45)
-
-	for _, metric := range exportMetrics {
-		if err := prometheus.Register(metric); err != nil {
-			t.Errorf("metric not register")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(tcpConnectionOpened, tcpConnectionFailed)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, families, 2)
+	for _, family := range families {
+		assert.Len(t, family.GetMetric(), 1)
+		metric := family.GetMetric()[0]
+		assert.Len(t, metric.GetLabel(), len(trafficLabels)-len(dropped))
+		for _, label := range metric.GetLabel() {
+			assert.NotContains(t, dropped, label.GetName())
+		}
+		switch family.GetName() {
+		case "kmesh_tcp_connections_opened_total":
+			assert.Equal(t, float64(2), metric.GetCounter().GetValue())
+		case "kmesh_tcp_connections_failed_total":
+			assert.Equal(t, float64(1), metric.GetCounter().GetValue())
 		}
 	}
-	cancel()
 }
@@ -17,30 +17,80 @@
 package bpfcache
 
 import (
-	"github.com/cilium/ebpf"
+	"net"
+
+	"kmesh.net/kmesh/pkg/nets"
 )
 
 const (
-	MaxServiceNum = 10
+	MaxAddressNum = 4
 )
 
 type BackendKey struct {
 	BackendUid uint32 // workloadUid to uint32
 }
 
-type ServiceList [MaxServiceNum]uint32
+// BackendServiceKey records that the backend belongs to the service, the value of the entry is unused.
+type BackendServiceKey struct {
+	BackendUid uint32 // workloadUid to uint32
+	ServiceId  uint32 // service id
+}
+
+type AddressList [MaxAddressNum][16]byte
 
 type BackendValue struct {
-	Ip           [16]byte
-	ServiceCount uint32
-	Services     ServiceList
-	WaypointAddr [16]byte
-	WaypointPort uint32
+	Addrs             AddressList // IPv4 addresses are stored before IPv6 addresses
+	Ipv4Count         uint32
+	Ipv6Count         uint32
+	WaypointAddr      [16]byte
+	WaypointPort      uint32
+	TunnelProtocol    uint32 // value of workloadapi.TunnelProtocol
+	AppTunnelProtocol uint32 // value of workloadapi.ApplicationTunnel_Protocol
+}
+
+// SetAddresses stores the workload addresses in the backend value, grouped by family so that
+// the bpf prog can pick the one matching the connecting socket. It returns the addresses that
+// do not fit in the value.
+func (bv *BackendValue) SetAddresses(addrs [][]byte) [][]byte {
+	var ipv4, ipv6, dropped [][]byte
+	for _, addr := range addrs {
+		if len(addr) == net.IPv4len {
+			ipv4 = append(ipv4, addr)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+
+	bv.Addrs = AddressList{}
+	bv.Ipv4Count, bv.Ipv6Count = 0, 0
+	for _, addr := range append(ipv4, ipv6...) {
+		count := bv.Ipv4Count + bv.Ipv6Count
+		if count >= MaxAddressNum {
+			dropped = append(dropped, addr)
+			continue
+		}
+		nets.CopyIpByteFromSlice(&bv.Addrs[count], addr)
+		if len(addr) == net.IPv4len {
+			bv.Ipv4Count++
+		} else {
+			bv.Ipv6Count++
+		}
+	}
+	return dropped
+}
+
+// Addresses returns all the addresses stored in the backend value.
+func (bv *BackendValue) Addresses() [][16]byte {
+	count := bv.Ipv4Count + bv.Ipv6Count
+	if count > MaxAddressNum {
+		count = MaxAddressNum
+	}
+	return bv.Addrs[:count]
 }
 
 func (c *Cache) BackendUpdate(key *BackendKey, value *BackendValue) error {
 	log.Debugf("BackendUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmeshBackend.Update(key, value, ebpf.UpdateAny)
+	return update(c.bpfMap.KmeshBackend, "kmesh_backend", key, value)
 }
 
 func (c *Cache) BackendDelete(key *BackendKey) error {
@@ -52,3 +102,58 @@ func (c *Cache) BackendLookup(key *BackendKey, value *BackendValue) error {
 	log.Debugf("BackendLookup [%#v]", *key)
 	return c.bpfMap.KmeshBackend.Lookup(key, value)
 }
+
+func (c *Cache) BackendServiceUpdate(key *BackendServiceKey) error {
+	log.Debugf("BackendServiceUpdate [%#v]", *key)
+	if err := update(c.bpfMap.KmeshBackendService, "kmesh_backend_service", key, uint32(0)); err != nil {
+		return err
+	}
+	c.backendServices.add(*key)
+	return nil
+}
+
+func (c *Cache) BackendServiceDelete(key *BackendServiceKey) error {
+	log.Debugf("BackendServiceDelete [%#v]", *key)
+	err := c.bpfMap.KmeshBackendService.Delete(key)
+	if deleted(err) {
+		c.backendServices.remove(*key)
+	}
+	return err
+}
+
+// BackendServiceIterFindKey returns the keys of the services the backend belongs to, from the index
+// rather than the map.
+func (c *Cache) BackendServiceIterFindKey(backendUid uint32) []BackendServiceKey {
+	log.Debugf("BackendServiceIterFindKey [%#v]", backendUid)
+	return c.backendServices.find(backendUid)
+}
+
+func (c *Cache) BackendIterAll() map[BackendKey]BackendValue {
+	var (
+		key   = BackendKey{}
+		value = BackendValue{}
+		iter  = c.bpfMap.KmeshBackend.Iterate()
+	)
+
+	res := make(map[BackendKey]BackendValue)
+	for iter.Next(&key, &value) {
+		res[key] = value
+	}
+
+	return res
+}
+
+func (c *Cache) BackendServiceIterAll() []BackendServiceKey {
+	var (
+		key   = BackendServiceKey{}
+		value uint32
+		iter  = c.bpfMap.KmeshBackendService.Iterate()
+	)
+
+	res := make([]BackendServiceKey, 0)
+	for iter.Next(&key, &value) {
+		res = append(res, key)
+	}
+
+	return res
+}
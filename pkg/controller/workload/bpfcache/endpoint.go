@@ -16,13 +16,11 @@
 
 package bpfcache
 
-import (
-	"github.com/cilium/ebpf"
-)
-
 type EndpointKey struct {
 	ServiceId    uint32 // service id
-	BackendIndex uint32 // if endpoint_count = 3, then backend_index = 1/2/3
+	Version      uint32 // EndpointVersion of the service the endpoint belongs to
+	Prio         uint32 // locality lb priority of the endpoint, 0 is the highest
+	BackendIndex uint32 // if EndpointCount[Prio] = 3, then backend_index = 1/2/3
 }
 
 type EndpointValue struct {
@@ -31,7 +29,7 @@ type EndpointValue struct {
 
 func (c *Cache) EndpointUpdate(key *EndpointKey, value *EndpointValue) error {
 	log.Debugf("EndpointUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmeshEndpoint.Update(key, value, ebpf.UpdateAny)
+	return update(c.bpfMap.KmeshEndpoint, "kmesh_endpoint", key, value)
 }
 
 func (c *Cache) EndpointDelete(key *EndpointKey) error {
@@ -61,3 +59,18 @@ func (c *Cache) EndpointIterFindKey(workloadUid uint32) []EndpointKey {
 
 	return res
 }
+
+func (c *Cache) EndpointIterAll() map[EndpointKey]EndpointValue {
+	var (
+		key   = EndpointKey{}
+		value = EndpointValue{}
+		iter  = c.bpfMap.KmeshEndpoint.Iterate()
+	)
+
+	res := make(map[EndpointKey]EndpointValue)
+	for iter.Next(&key, &value) {
+		res[key] = value
+	}
+
+	return res
+}
@@ -0,0 +1,306 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+// EndpointTxn stages the endpoint changes of one service and publishes them at once.
+//
+// The bpf prog reads the endpoint counts and the endpoint version from a single service value,
+// so a service update is the only point where a change becomes visible. Appended endpoints are
+// written after the published ones before the counts grow. A removed endpoint is overwritten by
+// the last one of its priority before the counts shrink, a reader may pick the moved endpoint
+// twice meanwhile but never a removed one. Any other change rewrites the whole set under the
+// inactive version and flips the service to it, the old set is deleted only after, so a rewrite
+// needs as many free entries in the endpoint map as the service has endpoints.
+// A failure before the service update leaves the published set untouched.
+type EndpointTxn struct {
+	c  *Cache
+	sk ServiceKey
+	// Service is the value published on commit, EndpointCount and EndpointVersion are managed by
+	// the txn while the other fields can be changed by the caller.
+	Service ServiceValue
+
+	exists    bool
+	published EndpointCounts
+	// the published endpoints are only loaded when the txn is more than an append
+	loaded        bool
+	publishedUids [PrioCount][]uint32
+	// endpoints[prio][i] is staged at the backend index i+1
+	endpoints [PrioCount][]uint32
+	added     [PrioCount][]uint32
+	// inPlace is false if the set has to be rewritten under the inactive version
+	inPlace bool
+}
+
+// NewEndpointTxn starts a txn on the service, a service absent from the map is created on commit.
+func (c *Cache) NewEndpointTxn(serviceId uint32) *EndpointTxn {
+	t := &EndpointTxn{
+		c:       c,
+		sk:      ServiceKey{ServiceId: serviceId},
+		inPlace: true,
+	}
+	if err := c.ServiceLookup(&t.sk, &t.Service); err != nil {
+		t.Service = ServiceValue{}
+		t.loaded = true
+		return t
+	}
+
+	t.exists = true
+	t.published = t.Service.EndpointCount
+	return t
+}
+
+func (t *EndpointTxn) load() {
+	if t.loaded {
+		return
+	}
+	t.loaded = true
+
+	ev := EndpointValue{}
+	for prio := uint32(0); prio < PrioCount; prio++ {
+		for i := uint32(1); i <= t.published[prio]; i++ {
+			ek := EndpointKey{ServiceId: t.sk.ServiceId, Version: t.Service.EndpointVersion, Prio: prio, BackendIndex: i}
+			if err := t.c.EndpointLookup(&ek, &ev); err != nil {
+				// a hole in the published set, rewrite the set without it
+				t.inPlace = false
+				continue
+			}
+			t.endpoints[prio] = append(t.endpoints[prio], ev.BackendUid)
+		}
+		t.publishedUids[prio] = append([]uint32(nil), t.endpoints[prio]...)
+	}
+}
+
+// Exists returns whether the service is stored in the map.
+func (t *EndpointTxn) Exists() bool {
+	return t.exists
+}
+
+// Backends returns the staged backends, indexed by the locality lb priority.
+func (t *EndpointTxn) Backends() [PrioCount][]uint32 {
+	t.load()
+	var backends [PrioCount][]uint32
+	for prio := range backends {
+		backends[prio] = append(append(backends[prio], t.endpoints[prio]...), t.added[prio]...)
+	}
+	return backends
+}
+
+// Add stages the backend as an endpoint with the priority.
+func (t *EndpointTxn) Add(backendUid, prio uint32) {
+	if prio >= PrioCount {
+		prio = PrioCount - 1
+	}
+	t.added[prio] = append(t.added[prio], backendUid)
+}
+
+// Remove stages the removal of the backend, it returns false if the backend is not an endpoint.
+func (t *EndpointTxn) Remove(backendUid uint32) bool {
+	t.load()
+	removed := false
+	for prio := range t.endpoints {
+		if kept, ok := swapRemoveUid(t.endpoints[prio], backendUid); ok {
+			t.endpoints[prio] = kept
+			removed = true
+		}
+		if kept, ok := removeUid(t.added[prio], backendUid); ok {
+			t.added[prio] = kept
+			removed = true
+		}
+	}
+	return removed
+}
+
+// Reset drops all the staged endpoints, they are usually added again with new priorities.
+func (t *EndpointTxn) Reset() {
+	t.loaded = true
+	t.endpoints = [PrioCount][]uint32{}
+	t.added = [PrioCount][]uint32{}
+	t.inPlace = false
+}
+
+// Commit publishes the staged endpoints together with Service.
+func (t *EndpointTxn) Commit() error {
+	var err error
+	if t.exists && t.inPlace {
+		err = t.commitInPlace()
+	} else {
+		err = t.commitRewrite()
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.loaded {
+		t.endpoints = t.Backends()
+		for prio := range t.endpoints {
+			t.publishedUids[prio] = append([]uint32(nil), t.endpoints[prio]...)
+		}
+	}
+	t.added = [PrioCount][]uint32{}
+	t.exists = true
+	t.published = t.Service.EndpointCount
+	t.inPlace = true
+	return nil
+}
+
+// commitInPlace updates the published set under its version, it writes one entry per appended
+// or removed endpoint.
+func (t *EndpointTxn) commitInPlace() error {
+	// the entries written after the published ones and the published ones overwritten
+	var written, overwritten []EndpointKey
+	version := t.Service.EndpointVersion
+	counts := t.published
+	write := func(prio, index, uid uint32) error {
+		ek := EndpointKey{ServiceId: t.sk.ServiceId, Version: version, Prio: prio, BackendIndex: index}
+		ev := EndpointValue{BackendUid: uid}
+		if err := t.c.EndpointUpdate(&ek, &ev); err != nil {
+			return err
+		}
+		if index <= t.published[prio] {
+			overwritten = append(overwritten, ek)
+		} else {
+			written = append(written, ek)
+		}
+		return nil
+	}
+
+	for prio := uint32(0); prio < PrioCount; prio++ {
+		if t.loaded {
+			// the removed endpoints are replaced by the ones moved from the tail
+			for i, uid := range t.endpoints[prio] {
+				if uid == t.publishedUids[prio][i] {
+					continue
+				}
+				if err := write(prio, uint32(i+1), uid); err != nil {
+					t.rollback(written, overwritten)
+					return err
+				}
+			}
+			counts[prio] = uint32(len(t.endpoints[prio]))
+		}
+		for _, uid := range t.added[prio] {
+			counts[prio]++
+			if err := write(prio, counts[prio], uid); err != nil {
+				t.rollback(written, overwritten)
+				return err
+			}
+		}
+	}
+
+	t.Service.EndpointCount = counts
+	if err := t.c.ServiceUpdate(&t.sk, &t.Service); err != nil {
+		t.Service.EndpointCount = t.published
+		t.rollback(written, overwritten)
+		return err
+	}
+
+	// the tail beyond the shrunk counts is no longer reachable
+	for prio := uint32(0); prio < PrioCount; prio++ {
+		for i := counts[prio] + 1; i <= t.published[prio]; i++ {
+			ek := EndpointKey{ServiceId: t.sk.ServiceId, Version: version, Prio: prio, BackendIndex: i}
+			if err := t.c.EndpointDelete(&ek); err != nil {
+				log.Warnf("delete endpoint [%#v] beyond the count failed, err:%s", ek, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *EndpointTxn) commitRewrite() error {
+	var (
+		written []EndpointKey
+		counts  EndpointCounts
+	)
+	oldVersion := t.Service.EndpointVersion
+	newVersion := oldVersion
+	if t.exists {
+		newVersion = oldVersion ^ 1
+	}
+
+	backends := t.Backends()
+	for prio := uint32(0); prio < PrioCount; prio++ {
+		for _, uid := range backends[prio] {
+			counts[prio]++
+			ek := EndpointKey{ServiceId: t.sk.ServiceId, Version: newVersion, Prio: prio, BackendIndex: counts[prio]}
+			ev := EndpointValue{BackendUid: uid}
+			if err := t.c.EndpointUpdate(&ek, &ev); err != nil {
+				t.rollback(written, nil)
+				return err
+			}
+			written = append(written, ek)
+		}
+	}
+
+	t.Service.EndpointCount = counts
+	t.Service.EndpointVersion = newVersion
+	if err := t.c.ServiceUpdate(&t.sk, &t.Service); err != nil {
+		t.Service.EndpointCount = t.published
+		t.Service.EndpointVersion = oldVersion
+		t.rollback(written, nil)
+		return err
+	}
+
+	// the old set is no longer reachable, a failed deletion only leaks an unused entry
+	if newVersion != oldVersion {
+		for prio := uint32(0); prio < PrioCount; prio++ {
+			for i := uint32(1); i <= t.published[prio]; i++ {
+				ek := EndpointKey{ServiceId: t.sk.ServiceId, Version: oldVersion, Prio: prio, BackendIndex: i}
+				if err := t.c.EndpointDelete(&ek); err != nil {
+					log.Warnf("delete endpoint [%#v] of the old version failed, err:%s", ek, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// rollback deletes the endpoints written by an unpublished commit and restores the published ones
+// it overwrote.
+func (t *EndpointTxn) rollback(written, overwritten []EndpointKey) {
+	for _, ek := range written {
+		if err := t.c.EndpointDelete(&ek); err != nil {
+			log.Warnf("rollback endpoint [%#v] failed, err:%s", ek, err)
+		}
+	}
+	for _, ek := range overwritten {
+		ev := EndpointValue{BackendUid: t.publishedUids[ek.Prio][ek.BackendIndex-1]}
+		if err := t.c.EndpointUpdate(&ek, &ev); err != nil {
+			log.Warnf("restore endpoint [%#v] failed, err:%s", ek, err)
+		}
+	}
+}
+
+func removeUid(uids []uint32, uid uint32) ([]uint32, bool) {
+	for i := range uids {
+		if uids[i] == uid {
+			return append(uids[:i:i], uids[i+1:]...), true
+		}
+	}
+	return uids, false
+}
+
+// swapRemoveUid removes the uid by moving the last one to its place
+func swapRemoveUid(uids []uint32, uid uint32) ([]uint32, bool) {
+	for i := range uids {
+		if uids[i] == uid {
+			last := len(uids) - 1
+			uids[i] = uids[last]
+			return uids[:last], true
+		}
+	}
+	return uids, false
+}
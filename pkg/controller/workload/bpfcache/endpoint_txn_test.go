@@ -0,0 +1,140 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+)
+
+func publishedBackends(t *testing.T, c *Cache, serviceId uint32) [PrioCount][]uint32 {
+	var (
+		sv       ServiceValue
+		ev       EndpointValue
+		backends [PrioCount][]uint32
+	)
+	assert.NoError(t, c.ServiceLookup(&ServiceKey{ServiceId: serviceId}, &sv))
+	for prio := uint32(0); prio < PrioCount; prio++ {
+		for i := uint32(1); i <= sv.EndpointCount[prio]; i++ {
+			ek := EndpointKey{ServiceId: serviceId, Version: sv.EndpointVersion, Prio: prio, BackendIndex: i}
+			assert.NoError(t, c.EndpointLookup(&ek, &ev))
+			backends[prio] = append(backends[prio], ev.BackendUid)
+		}
+	}
+	return backends
+}
+
+func TestEndpointTxn(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+	c := NewCache(workloadMap)
+
+	// 1. a new service is created with its endpoints
+	txn := c.NewEndpointTxn(1)
+	assert.False(t, txn.Exists())
+	txn.Service.LbPolicy = 2
+	txn.Add(10, 0)
+	txn.Add(11, 1)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, [PrioCount][]uint32{{10}, {11}}, publishedBackends(t, c, 1))
+
+	// 2. appended endpoints are published in place
+	txn = c.NewEndpointTxn(1)
+	assert.True(t, txn.Exists())
+	assert.Equal(t, uint32(2), txn.Service.LbPolicy)
+	txn.Add(12, 0)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, uint32(0), txn.Service.EndpointVersion)
+	assert.Equal(t, [PrioCount][]uint32{{10, 12}, {11}}, publishedBackends(t, c, 1))
+
+	// 3. a removal moves the last endpoint of the priority to its place under the same version
+	txn = c.NewEndpointTxn(1)
+	assert.True(t, txn.Remove(10))
+	assert.False(t, txn.Remove(10))
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, uint32(0), txn.Service.EndpointVersion)
+	assert.Equal(t, [PrioCount][]uint32{{12}, {11}}, publishedBackends(t, c, 1))
+	assert.Len(t, c.EndpointIterAll(), 2)
+
+	// 4. a txn can be committed again
+	txn.Add(13, 1)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, [PrioCount][]uint32{{12}, {11, 13}}, publishedBackends(t, c, 1))
+
+	// 5. removals and appends are combined in place
+	txn = c.NewEndpointTxn(1)
+	txn.Add(14, 1)
+	assert.True(t, txn.Remove(11))
+	assert.True(t, txn.Remove(14))
+	txn.Add(15, 0)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, uint32(0), txn.Service.EndpointVersion)
+	assert.Equal(t, [PrioCount][]uint32{{12, 15}, {13}}, publishedBackends(t, c, 1))
+	assert.Len(t, c.EndpointIterAll(), 3)
+
+	// 6. a reset publishes a rewritten set under the other version and deletes the old one
+	txn = c.NewEndpointTxn(1)
+	txn.Reset()
+	txn.Add(13, 0)
+	txn.Add(12, 1)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, uint32(1), txn.Service.EndpointVersion)
+	assert.Equal(t, [PrioCount][]uint32{{13}, {12}}, publishedBackends(t, c, 1))
+	assert.Len(t, c.EndpointIterAll(), 2)
+}
+
+func TestEndpointTxnCommitFailed(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+
+	endpointMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_endpoint",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(unsafe.Sizeof(EndpointKey{})),
+		ValueSize:  uint32(unsafe.Sizeof(EndpointValue{})),
+		MaxEntries: 4,
+	})
+	assert.NoError(t, err)
+	workloadMap.KmeshEndpoint.Close()
+	workloadMap.KmeshEndpoint = endpointMap
+	c := NewCache(workloadMap)
+
+	txn := c.NewEndpointTxn(1)
+	txn.Add(10, 0)
+	txn.Add(11, 0)
+	txn.Add(12, 0)
+	assert.NoError(t, txn.Commit())
+
+	// the rewritten set does not fit in the map, the published set is kept untouched
+	txn = c.NewEndpointTxn(1)
+	txn.Reset()
+	txn.Add(12, 0)
+	txn.Add(11, 0)
+	assert.Error(t, txn.Commit())
+	assert.Equal(t, [PrioCount][]uint32{{10, 11, 12}}, publishedBackends(t, c, 1))
+	assert.Len(t, c.EndpointIterAll(), 3)
+
+	// a removal needs no free entry
+	txn = c.NewEndpointTxn(1)
+	txn.Remove(10)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, [PrioCount][]uint32{{12, 11}}, publishedBackends(t, c, 1))
+	assert.Len(t, c.EndpointIterAll(), 2)
+}
@@ -17,6 +17,12 @@
 package bpfcache
 
 import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+
 	"kmesh.net/kmesh/bpf/kmesh/bpf2go"
 	"kmesh.net/kmesh/pkg/logger"
 )
@@ -25,10 +31,59 @@ var log = logger.NewLoggerField("workload_bpfcache")
 
 type Cache struct {
 	bpfMap bpf2go.KmeshCgroupSockWorkloadMaps
+	// the ports of each service and the services of each backend
+	servicePorts    *keyIndex[ServicePortKey]
+	backendServices *keyIndex[BackendServiceKey]
 }
 
 func NewCache(workloadMap bpf2go.KmeshCgroupSockWorkloadMaps) *Cache {
-	return &Cache{
+	c := &Cache{
 		bpfMap: workloadMap,
 	}
+	c.servicePorts = newKeyIndex(func() []ServicePortKey {
+		keys := make([]ServicePortKey, 0)
+		for key := range c.ServicePortIterAll() {
+			keys = append(keys, key)
+		}
+		return keys
+	}, func(key ServicePortKey) uint32 { return key.ServiceId })
+	c.backendServices = newKeyIndex(c.BackendServiceIterAll,
+		func(key BackendServiceKey) uint32 { return key.BackendUid })
+	return c
+}
+
+// deleted returns whether the entry is not in the map after a delete which returned err
+func deleted(err error) bool {
+	return err == nil || errors.Is(err, ebpf.ErrKeyNotExist)
+}
+
+// ErrMapFull is wrapped in the error of an update rejected because the map reached its max_entries
+var ErrMapFull = errors.New("bpf map is full")
+
+// MapUpdateError is the error of a failed update of the named map
+type MapUpdateError struct {
+	Map string
+	Err error
+}
+
+func (e *MapUpdateError) Error() string {
+	return fmt.Sprintf("update bpf map %s failed: %v", e.Map, e.Err)
+}
+
+func (e *MapUpdateError) Unwrap() error {
+	return e.Err
+}
+
+// update writes an entry to the map, hash maps reject new keys with E2BIG once
+// max_entries is reached, the error of such an overflow wraps ErrMapFull.
+func update(m *ebpf.Map, mapName string, key, value interface{}) error {
+	err := m.Update(key, value, ebpf.UpdateAny)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, syscall.E2BIG) {
+		log.Errorf("bpf map %s is full", mapName)
+		err = fmt.Errorf("%w: %w", ErrMapFull, err)
+	}
+	return &MapUpdateError{Map: mapName, Err: err}
 }
@@ -0,0 +1,49 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_update(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_test",
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatal("create map failed, err: ", err)
+	}
+	defer m.Close()
+
+	assert.NoError(t, update(m, "kmesh_test", uint32(1), uint32(1)))
+	// an existing key is still updated when the map is full
+	assert.NoError(t, update(m, "kmesh_test", uint32(1), uint32(2)))
+
+	err = update(m, "kmesh_test", uint32(2), uint32(1))
+	assert.ErrorIs(t, err, ErrMapFull)
+	var mapErr *MapUpdateError
+	assert.True(t, errors.As(err, &mapErr))
+	assert.Equal(t, "kmesh_test", mapErr.Map)
+}
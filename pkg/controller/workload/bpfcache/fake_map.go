@@ -70,13 +70,37 @@ func NewFakeWorkloadMap(t *testing.T) bpf2go.KmeshCgroupSockWorkloadMaps {
 		t.Fatalf("create serviceMap map failed, err is %v", err)
 	}
 
+	servicePortMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_service_port",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(unsafe.Sizeof(ServicePortKey{})),
+		ValueSize:  uint32(unsafe.Sizeof(ServicePortValue{})),
+		MaxEntries: 1024,
+	})
+	if err != nil {
+		t.Fatalf("create servicePortMap map failed, err is %v", err)
+	}
+
+	backendServiceMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_backend_service",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(unsafe.Sizeof(BackendServiceKey{})),
+		ValueSize:  uint32(unsafe.Sizeof(uint32(0))),
+		MaxEntries: 1024,
+	})
+	if err != nil {
+		t.Fatalf("create backendServiceMap map failed, err is %v", err)
+	}
+
 	// TODO: add other maps when needed
 
 	return bpf2go.KmeshCgroupSockWorkloadMaps{
-		KmeshBackend:  backEndMap,
-		KmeshEndpoint: endpointMap,
-		KmeshFrontend: frontendMap,
-		KmeshService:  serviceMap,
+		KmeshBackend:        backEndMap,
+		KmeshEndpoint:       endpointMap,
+		KmeshFrontend:       frontendMap,
+		KmeshService:        serviceMap,
+		KmeshServicePort:    servicePortMap,
+		KmeshBackendService: backendServiceMap,
 	}
 }
 
@@ -85,4 +109,6 @@ func CleanupFakeWorkloadMap(maps bpf2go.KmeshCgroupSockWorkloadMaps) {
 	maps.KmeshEndpoint.Close()
 	maps.KmeshFrontend.Close()
 	maps.KmeshService.Close()
+	maps.KmeshServicePort.Close()
+	maps.KmeshBackendService.Close()
 }
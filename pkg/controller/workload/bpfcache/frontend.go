@@ -16,10 +16,6 @@
 
 package bpfcache
 
-import (
-	"github.com/cilium/ebpf"
-)
-
 type FrontendKey struct {
 	Ip [16]byte // Service ip or Pod ip
 }
@@ -30,8 +26,7 @@ type FrontendValue struct {
 
 func (c *Cache) FrontendUpdate(key *FrontendKey, value *FrontendValue) error {
 	log.Debugf("FrontendUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmeshFrontend.
-		Update(key, value, ebpf.UpdateAny)
+	return update(c.bpfMap.KmeshFrontend, "kmesh_frontend", key, value)
 }
 
 func (c *Cache) FrontendDelete(key *FrontendKey) error {
@@ -64,3 +59,18 @@ func (c *Cache) FrontendIterFindKey(upstreamId uint32) []FrontendKey {
 	log.Debugf("res:[%#v]", res)
 	return res
 }
+
+func (c *Cache) FrontendIterAll() map[FrontendKey]FrontendValue {
+	var (
+		key   = FrontendKey{}
+		value = FrontendValue{}
+		iter  = c.bpfMap.KmeshFrontend.Iterate()
+	)
+
+	res := make(map[FrontendKey]FrontendValue)
+	for iter.Next(&key, &value) {
+		res[key] = value
+	}
+
+	return res
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"sync"
+)
+
+// keyIndex indexes the keys of a bpf map by an id they hold, so that the keys of a service or a
+// backend are found without walking a map of up to a hundred thousand entries. The map is walked
+// once when the index is first used, the index follows the updates and deletes of the Cache from
+// then on.
+type keyIndex[K comparable] struct {
+	mutex sync.Mutex
+	keys  map[uint32]map[K]struct{}
+	// load returns the keys in the map when the index is built
+	load func() []K
+	id   func(K) uint32
+}
+
+func newKeyIndex[K comparable](load func() []K, id func(K) uint32) *keyIndex[K] {
+	return &keyIndex[K]{load: load, id: id}
+}
+
+// build must be called with the mutex held
+func (x *keyIndex[K]) build() {
+	if x.keys != nil {
+		return
+	}
+	x.keys = make(map[uint32]map[K]struct{})
+	for _, key := range x.load() {
+		x.addLocked(key)
+	}
+}
+
+func (x *keyIndex[K]) addLocked(key K) {
+	id := x.id(key)
+	keys, ok := x.keys[id]
+	if !ok {
+		keys = make(map[K]struct{})
+		x.keys[id] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (x *keyIndex[K]) add(key K) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.build()
+	x.addLocked(key)
+}
+
+func (x *keyIndex[K]) remove(key K) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.build()
+	id := x.id(key)
+	delete(x.keys[id], key)
+	if len(x.keys[id]) == 0 {
+		delete(x.keys, id)
+	}
+}
+
+func (x *keyIndex[K]) find(id uint32) []K {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.build()
+	res := make([]K, 0, len(x.keys[id]))
+	for key := range x.keys[id] {
+		res = append(res, key)
+	}
+	return res
+}
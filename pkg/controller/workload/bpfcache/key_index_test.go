@@ -0,0 +1,53 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyIndex(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+
+	// the entries left by the last run are indexed when the index is first used
+	assert.NoError(t, workloadMap.KmeshServicePort.Update(&ServicePortKey{ServiceId: 1, ServicePort: 80},
+		&ServicePortValue{TargetPort: 8080}, ebpf.UpdateAny))
+	assert.NoError(t, workloadMap.KmeshBackendService.Update(&BackendServiceKey{BackendUid: 10, ServiceId: 1},
+		uint32(0), ebpf.UpdateAny))
+	c := NewCache(workloadMap)
+	assert.Equal(t, []ServicePortKey{{ServiceId: 1, ServicePort: 80}}, c.ServicePortIterFindKey(1))
+	assert.Equal(t, []BackendServiceKey{{BackendUid: 10, ServiceId: 1}}, c.BackendServiceIterFindKey(10))
+
+	assert.NoError(t, c.ServicePortUpdate(&ServicePortKey{ServiceId: 1, ServicePort: 443}, &ServicePortValue{}))
+	assert.NoError(t, c.ServicePortUpdate(&ServicePortKey{ServiceId: 2, ServicePort: 80}, &ServicePortValue{}))
+	assert.ElementsMatch(t, []ServicePortKey{{ServiceId: 1, ServicePort: 80}, {ServiceId: 1, ServicePort: 443}},
+		c.ServicePortIterFindKey(1))
+	assert.NoError(t, c.ServicePortDelete(&ServicePortKey{ServiceId: 1, ServicePort: 80}))
+	assert.Equal(t, []ServicePortKey{{ServiceId: 1, ServicePort: 443}}, c.ServicePortIterFindKey(1))
+
+	assert.NoError(t, c.BackendServiceUpdate(&BackendServiceKey{BackendUid: 10, ServiceId: 2}))
+	assert.Len(t, c.BackendServiceIterFindKey(10), 2)
+	assert.NoError(t, c.BackendServiceDelete(&BackendServiceKey{BackendUid: 10, ServiceId: 1}))
+	// a key already gone from the map is dropped from the index too
+	assert.Error(t, c.BackendServiceDelete(&BackendServiceKey{BackendUid: 10, ServiceId: 1}))
+	assert.Equal(t, []BackendServiceKey{{BackendUid: 10, ServiceId: 2}}, c.BackendServiceIterFindKey(10))
+	assert.Empty(t, c.BackendServiceIterFindKey(11))
+}
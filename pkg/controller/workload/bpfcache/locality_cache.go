@@ -0,0 +1,113 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"sync"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+)
+
+type localityInfo struct {
+	region    string // init from workload.GetLocality().GetRegion()
+	zone      string // init from workload.GetLocality().GetZone()
+	subZone   string // init from workload.GetLocality().GetSubzone()
+	nodeName  string // init from os.Getenv("NODE_NAME"), workload.GetNode()
+	clusterId string // init from workload.GetClusterId()
+	network   string // init from workload.GetNetwork()
+}
+
+// LocalityCache records the locality of the node kmesh runs on, it is used to
+// calculate the load balancing priority of the endpoints of a service.
+type LocalityCache struct {
+	mutex        sync.RWMutex
+	localityInfo *localityInfo
+}
+
+func NewLocalityCache() *LocalityCache {
+	return &LocalityCache{}
+}
+
+// SetLocality updates the locality of the local node, it returns true if the locality changed.
+func (l *LocalityCache) SetLocality(nodeName, clusterId, network string, locality *workloadapi.Locality) bool {
+	info := &localityInfo{
+		region:    locality.GetRegion(),
+		zone:      locality.GetZone(),
+		subZone:   locality.GetSubzone(),
+		nodeName:  nodeName,
+		clusterId: clusterId,
+		network:   network,
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.localityInfo != nil && *l.localityInfo == *info {
+		return false
+	}
+	l.localityInfo = info
+	return true
+}
+
+func (l *LocalityCache) IsLocalitySet() bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.localityInfo != nil
+}
+
+// CalcLocalityLBPrio returns the load balancing priority of the workload, 0 is the highest.
+// The priority is the number of routing preferences that the workload does not match, counted
+// from the first mismatched scope, e.g. with [REGION, ZONE] a workload in the same region but
+// another zone gets priority 1.
+func (l *LocalityCache) CalcLocalityLBPrio(workload *workloadapi.Workload, rp []workloadapi.LoadBalancing_Scope) uint32 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	var rank uint32
+	for _, scope := range rp {
+		if l.localityInfo == nil || workload == nil {
+			break
+		}
+		match := false
+		switch scope {
+		case workloadapi.LoadBalancing_REGION:
+			match = l.localityInfo.region == workload.GetLocality().GetRegion()
+		case workloadapi.LoadBalancing_ZONE:
+			match = l.localityInfo.zone == workload.GetLocality().GetZone()
+		case workloadapi.LoadBalancing_SUBZONE:
+			match = l.localityInfo.subZone == workload.GetLocality().GetSubzone()
+		case workloadapi.LoadBalancing_NODE:
+			match = l.localityInfo.nodeName == workload.GetNode()
+		case workloadapi.LoadBalancing_CLUSTER:
+			match = l.localityInfo.clusterId == workload.GetClusterId()
+		case workloadapi.LoadBalancing_NETWORK:
+			match = l.localityInfo.network == workload.GetNetwork()
+		default:
+			// unspecified scope does not restrict anything
+			match = true
+		}
+		if !match {
+			break
+		}
+		rank++
+	}
+
+	prio := uint32(len(rp)) - rank
+	if prio >= PrioCount {
+		prio = PrioCount - 1
+	}
+	return prio
+}
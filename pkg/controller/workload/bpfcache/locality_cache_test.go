@@ -0,0 +1,72 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+)
+
+func TestCalcLocalityLBPrio(t *testing.T) {
+	rp := []workloadapi.LoadBalancing_Scope{
+		workloadapi.LoadBalancing_NETWORK,
+		workloadapi.LoadBalancing_REGION,
+		workloadapi.LoadBalancing_ZONE,
+		workloadapi.LoadBalancing_NODE,
+	}
+	newWorkload := func(network, region, zone, node string) *workloadapi.Workload {
+		return &workloadapi.Workload{
+			Network:  network,
+			Node:     node,
+			Locality: &workloadapi.Locality{Region: region, Zone: zone},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		workload *workloadapi.Workload
+		rp       []workloadapi.LoadBalancing_Scope
+		want     uint32
+	}{
+		{"match all", newWorkload("n1", "r1", "z1", "node1"), rp, 0},
+		{"same zone", newWorkload("n1", "r1", "z1", "node2"), rp, 1},
+		{"same region", newWorkload("n1", "r1", "z2", "node1"), rp, 2},
+		{"same network", newWorkload("n1", "r2", "z1", "node1"), rp, 3},
+		{"match none", newWorkload("n2", "r1", "z1", "node1"), rp, 4},
+		{"nil workload", nil, rp, 4},
+		{"no routing preference", newWorkload("n2", "r2", "z2", "node2"), nil, 0},
+	}
+
+	l := NewLocalityCache()
+	assert.True(t, l.SetLocality("node1", "c1", "n1", &workloadapi.Locality{Region: "r1", Zone: "z1"}))
+	assert.False(t, l.SetLocality("node1", "c1", "n1", &workloadapi.Locality{Region: "r1", Zone: "z1"}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, l.CalcLocalityLBPrio(tt.workload, tt.rp))
+		})
+	}
+}
+
+func TestCalcLocalityLBPrioWithoutLocality(t *testing.T) {
+	l := NewLocalityCache()
+	assert.False(t, l.IsLocalitySet())
+	rp := []workloadapi.LoadBalancing_Scope{workloadapi.LoadBalancing_REGION, workloadapi.LoadBalancing_ZONE}
+	assert.Equal(t, uint32(2), l.CalcLocalityLBPrio(&workloadapi.Workload{}, rp))
+}
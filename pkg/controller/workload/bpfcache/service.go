@@ -16,33 +16,36 @@
 
 package bpfcache
 
-import (
-	"github.com/cilium/ebpf"
-)
-
 const (
-	MaxPortNum = 10
+	PrioCount = 7
 )
 
 type ServiceKey struct {
 	ServiceId uint32 // service id
 }
 
-type ServicePorts [MaxPortNum]uint32
-type TargetPorts [MaxPortNum]uint32
+type EndpointCounts [PrioCount]uint32
+
+type ServicePortKey struct {
+	ServiceId   uint32 // service id
+	ServicePort uint32 // service port in big endian
+}
+
+type ServicePortValue struct {
+	TargetPort uint32 // target port in big endian
+}
 
 type ServiceValue struct {
-	EndpointCount uint32       // endpoint count of current service
-	LbPolicy      uint32       // load balancing algorithm, currently only supports random algorithm
-	ServicePort   ServicePorts // ServicePort[i] and TargetPort[i] are a pair, i starts from 0 and max value is MaxPortNum-1
-	TargetPort    TargetPorts
-	WaypointAddr  [16]byte
-	WaypointPort  uint32
+	EndpointCount   EndpointCounts // endpoint count of current service, indexed by the locality lb priority
+	EndpointVersion uint32         // the endpoint set in use, it is flipped to publish a rewritten set
+	LbPolicy        uint32         // load balancing algorithm, random/strict/failover
+	WaypointAddr    [16]byte
+	WaypointPort    uint32
 }
 
 func (c *Cache) ServiceUpdate(key *ServiceKey, value *ServiceValue) error {
 	log.Debugf("ServiceUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmeshService.Update(key, value, ebpf.UpdateAny)
+	return update(c.bpfMap.KmeshService, "kmesh_service", key, value)
 }
 
 func (c *Cache) ServiceDelete(key *ServiceKey) error {
@@ -54,3 +57,63 @@ func (c *Cache) ServiceLookup(key *ServiceKey, value *ServiceValue) error {
 	log.Debugf("ServiceLookup [%#v]", *key)
 	return c.bpfMap.KmeshService.Lookup(key, value)
 }
+
+func (c *Cache) ServicePortUpdate(key *ServicePortKey, value *ServicePortValue) error {
+	log.Debugf("ServicePortUpdate [%#v], [%#v]", *key, *value)
+	if err := update(c.bpfMap.KmeshServicePort, "kmesh_service_port", key, value); err != nil {
+		return err
+	}
+	c.servicePorts.add(*key)
+	return nil
+}
+
+func (c *Cache) ServicePortDelete(key *ServicePortKey) error {
+	log.Debugf("ServicePortDelete [%#v]", *key)
+	err := c.bpfMap.KmeshServicePort.Delete(key)
+	if deleted(err) {
+		c.servicePorts.remove(*key)
+	}
+	return err
+}
+
+func (c *Cache) ServicePortLookup(key *ServicePortKey, value *ServicePortValue) error {
+	log.Debugf("ServicePortLookup [%#v]", *key)
+	return c.bpfMap.KmeshServicePort.Lookup(key, value)
+}
+
+// ServicePortIterFindKey returns the keys of the ports of the service, from the index rather than
+// the map.
+func (c *Cache) ServicePortIterFindKey(serviceId uint32) []ServicePortKey {
+	log.Debugf("ServicePortIterFindKey [%#v]", serviceId)
+	return c.servicePorts.find(serviceId)
+}
+
+func (c *Cache) ServiceIterAll() map[ServiceKey]ServiceValue {
+	var (
+		key   = ServiceKey{}
+		value = ServiceValue{}
+		iter  = c.bpfMap.KmeshService.Iterate()
+	)
+
+	res := make(map[ServiceKey]ServiceValue)
+	for iter.Next(&key, &value) {
+		res[key] = value
+	}
+
+	return res
+}
+
+func (c *Cache) ServicePortIterAll() map[ServicePortKey]ServicePortValue {
+	var (
+		key   = ServicePortKey{}
+		value = ServicePortValue{}
+		iter  = c.bpfMap.KmeshServicePort.Iterate()
+	)
+
+	res := make(map[ServicePortKey]ServicePortValue)
+	for iter.Next(&key, &value) {
+		res[key] = value
+	}
+
+	return res
+}
@@ -26,6 +26,7 @@ type ServiceCache interface {
 	List() []*workloadapi.Service
 	AddOrUpdateService(svc *workloadapi.Service)
 	DeleteService(resourceName string)
+	GetService(resourceName string) *workloadapi.Service
 }
 
 type serviceCache struct {
@@ -52,6 +53,12 @@ func (s *serviceCache) DeleteService(resourceName string) {
 	delete(s.servicesByResourceName, resourceName)
 }
 
+func (s *serviceCache) GetService(resourceName string) *workloadapi.Service {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.servicesByResourceName[resourceName]
+}
+
 func (s *serviceCache) List() []*workloadapi.Service {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
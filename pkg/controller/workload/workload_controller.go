@@ -40,6 +40,7 @@ type Controller struct {
 	Processor        *Processor
 	Rbac             *auth.Rbac
 	MetricController *telemetry.MetricController
+	AccessLogger     *telemetry.AccessLogger
 	bpfWorkloadObj   *bpf.BpfKmeshWorkload
 }
 
@@ -48,14 +49,33 @@ func NewController(bpfWorkload *bpf.BpfKmeshWorkload) *Controller {
 		Processor:      newProcessor(bpfWorkload.SockConn.KmeshCgroupSockWorkloadObjects.KmeshCgroupSockWorkloadMaps),
 		bpfWorkloadObj: bpfWorkload,
 	}
-	c.Rbac = auth.NewRbac(c.Processor.WorkloadCache)
-	c.MetricController = telemetry.NewMetric(c.Processor.WorkloadCache)
+	// entries of the resources deleted while kmesh was down are left in the pinned maps
+	c.Processor.needReconcile = bpf.GetStartType() == bpf.Restart || c.Processor.hashName.NamesLost()
+	if c.Processor.needReconcile {
+		if unknown := c.Processor.unknownHashIds(); len(unknown) > 0 {
+			log.Warnf("%d ids in the pinned bpf maps are not found in the persisted hash names: %v", len(unknown), unknown)
+			// the entries are removed by the reconcile, the ids are not reused before that
+			c.Processor.hashName.Reserve(unknown)
+		}
+	}
+	c.Rbac = auth.NewRbac(c.Processor.WorkloadCache, c.Processor.ServiceCache)
+	c.Rbac.SetPolicyMaps(bpfWorkload.SockOps.KmeshAuthz, bpfWorkload.SockOps.KmeshAuthzRule)
+	c.MetricController = telemetry.NewMetric(c.Processor.WorkloadCache, c.Processor.ServiceCache)
+	c.AccessLogger = telemetry.NewAccessLogger(c.Processor.WorkloadCache)
 	return c
 }
 
+// SetWaypointPort sets the port the traffic to the services used as waypoints is sent to, it must
+// be called before the workload stream is created.
+func (c *Controller) SetWaypointPort(port uint32) {
+	c.Processor.waypointPort = port
+}
+
 func (c *Controller) Run(ctx context.Context) {
 	go c.Rbac.Run(ctx, c.bpfWorkloadObj.SockOps.MapOfTuple, c.bpfWorkloadObj.XdpAuth.MapOfAuth)
 	go c.MetricController.Run(ctx, c.bpfWorkloadObj.SockConn.MapOfMetricNotify, c.bpfWorkloadObj.SockConn.MapOfMetrics)
+	go c.AccessLogger.Run(ctx, c.bpfWorkloadObj.SockConn.MapOfAccessLog)
+	go newTunnelStats(c.bpfWorkloadObj.SockConn.KmeshTunnelStats).Run(ctx)
 }
 
 func (c *Controller) WorkloadStreamCreateAndSend(client discoveryv3.AggregatedDiscoveryServiceClient, ctx context.Context) error {
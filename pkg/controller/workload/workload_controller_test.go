@@ -150,7 +150,7 @@ func TestWorkloadStreamCreateAndSend(t *testing.T) {
 				patches1.ApplyMethodReturn(fakeClient.Client, "DeltaAggregatedResources", fakeClient.DeltaClient, nil)
 
 				workloadController.Processor = newProcessor(workloadMap)
-				workloadController.Rbac = auth.NewRbac(nil)
+				workloadController.Rbac = auth.NewRbac(nil, nil)
 				workloadController.Rbac.UpdatePolicy(&security.Authorization{
 					Name:      "p1",
 					Namespace: "test",
@@ -195,7 +195,8 @@ func TestWorkloadStreamCreateAndSend(t *testing.T) {
 func TestAdsStream_AdsStreamProcess(t *testing.T) {
 	workloadStream := Controller{
 		Processor: &Processor{
-			ack: &discoveryv3.DeltaDiscoveryRequest{},
+			ack:      &discoveryv3.DeltaDiscoveryRequest{},
+			hashName: NewHashName(),
 		},
 	}
 
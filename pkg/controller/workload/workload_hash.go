@@ -17,12 +17,22 @@
 package workload
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"hash/fnv"
 	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+	"kmesh.net/kmesh/pkg/utils"
 )
 
 var (
@@ -31,64 +41,305 @@ var (
 
 const (
 	persistPath = "/mnt/workload_hash_name.yaml"
+	// journalPath records the changes made after the snapshot in persistPath was written
+	journalPath = persistPath + ".journal"
+	// corruptPath keeps a snapshot which could not be loaded
+	corruptPath = persistPath + ".corrupt"
+	// the journal is compacted into the snapshot when it holds more records than both this
+	// and the number of names
+	minCompactRecords = 1024
+
+	journalAdd    = "+"
+	journalDelete = "-"
 )
 
+// hashNameSnapshot is the content of persistPath, the checksum covers the sorted names and ids.
+type hashNameSnapshot struct {
+	Checksum uint32            `yaml:"checksum"`
+	Names    map[string]uint32 `yaml:"names"`
+}
+
+// HashNameStats describes how crowded the id space of the HashName is.
+type HashNameStats struct {
+	Names      int    // number of names stored
+	Collisions int    // number of names not stored at their own hash value
+	MaxProbe   uint32 // the longest distance between the hash value of a name and its id
+}
+
 // HashName converts a string to a uint32 integer as the key of bpf map
+//
+// The mapping is persisted, so that the ids in the pinned bpf maps can still be resolved after
+// a restart. Every change is appended to a journal with a checksum per record, the journal is
+// compacted into a snapshot written atomically. A record torn by a crash is dropped on load.
+//
+// The records are buffered and written with a single fsync by Flush, which is called once per
+// xDS response. The ids of the records lost by a crash before the flush are still in the pinned
+// maps, they are found by unknownHashIds and reconciled on restart.
 type HashName struct {
 	numToStr map[uint32]string
 	strToNum map[string]uint32
+	// the number of names for each distance from their hash value other than 0
+	probes     map[uint32]int
+	collisions int
+	// namesLost is set if the snapshot could not be loaded, the ids in the pinned maps are unknown
+	namesLost bool
+	// reserved are the ids in use in the bpf maps whose names are lost, they are not handed out
+	// until the entries are removed
+	reserved map[uint32]struct{}
+	// number of records in the journal, including the pending ones
+	journalRecords int
+	// journal is kept open for appending, pending are the records not written to it yet
+	journal *os.File
+	pending bytes.Buffer
 }
 
 func NewHashName() *HashName {
 	hashName := &HashName{
 		strToNum: make(map[string]uint32),
+		numToStr: make(map[uint32]string),
+		probes:   make(map[uint32]int),
 	}
 	// if read failed, initialize with an empty map
-	if err := hashName.readFromPersistFile(); err != nil {
-		hashName.numToStr = make(map[uint32]string)
-	} else {
-		hashName.numToStr = make(map[uint32]string, len(hashName.strToNum))
-		for str, num := range hashName.strToNum {
-			hashName.numToStr[num] = str
+	if err := hashName.readFromPersistFile(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		// the snapshot is kept aside rather than overwritten by the compaction below, the entries
+		// of the ids it held are left to the reconcile
+		log.Errorf("error reading the persisted hash names, move it to %s and start with an empty one: %v", corruptPath, err)
+		if err := os.Rename(persistPath, corruptPath); err != nil {
+			log.Errorf("error moving the persisted hash names aside: %v", err)
 		}
+		hashName.strToNum = make(map[string]uint32)
+		hashName.numToStr = make(map[uint32]string)
+		hashName.probes = make(map[uint32]int)
+		hashName.collisions = 0
+		hashName.namesLost = true
+	}
+	hashName.replayJournal()
+
+	// start with an empty journal, the loaded names may come from a legacy file as well
+	if err := hashName.compact(); err != nil {
+		log.Errorf("error compacting the persisted hash names: %v", err)
 	}
+	log.Infof("hash names loaded: %+v", hashName.Stats())
+	hashName.reportStats()
 	return hashName
 }
 
+// NamesLost returns whether the persisted names could not be loaded.
+func (h *HashName) NamesLost() bool {
+	return h.namesLost
+}
+
 func (h *HashName) readFromPersistFile() error {
 	data, err := os.ReadFile(persistPath)
 	if err != nil {
 		return err
 	}
 
-	return yaml.Unmarshal(data, &h.strToNum)
+	snapshot := hashNameSnapshot{}
+	if err = yaml.Unmarshal(data, &snapshot); err != nil || snapshot.Names == nil {
+		// the legacy file is a plain map from name to id without checksum
+		legacy := make(map[string]uint32)
+		if legacyErr := yaml.Unmarshal(data, &legacy); legacyErr != nil {
+			return fmt.Errorf("unrecognized hash name file: %v", legacyErr)
+		}
+		snapshot.Names = legacy
+		snapshot.Checksum = checksumOf(legacy)
+	}
+
+	if checksum := checksumOf(snapshot.Names); checksum != snapshot.Checksum {
+		return fmt.Errorf("checksum mismatch, recorded %d, calculated %d", snapshot.Checksum, checksum)
+	}
+	for str, num := range snapshot.Names {
+		h.set(str, num)
+	}
+	return nil
+}
+
+// replayJournal applies the records appended after the snapshot, it stops at the first record
+// which is damaged, that can only be the last one being written when kmesh crashed.
+func (h *HashName) replayJournal() {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		op, str, num, err := parseJournalRecord(scanner.Text())
+		if err != nil {
+			log.Warnf("drop the damaged hash name journal from record %q: %v", scanner.Text(), err)
+			return
+		}
+		switch op {
+		case journalAdd:
+			h.set(str, num)
+		case journalDelete:
+			h.unset(str)
+		}
+	}
+}
+
+func (h *HashName) set(str string, num uint32) {
+	// keep the mapping one to one, a record may reuse the id or the name of an older one
+	if old, exists := h.numToStr[num]; exists {
+		h.unlink(old, num)
+	}
+	if old, exists := h.strToNum[str]; exists {
+		h.unlink(str, old)
+	}
+	h.link(str, num)
+}
+
+func (h *HashName) unset(str string) {
+	if num, exists := h.strToNum[str]; exists {
+		h.unlink(str, num)
+	}
 }
 
-func (h *HashName) flush() error {
-	// We only need to flush strToNum here, since we can generate numToStr from it.
-	if len(h.strToNum) == 0 {
-		return os.WriteFile(persistPath, nil, 0644)
+func (h *HashName) link(str string, num uint32) {
+	h.strToNum[str] = num
+	h.numToStr[num] = str
+	if probe := num - hashOf(str); probe != 0 {
+		h.probes[probe]++
+		h.collisions++
 	}
+}
 
-	yaml, err := yaml.Marshal(h.strToNum)
+func (h *HashName) unlink(str string, num uint32) {
+	delete(h.numToStr, num)
+	delete(h.strToNum, str)
+	if probe := num - hashOf(str); probe != 0 {
+		if h.probes[probe]--; h.probes[probe] == 0 {
+			delete(h.probes, probe)
+		}
+		h.collisions--
+	}
+}
+
+func hashOf(str string) uint32 {
+	hash.Reset()
+	hash.Write([]byte(str))
+	return hash.Sum32()
+}
+
+// compact writes all the names to the snapshot atomically and then empties the journal, the
+// journal records are idempotent, so a crash in between loses nothing.
+func (h *HashName) compact() error {
+	data, err := yaml.Marshal(hashNameSnapshot{
+		Checksum: checksumOf(h.strToNum),
+		Names:    h.strToNum,
+	})
 	if err != nil {
 		return err
 	}
+	if err = utils.AtomicWrite(persistPath, data, 0644); err != nil {
+		return err
+	}
+	h.closeJournal()
+	if err = os.WriteFile(journalPath, nil, 0644); err != nil {
+		return err
+	}
+	// the pending records are in the snapshot already
+	h.pending.Reset()
+	h.journalRecords = 0
+	return nil
+}
+
+func (h *HashName) closeJournal() {
+	if h.journal == nil {
+		return
+	}
+	if err := h.journal.Close(); err != nil {
+		log.Errorf("error closing the hash name journal: %v", err)
+	}
+	h.journal = nil
+}
 
-	return os.WriteFile(persistPath, yaml, 0644)
+// appendJournal records a change, it is persisted by the next Flush
+func (h *HashName) appendJournal(op, str string, num uint32) {
+	h.pending.WriteString(formatJournalRecord(op, str, num))
+	h.journalRecords++
 }
 
-// flushDelta is similar to flush, but it appends new item instead of flush all data
-func (h *HashName) flushDelta(str string, num uint32) error {
-	f, err := os.OpenFile(persistPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// Flush writes the pending records to the journal with a single fsync, the journal is compacted
+// once it grows too long.
+func (h *HashName) Flush() error {
+	if h.pending.Len() == 0 {
+		return nil
+	}
+	if h.journalRecords > minCompactRecords && h.journalRecords > len(h.strToNum) {
+		return h.compact()
+	}
+
+	if h.journal == nil {
+		f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		h.journal = f
+	}
+	_, err := h.journal.Write(h.pending.Bytes())
+	if err == nil {
+		// a record is only relied on once it survives a power loss
+		err = h.journal.Sync()
+	}
 	if err != nil {
-		return err
+		// the journal may end with a torn record now, the next records would be dropped after
+		// it on load, so everything is written to a new snapshot instead
+		h.closeJournal()
+		if compactErr := h.compact(); compactErr != nil {
+			return errors.Join(err, compactErr)
+		}
+		return nil
 	}
-	defer f.Close()
+	h.pending.Reset()
+	return nil
+}
 
-	data := fmt.Sprintf("%s: %d\n", str, num)
-	_, err = f.WriteString(data)
-	return err
+func formatJournalRecord(op, str string, num uint32) string {
+	record := fmt.Sprintf("%s\t%d\t%s", op, num, strconv.Quote(str))
+	return fmt.Sprintf("%s\t%08x\n", record, crc32.ChecksumIEEE([]byte(record)))
+}
+
+func parseJournalRecord(line string) (string, string, uint32, error) {
+	i := strings.LastIndexByte(line, '\t')
+	if i < 0 {
+		return "", "", 0, fmt.Errorf("missing checksum")
+	}
+	record := line[:i]
+	checksum, err := strconv.ParseUint(line[i+1:], 16, 32)
+	if err != nil || uint32(checksum) != crc32.ChecksumIEEE([]byte(record)) {
+		return "", "", 0, fmt.Errorf("checksum mismatch")
+	}
+
+	fields := strings.SplitN(record, "\t", 3)
+	if len(fields) != 3 || (fields[0] != journalAdd && fields[0] != journalDelete) {
+		return "", "", 0, fmt.Errorf("malformed record")
+	}
+	num, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return "", "", 0, err
+	}
+	str, err := strconv.Unquote(fields[2])
+	if err != nil {
+		return "", "", 0, err
+	}
+	return fields[0], str, uint32(num), nil
+}
+
+func checksumOf(strToNum map[string]uint32) uint32 {
+	names := make([]string, 0, len(strToNum))
+	for str := range strToNum {
+		names = append(names, str)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, str := range names {
+		fmt.Fprintf(&buf, "%s\t%d\n", str, strToNum[str])
+	}
+	return crc32.ChecksumIEEE(buf.Bytes())
 }
 
 func (h *HashName) StrToNum(str string) uint32 {
@@ -104,13 +355,12 @@ func (h *HashName) StrToNum(str string) uint32 {
 	// Using linear probing to solve hash conflicts
 	for num = hash.Sum32(); num < math.MaxUint32; num++ {
 		// Create a new item if we find an empty slot
-		if _, exists := h.numToStr[num]; !exists {
-			h.numToStr[num] = str
-			h.strToNum[str] = num
-			// Create a new item here, should flush
-			if err := h.flushDelta(str, num); err != nil {
-				log.Errorf("error flushing when calling StrToNum: %v", err)
-			}
+		_, reserved := h.reserved[num]
+		if _, exists := h.numToStr[num]; !exists && !reserved {
+			h.link(str, num)
+			// Create a new item here, should persist
+			h.appendJournal(journalAdd, str, num)
+			h.reportStats()
 			break
 		}
 		// It's a ring
@@ -129,11 +379,49 @@ func (h *HashName) NumToStr(num uint32) string {
 func (h *HashName) Delete(str string) {
 	// only when the num exists, we do the logic
 	if num, exists := h.strToNum[str]; exists {
-		delete(h.numToStr, num)
-		delete(h.strToNum, str)
-		// delete an old item here, should flush
-		if err := h.flush(); err != nil {
-			log.Errorf("error flushing when calling Delete: %v", err)
+		h.unlink(str, num)
+		// delete an old item here, should persist
+		h.appendJournal(journalDelete, str, num)
+		h.reportStats()
+	}
+}
+
+// Names returns all the stored names.
+func (h *HashName) Names() []string {
+	names := make([]string, 0, len(h.strToNum))
+	for str := range h.strToNum {
+		names = append(names, str)
+	}
+	return names
+}
+
+// Reserve keeps the ids from being assigned to new names.
+func (h *HashName) Reserve(nums []uint32) {
+	if h.reserved == nil {
+		h.reserved = make(map[uint32]struct{}, len(nums))
+	}
+	for _, num := range nums {
+		h.reserved[num] = struct{}{}
+	}
+}
+
+// Release makes the reserved ids available again.
+func (h *HashName) Release() {
+	h.reserved = nil
+}
+
+// Stats returns the collision statistics of the stored names.
+func (h *HashName) Stats() HashNameStats {
+	stats := HashNameStats{Names: len(h.strToNum), Collisions: h.collisions}
+	for probe := range h.probes {
+		if probe > stats.MaxProbe {
+			stats.MaxProbe = probe
 		}
 	}
+	return stats
+}
+
+func (h *HashName) reportStats() {
+	stats := h.Stats()
+	telemetry.RecordHashNameStats(stats.Names, stats.Collisions, stats.MaxProbe)
 }
@@ -17,6 +17,8 @@
 package workload
 
 import (
+	"bytes"
+	"fmt"
 	"hash/fnv"
 	"os"
 	"testing"
@@ -36,6 +38,8 @@ func getHashValueMap(testStrings []string) map[string]uint32 {
 // clean persist file for test
 func cleanPersistFile() {
 	_ = os.Remove(persistPath)
+	_ = os.Remove(journalPath)
+	_ = os.Remove(corruptPath)
 }
 
 func TestWorkloadHash_Basic(t *testing.T) {
@@ -88,6 +92,9 @@ func TestWorkloadHash_StrToNumAfterDelete(t *testing.T) {
 		num := hashName.StrToNum(testString)
 		strToNumMap[testString] = num
 	}
+	if err := hashName.Flush(); err != nil {
+		t.Fatal(err)
+	}
 
 	// create a new one to imutate the kmesh restart
 	hashName = NewHashName()
@@ -111,3 +118,133 @@ func TestWorkloadHash_StrToNumAfterDelete(t *testing.T) {
 		}
 	}
 }
+
+func TestWorkloadHash_Persist(t *testing.T) {
+	cleanPersistFile()
+	hashName := NewHashName()
+	fooNum := hashName.StrToNum("foo")
+	barNum := hashName.StrToNum("bar")
+	hashName.Delete("bar")
+
+	// the records are only written by the flush
+	if data, _ := os.ReadFile(journalPath); len(data) != 0 {
+		t.Errorf("journal written before the flush: %q", data)
+	}
+	if err := hashName.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(journalPath); bytes.Count(data, []byte("\n")) != 3 {
+		t.Errorf("journal = %q, want 3 records", data)
+	}
+
+	// a crash tears the record being appended, the records before it are kept
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("+\t1234\t\"torn")
+	f.Close()
+
+	hashName = NewHashName()
+	if num := hashName.StrToNum("foo"); num != fooNum {
+		t.Errorf("StrToNum(foo) = %d, want %d", num, fooNum)
+	}
+	if str := hashName.NumToStr(barNum); str != "" {
+		t.Errorf("NumToStr(%d) = %s, want empty", barNum, str)
+	}
+	if str := hashName.NumToStr(1234); str != "" {
+		t.Errorf("NumToStr(1234) = %s, want empty", str)
+	}
+	// the journal is compacted into the snapshot on startup
+	if data, _ := os.ReadFile(journalPath); len(data) != 0 {
+		t.Errorf("journal is not compacted: %q", data)
+	}
+}
+
+func TestWorkloadHash_CorruptedSnapshot(t *testing.T) {
+	cleanPersistFile()
+	hashName := NewHashName()
+	num := hashName.StrToNum("foo")
+	if err := hashName.compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a snapshot not matching its checksum is discarded
+	data := fmt.Sprintf("checksum: 1\nnames:\n  foo: %d\n", num)
+	if err := os.WriteFile(persistPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hashName = NewHashName()
+	if str := hashName.NumToStr(num); str != "" {
+		t.Errorf("NumToStr(%d) = %s, want empty", num, str)
+	}
+	if !hashName.NamesLost() {
+		t.Errorf("NamesLost() = false, want true")
+	}
+	// and kept aside instead of overwritten
+	if corrupt, err := os.ReadFile(corruptPath); err != nil || string(corrupt) != data {
+		t.Errorf("corrupt snapshot = %q, %v, want %q", corrupt, err, data)
+	}
+
+	// the legacy file without checksum is still accepted
+	data = fmt.Sprintf("foo: %d\n", num)
+	if err := os.WriteFile(persistPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hashName = NewHashName()
+	if str := hashName.NumToStr(num); str != "foo" {
+		t.Errorf("NumToStr(%d) = %s, want foo", num, str)
+	}
+	if hashName.NamesLost() {
+		t.Errorf("NamesLost() = true, want false")
+	}
+	cleanPersistFile()
+}
+
+func TestWorkloadHash_Compact(t *testing.T) {
+	cleanPersistFile()
+	hashName := NewHashName()
+	for i := 0; i <= minCompactRecords; i++ {
+		hashName.StrToNum("foo")
+		hashName.Delete("foo")
+	}
+	if err := hashName.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if hashName.journalRecords != 0 {
+		t.Errorf("journal records = %d, want compacted", hashName.journalRecords)
+	}
+}
+
+func TestWorkloadHash_Stats(t *testing.T) {
+	cleanPersistFile()
+	hashName := NewHashName()
+	for _, str := range []string{"foo", "bar", "costarring", "liquid"} {
+		hashName.StrToNum(str)
+	}
+
+	stats := hashName.Stats()
+	expected := HashNameStats{Names: 4, Collisions: 1, MaxProbe: 1}
+	if stats != expected {
+		t.Errorf("Stats() = %+v, want %+v", stats, expected)
+	}
+}
+
+// BenchmarkHashName_Flush compares an fsync per new name with one per xDS response of 1000 names.
+func BenchmarkHashName_Flush(b *testing.B) {
+	for _, batch := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("names per flush %d", batch), func(b *testing.B) {
+			cleanPersistFile()
+			defer cleanPersistFile()
+			hashName := NewHashName()
+			for i := 0; i < b.N; i++ {
+				hashName.StrToNum(fmt.Sprintf("ns/name-%d", i))
+				if (i+1)%batch == 0 {
+					if err := hashName.Flush(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -17,10 +17,13 @@
 package workload
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
+	"github.com/cilium/ebpf"
 	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
@@ -31,14 +34,16 @@ import (
 	"kmesh.net/kmesh/pkg/auth"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/config"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	bpf "kmesh.net/kmesh/pkg/controller/workload/bpfcache"
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
 	"kmesh.net/kmesh/pkg/nets"
 )
 
 const (
-	LbPolicyRandom    = 0
-	KmeshWaypointPort = 15019 // use this fixed port instead of the HboneMtlsPort in kmesh
+	LbPolicyRandom   = 0
+	LbPolicyStrict   = 1
+	LbPolicyFailover = 2
 )
 
 type Processor struct {
@@ -48,20 +53,36 @@ type Processor struct {
 	hashName *HashName
 	// workloads indexer, svc key -> workload id
 	endpointsByService map[string]map[string]struct{}
-	bpf                *bpf.Cache
-	nodeName           string
-	WorkloadCache      cache.WorkloadCache
-	ServiceCache       cache.ServiceCache
+	// users of the waypoints specified by hostname, waypoint svc key -> workload id / svc key
+	workloadsByWaypoint map[string]map[string]struct{}
+	servicesByWaypoint  map[string]map[string]struct{}
+	// workloads not stored until their waypoint service is received, workload id
+	pendingBackends map[string]struct{}
+	bpf             *bpf.Cache
+	nodeName        string
+	// the target port of the services used as waypoints
+	waypointPort uint32
+	// locality of the node kmesh runs on, used by locality load balancing
+	locality *bpf.LocalityCache
+	// the bpf maps are reused from the last run and need to be reconciled after the first full sync
+	needReconcile bool
+	WorkloadCache cache.WorkloadCache
+	ServiceCache  cache.ServiceCache
 }
 
 func newProcessor(workloadMap bpf2go.KmeshCgroupSockWorkloadMaps) *Processor {
 	return &Processor{
-		hashName:           NewHashName(),
-		endpointsByService: make(map[string]map[string]struct{}),
-		bpf:                bpf.NewCache(workloadMap),
-		nodeName:           os.Getenv("NODE_NAME"),
-		WorkloadCache:      cache.NewWorkloadCache(),
-		ServiceCache:       cache.NewServiceCache(),
+		hashName:            NewHashName(),
+		endpointsByService:  make(map[string]map[string]struct{}),
+		workloadsByWaypoint: make(map[string]map[string]struct{}),
+		servicesByWaypoint:  make(map[string]map[string]struct{}),
+		pendingBackends:     make(map[string]struct{}),
+		bpf:                 bpf.NewCache(workloadMap),
+		nodeName:            os.Getenv("NODE_NAME"),
+		waypointPort:        constants.KmeshWaypointPort,
+		locality:            bpf.NewLocalityCache(),
+		WorkloadCache:       cache.NewWorkloadCache(),
+		ServiceCache:        cache.NewServiceCache(),
 	}
 }
 
@@ -93,36 +114,72 @@ func (p *Processor) processWorkloadResponse(rsp *service_discovery_v3.DeltaDisco
 	switch rsp.GetTypeUrl() {
 	case AddressType:
 		err = p.handleAddressTypeResponse(rsp)
+		// the first address response holds all the resources, the caches are complete from now on
+		if err == nil && p.needReconcile {
+			if err = p.reconcile(); err != nil {
+				recordMapError(err)
+				err = fmt.Errorf("reconcile bpf maps failed, %s", err)
+			}
+			p.needReconcile = false
+		}
+		rbac.SetAddressSynced()
 	case AuthorizationType:
 		err = p.handleAuthorizationTypeResponse(rsp, rbac)
+		rbac.SetPolicySynced()
 	default:
 		err = fmt.Errorf("unsupported type url %s", rsp.GetTypeUrl())
 	}
+	telemetry.RecordXdsPush(rsp.GetTypeUrl(), err)
 	if err != nil {
 		log.Error(err)
 	}
+	// the names assigned while handling the response are persisted together
+	if err = p.hashName.Flush(); err != nil {
+		log.Errorf("error persisting the hash names: %v", err)
+	}
+	// both the workloads and the policies decide the policies offloaded into the bpf maps
+	rbac.SyncPolicyMaps()
+	// the connections to unknown destinations may be known now
+	rbac.RetryPending()
 }
 
 func (p *Processor) deletePodFrontendData(uid uint32) error {
 	var (
 		bk = bpf.BackendKey{}
 		bv = bpf.BackendValue{}
-		fk = bpf.FrontendKey{}
 	)
 
 	bk.BackendUid = uid
 	if err := p.bpf.BackendLookup(&bk, &bv); err == nil {
 		log.Debugf("Find BackendValue: [%#v]", bv)
-		fk.Ip = bv.Ip
-		if err = p.bpf.FrontendDelete(&fk); err != nil {
-			log.Errorf("FrontendDelete failed: %s", err)
-			return err
+		for _, ip := range bv.Addresses() {
+			if err = p.deletePodFrontendAddr(uid, ip); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// deletePodFrontendAddr deletes the frontend record of one pod address, the address may have been
+// reused by another pod already, in which case the record is kept.
+func (p *Processor) deletePodFrontendAddr(uid uint32, ip [16]byte) error {
+	var (
+		fk = bpf.FrontendKey{Ip: ip}
+		fv = bpf.FrontendValue{}
+	)
+
+	if err := p.bpf.FrontendLookup(&fk, &fv); err != nil || fv.UpstreamId != uid {
+		return nil
+	}
+	if err := p.bpf.FrontendDelete(&fk); err != nil {
+		log.Errorf("FrontendDelete failed: %s", err)
+		return err
+	}
+	return nil
+}
+
 func (p *Processor) storePodFrontendData(uid uint32, ip []byte) error {
 	var (
 		fk = bpf.FrontendKey{}
@@ -142,16 +199,14 @@ func (p *Processor) storePodFrontendData(uid uint32, ip []byte) error {
 
 func (p *Processor) removeWorkloadResource(removedResources []string) error {
 	var (
-		err               error
-		skUpdate          = bpf.ServiceKey{}
-		svUpdate          = bpf.ServiceValue{}
-		lastEndpointKey   = bpf.EndpointKey{}
-		lastEndpointValue = bpf.EndpointValue{}
-		bkDelete          = bpf.BackendKey{}
+		err      error
+		bkDelete = bpf.BackendKey{}
 	)
 
 	for _, uid := range removedResources {
+		p.indexWaypoint(p.workloadsByWaypoint, uid, p.WorkloadCache.GetWorkloadByUid(uid).GetWaypoint(), nil)
 		p.WorkloadCache.DeleteWorkload(uid)
+		delete(p.pendingBackends, uid)
 		backendUid := p.hashName.StrToNum(uid)
 		// for Pod to Pod access, Pod info stored in frontend map, when Pod offline, we need delete the related records
 		if err = p.deletePodFrontendData(backendUid); err != nil {
@@ -159,47 +214,15 @@ func (p *Processor) removeWorkloadResource(removedResources []string) error {
 			goto failed
 		}
 
-		// 1. find all endpoint keys related to this workload
-		if eks := p.bpf.EndpointIterFindKey(backendUid); len(eks) != 0 {
-			for _, ek := range eks {
-				log.Debugf("Find EndpointKey: [%#v]", ek)
-				// 2. find the service
-				skUpdate.ServiceId = ek.ServiceId
-				if err = p.bpf.ServiceLookup(&skUpdate, &svUpdate); err == nil {
-					log.Debugf("Find ServiceValue: [%#v]", svUpdate)
-					// 3. find the last indexed endpoint of the service
-					lastEndpointKey.ServiceId = skUpdate.ServiceId
-					lastEndpointKey.BackendIndex = svUpdate.EndpointCount
-					if err = p.bpf.EndpointLookup(&lastEndpointKey, &lastEndpointValue); err == nil {
-						log.Debugf("Find EndpointValue: [%#v]", lastEndpointValue)
-						// 4. switch the index of the last with the current removed endpoint
-						if err = p.bpf.EndpointUpdate(&ek, &lastEndpointValue); err != nil {
-							log.Errorf("EndpointUpdate failed: %s", err)
-							goto failed
-						}
-						if err = p.bpf.EndpointDelete(&lastEndpointKey); err != nil {
-							log.Errorf("EndpointDelete failed: %s", err)
-							goto failed
-						}
-						svUpdate.EndpointCount = svUpdate.EndpointCount - 1
-						if err = p.bpf.ServiceUpdate(&skUpdate, &svUpdate); err != nil {
-							log.Errorf("ServiceUpdate failed: %s", err)
-							goto failed
-						}
-					} else {
-						// last indexed endpoint not exists, this should not occur
-						// we should delete the endpoint just in case leak
-						if err = p.bpf.EndpointDelete(&ek); err != nil {
-							log.Errorf("EndpointDelete failed: %s", err)
-							goto failed
-						}
-					}
-				} else { // service not exist, we should delete the endpoint
-					if err = p.bpf.EndpointDelete(&ek); err != nil {
-						log.Errorf("EndpointDelete failed: %s", err)
-						goto failed
-					}
-				}
+		if err = p.deleteBackendEndpoints(backendUid); err != nil {
+			log.Errorf("deleteBackendEndpoints failed: %s", err)
+			goto failed
+		}
+
+		for _, bsk := range p.bpf.BackendServiceIterFindKey(backendUid) {
+			if err = p.bpf.BackendServiceDelete(&bsk); err != nil {
+				log.Errorf("BackendServiceDelete failed: %s", err)
+				goto failed
 			}
 		}
 
@@ -215,6 +238,39 @@ failed:
 	return err
 }
 
+// deleteBackendEndpoints removes the backend from the endpoints of all the services it belongs to,
+// every service is published at once, so it never points to a removed backend.
+func (p *Processor) deleteBackendEndpoints(backendUid uint32) error {
+	txns := make(map[uint32]*bpf.EndpointTxn)
+	for _, ek := range p.bpf.EndpointIterFindKey(backendUid) {
+		log.Debugf("Find EndpointKey: [%#v]", ek)
+		txn, ok := txns[ek.ServiceId]
+		if !ok {
+			txn = p.bpf.NewEndpointTxn(ek.ServiceId)
+			txns[ek.ServiceId] = txn
+		}
+		// the endpoint is not reachable if it is not in the published set of an existing service
+		if !txn.Exists() || ek.Version != txn.Service.EndpointVersion || ek.Prio >= bpf.PrioCount ||
+			ek.BackendIndex > txn.Service.EndpointCount[ek.Prio] {
+			if err := p.bpf.EndpointDelete(&ek); err != nil {
+				log.Errorf("EndpointDelete failed: %s", err)
+				return err
+			}
+		}
+	}
+
+	for serviceId, txn := range txns {
+		if !txn.Exists() || !txn.Remove(backendUid) {
+			continue
+		}
+		if err := txn.Commit(); err != nil {
+			log.Errorf("remove endpoint %d of service %d failed: %s", backendUid, serviceId, err)
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *Processor) deleteFrontendData(id uint32) error {
 	var (
 		err error
@@ -243,7 +299,10 @@ func (p *Processor) removeServiceResource(resources []string) error {
 	)
 
 	for _, name := range resources {
+		p.indexWaypoint(p.servicesByWaypoint, name, p.ServiceCache.GetService(name).GetWaypoint(), nil)
 		p.ServiceCache.DeleteService(name)
+		// the users of the service as a waypoint lose their waypoint address
+		p.updateWaypointUsers(name)
 		serviceId := p.hashName.StrToNum(name)
 		skDelete.ServiceId = serviceId
 		if err = p.bpf.ServiceLookup(&skDelete, &svDelete); err == nil {
@@ -257,15 +316,25 @@ func (p *Processor) removeServiceResource(resources []string) error {
 				goto failed
 			}
 
-			var i uint32
-			for i = 1; i <= svDelete.EndpointCount; i++ {
-				ekDelete.ServiceId = serviceId
-				ekDelete.BackendIndex = i
-				if err = p.bpf.EndpointDelete(&ekDelete); err != nil {
-					log.Errorf("EndpointDelete failed: %s", err)
+			for _, spk := range p.bpf.ServicePortIterFindKey(serviceId) {
+				if err = p.bpf.ServicePortDelete(&spk); err != nil {
+					log.Errorf("ServicePortDelete failed: %s", err)
 					goto failed
 				}
 			}
+
+			for prio := uint32(0); prio < bpf.PrioCount; prio++ {
+				for i := uint32(1); i <= svDelete.EndpointCount[prio]; i++ {
+					ekDelete.ServiceId = serviceId
+					ekDelete.Version = svDelete.EndpointVersion
+					ekDelete.Prio = prio
+					ekDelete.BackendIndex = i
+					if err = p.bpf.EndpointDelete(&ekDelete); err != nil {
+						log.Errorf("EndpointDelete failed: %s", err)
+						goto failed
+					}
+				}
+			}
 		}
 		p.hashName.Delete(name)
 	}
@@ -274,28 +343,6 @@ failed:
 	return err
 }
 
-func (p *Processor) storeEndpointWithService(sk *bpf.ServiceKey, sv *bpf.ServiceValue, uid uint32) error {
-	var (
-		err error
-		ek  = bpf.EndpointKey{}
-		ev  = bpf.EndpointValue{}
-	)
-	sv.EndpointCount++
-	ek.BackendIndex = sv.EndpointCount
-	ek.ServiceId = sk.ServiceId
-	ev.BackendUid = uid
-	if err = p.bpf.EndpointUpdate(&ek, &ev); err != nil {
-		log.Errorf("Update endpoint map failed, err:%s", err)
-		return err
-	}
-	if err = p.bpf.ServiceUpdate(sk, sv); err != nil {
-		log.Errorf("Update ServiceUpdate map failed, err:%s", err)
-		return err
-	}
-
-	return nil
-}
-
 func (p *Processor) storeServiceEndpoint(workload_uid string, serviceName string) {
 	wls, ok := p.endpointsByService[serviceName]
 	if !ok {
@@ -306,37 +353,67 @@ func (p *Processor) storeServiceEndpoint(workload_uid string, serviceName string
 	wls[workload_uid] = struct{}{}
 }
 
-func (p *Processor) storeBackendData(uid uint32, ip []byte, waypoint *workloadapi.GatewayAddress, portList map[string]*workloadapi.PortList) error {
+func (p *Processor) storeBackendData(uid uint32, workload *workloadapi.Workload) error {
 	var (
-		bk = bpf.BackendKey{}
-		bv = bpf.BackendValue{}
+		bk    = bpf.BackendKey{}
+		bv    = bpf.BackendValue{}
+		oldBv = bpf.BackendValue{}
+		ips   = workload.GetAddresses()
 	)
 
-	bk.BackendUid = uid
-	nets.CopyIpByteFromSlice(&bv.Ip, ip)
-	bv.ServiceCount = 0
-	for serviceName := range portList {
-		bv.Services[bv.ServiceCount] = p.hashName.StrToNum(serviceName)
-		bv.ServiceCount++
-		if bv.ServiceCount >= bpf.MaxServiceNum {
-			log.Warnf("exceed the max service count, currently, a pod can belong to a maximum of 10 services")
-			break
-		}
+	// a backend stored without its waypoint would let the traffic bypass it, the backend is
+	// programmed by updateWaypointUsers once the waypoint service is received
+	if p.waypointPending(workload.GetWaypoint()) {
+		log.Warnf("waypoint service %s of workload %s is not resolved yet", waypointServiceName(workload.GetWaypoint()), workload.GetUid())
+		p.pendingBackends[workload.GetUid()] = struct{}{}
+		return nil
 	}
+	delete(p.pendingBackends, workload.GetUid())
 
-	if waypoint != nil {
-		nets.CopyIpByteFromSlice(&bv.WaypointAddr, waypoint.GetAddress().Address)
-		bv.WaypointPort = nets.ConvertPortToBigEndian(waypoint.GetHboneMtlsPort())
+	bk.BackendUid = uid
+	if dropped := bv.SetAddresses(ips); len(dropped) != 0 {
+		log.Warnf("exceed the max address count, currently, a pod can have a maximum of %d addresses", bpf.MaxAddressNum)
+	}
+	if addr, port, ok := p.resolveWaypoint(workload.GetWaypoint()); ok {
+		bv.WaypointAddr = addr
+		bv.WaypointPort = port
 	}
+	setBackendTunnel(&bv, workload)
+
+	// the addresses of a workload may change on update, remember the old ones to clean up the stale frontend records
+	oldFound := p.bpf.BackendLookup(&bk, &oldBv) == nil
 
 	if err := p.bpf.BackendUpdate(&bk, &bv); err != nil {
 		log.Errorf("Update backend map failed, err:%s", err)
 		return err
 	}
 
-	if err := p.storePodFrontendData(uid, ip); err != nil {
-		log.Errorf("storePodFrontendData failed, err:%s", err)
-		return err
+	for serviceName := range workload.GetServices() {
+		bsk := bpf.BackendServiceKey{BackendUid: uid, ServiceId: p.hashName.StrToNum(serviceName)}
+		if err := p.bpf.BackendServiceUpdate(&bsk); err != nil {
+			log.Errorf("Update backend service map failed, err:%s", err)
+			return err
+		}
+	}
+
+	for _, ip := range ips {
+		if err := p.storePodFrontendData(uid, ip); err != nil {
+			log.Errorf("storePodFrontendData failed, err:%s", err)
+			return err
+		}
+	}
+
+	if oldFound {
+		newAddrs := bv.Addresses()
+		for _, ip := range oldBv.Addresses() {
+			if slices.Contains(newAddrs, ip) {
+				continue
+			}
+			if err := p.deletePodFrontendAddr(uid, ip); err != nil {
+				log.Errorf("deletePodFrontendAddr failed, err:%s", err)
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -345,11 +422,8 @@ func (p *Processor) storeBackendData(uid uint32, ip []byte, waypoint *workloadap
 func (p *Processor) handleDataWithService(workload *workloadapi.Workload) error {
 	var (
 		err error
-		sk  = bpf.ServiceKey{}
-		sv  = bpf.ServiceValue{}
-
-		bk = bpf.BackendKey{}
-		bv = bpf.BackendValue{}
+		bk  = bpf.BackendKey{}
+		bv  = bpf.BackendValue{}
 	)
 
 	backend_uid := p.hashName.StrToNum(workload.GetUid())
@@ -357,67 +431,55 @@ func (p *Processor) handleDataWithService(workload *workloadapi.Workload) error
 		bk.BackendUid = backend_uid
 		// for update sense, if the backend is exist, just need update it
 		if err = p.bpf.BackendLookup(&bk, &bv); err != nil {
-			sk.ServiceId = p.hashName.StrToNum(serviceName)
+			txn := p.bpf.NewEndpointTxn(p.hashName.StrToNum(serviceName))
 			// the service already stored in map, add endpoint
-			if err = p.bpf.ServiceLookup(&sk, &sv); err == nil {
-				if err = p.storeEndpointWithService(&sk, &sv, backend_uid); err != nil {
-					log.Errorf("storeEndpointWithService failed, err:%s", err)
+			if txn.Exists() {
+				txn.Add(backend_uid, p.calcEndpointPrio(workload, serviceName))
+				if err = txn.Commit(); err != nil {
+					log.Errorf("add endpoint of service %s failed, err:%s", serviceName, err)
 					return err
 				}
 			} else {
 				p.storeServiceEndpoint(workload.GetUid(), serviceName)
 			}
+		} else if err = p.moveEndpointPrio(backend_uid, workload, serviceName); err != nil {
+			return err
 		}
 	}
 
-	if len(workload.GetAddresses()) > 1 {
-		log.Warnf("current only support single ip of a pod")
-	}
-
-	for _, ip := range workload.GetAddresses() { // current only support single ip, if a pod have multi ips, the last one will be stored finally
-		if err = p.storeBackendData(backend_uid, ip, workload.GetWaypoint(), workload.GetServices()); err != nil {
-			log.Errorf("storeBackendData failed, err:%s", err)
-			return err
-		}
+	if err = p.storeBackendData(backend_uid, workload); err != nil {
+		log.Errorf("storeBackendData failed, err:%s", err)
+		return err
 	}
 
 	return nil
 }
 
 func (p *Processor) handleDataWithoutService(workload *workloadapi.Workload) error {
-	var (
-		err error
-		bk  = bpf.BackendKey{}
-		bv  = bpf.BackendValue{}
-	)
-
 	uid := p.hashName.StrToNum(workload.GetUid())
-	ips := workload.GetAddresses()
-	for _, ip := range ips {
-		if waypoint := workload.GetWaypoint(); waypoint != nil {
-			nets.CopyIpByteFromSlice(&bv.WaypointAddr, waypoint.GetAddress().Address)
-			bv.WaypointPort = nets.ConvertPortToBigEndian(waypoint.GetHboneMtlsPort())
-		}
-
-		bk.BackendUid = uid
-
-		nets.CopyIpByteFromSlice(&bv.Ip, ip)
-		if err = p.bpf.BackendUpdate(&bk, &bv); err != nil {
-			log.Errorf("Update backend map failed, err:%s", err)
-			return err
-		}
-
-		if err = p.storePodFrontendData(uid, ip); err != nil {
-			log.Errorf("storePodFrontendData failed, err:%s", err)
-			return err
-		}
+	if err := p.storeBackendData(uid, workload); err != nil {
+		log.Errorf("storeBackendData failed, err:%s", err)
+		return err
 	}
 	return nil
 }
 
 func (p *Processor) handleWorkload(workload *workloadapi.Workload) error {
 	log.Debugf("handle workload: %s", workload.Uid)
+	oldWorkload := p.WorkloadCache.GetWorkloadByUid(workload.GetUid())
+	oldServices := oldWorkload.GetServices()
 	p.WorkloadCache.AddWorkload(workload)
+	p.indexWaypoint(p.workloadsByWaypoint, workload.GetUid(), oldWorkload.GetWaypoint(), workload.GetWaypoint())
+	if reasons := TunnelMisconfigs(workload); len(reasons) != 0 {
+		log.Warnf("workload %s has misconfigured tunnel: %s", workload.GetUid(), strings.Join(reasons, "; "))
+	}
+
+	// the locality of the local node is learned from the workloads running on it
+	if p.nodeName != "" && workload.GetNode() == p.nodeName {
+		if p.locality.SetLocality(p.nodeName, workload.GetClusterId(), workload.GetNetwork(), workload.GetLocality()) {
+			p.updateLocalityLBPrio()
+		}
+	}
 
 	// if have the service name, the workload belongs to a service
 	if workload.GetServices() != nil {
@@ -432,6 +494,42 @@ func (p *Processor) handleWorkload(workload *workloadapi.Workload) error {
 		}
 	}
 
+	// the workload may have left some services, it is no longer a valid backend of them
+	backendUid := p.hashName.StrToNum(workload.GetUid())
+	for serviceName := range oldServices {
+		if _, ok := workload.GetServices()[serviceName]; ok {
+			continue
+		}
+		if err := p.deleteServiceEndpoint(backendUid, workload.GetUid(), serviceName); err != nil {
+			return err
+		}
+		bsk := bpf.BackendServiceKey{BackendUid: backendUid, ServiceId: p.hashName.StrToNum(serviceName)}
+		if err := p.bpf.BackendServiceDelete(&bsk); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Errorf("BackendServiceDelete failed: %s", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteServiceEndpoint removes the backend from the endpoints of a service it left
+func (p *Processor) deleteServiceEndpoint(backendUid uint32, workloadUid, serviceName string) error {
+	if wls, ok := p.endpointsByService[serviceName]; ok {
+		delete(wls, workloadUid)
+		if len(wls) == 0 {
+			delete(p.endpointsByService, serviceName)
+		}
+	}
+
+	txn := p.bpf.NewEndpointTxn(p.hashName.StrToNum(serviceName))
+	if !txn.Exists() || !txn.Remove(backendUid) {
+		return nil
+	}
+	if err := txn.Commit(); err != nil {
+		log.Errorf("remove endpoint %d of service %s failed: %s", backendUid, serviceName, err)
+		return err
+	}
 	return nil
 }
 
@@ -453,73 +551,84 @@ func (p *Processor) storeServiceFrontendData(serviceId uint32, service *workload
 	return nil
 }
 
-func (p *Processor) storeServiceData(serviceName string, waypoint *workloadapi.GatewayAddress, ports []*workloadapi.Port) error {
+func (p *Processor) storeServicePorts(serviceId uint32, serviceName string, ports []*workloadapi.Port) error {
 	var (
-		err      error
-		ek       = bpf.EndpointKey{}
-		ev       = bpf.EndpointValue{}
-		sk       = bpf.ServiceKey{}
-		oldValue = bpf.ServiceValue{}
+		spk = bpf.ServicePortKey{}
+		spv = bpf.ServicePortValue{}
 	)
 
-	sk.ServiceId = p.hashName.StrToNum(serviceName)
-
-	newValue := bpf.ServiceValue{}
-	newValue.LbPolicy = LbPolicyRandom
-	if waypoint != nil {
-		nets.CopyIpByteFromSlice(&newValue.WaypointAddr, waypoint.GetAddress().Address)
-		newValue.WaypointPort = nets.ConvertPortToBigEndian(waypoint.GetHboneMtlsPort())
+	spk.ServiceId = serviceId
+	// traffic to a waypoint is sent to the port kmesh waypoints listen on
+	isWaypoint := p.isWaypoint(serviceName)
+	newPorts := make(map[uint32]struct{}, len(ports))
+	for _, port := range ports {
+		spk.ServicePort = nets.ConvertPortToBigEndian(port.ServicePort)
+		if isWaypoint {
+			spv.TargetPort = nets.ConvertPortToBigEndian(p.waypointPort)
+		} else {
+			spv.TargetPort = nets.ConvertPortToBigEndian(port.TargetPort)
+		}
+		if err := p.bpf.ServicePortUpdate(&spk, &spv); err != nil {
+			log.Errorf("Update ServicePort failed, err:%s", err)
+			return err
+		}
+		newPorts[spk.ServicePort] = struct{}{}
 	}
 
-	for i, port := range ports {
-		if i >= bpf.MaxPortNum {
-			log.Warnf("exceed the max port count,current only support maximum of 10 ports")
-			break
+	// remove the ports no longer exposed by the service
+	for _, oldKey := range p.bpf.ServicePortIterFindKey(serviceId) {
+		if _, ok := newPorts[oldKey.ServicePort]; ok {
+			continue
 		}
-
-		newValue.ServicePort[i] = nets.ConvertPortToBigEndian(port.ServicePort)
-		if strings.Contains(serviceName, "waypoint") {
-			newValue.TargetPort[i] = nets.ConvertPortToBigEndian(KmeshWaypointPort)
-		} else {
-			newValue.TargetPort[i] = nets.ConvertPortToBigEndian(port.TargetPort)
+		if err := p.bpf.ServicePortDelete(&oldKey); err != nil {
+			log.Errorf("ServicePortDelete failed, err:%s", err)
+			return err
 		}
 	}
 
-	// Already exists, it means this is service update.
-	if err = p.bpf.ServiceLookup(&sk, &oldValue); err == nil {
-		newValue.EndpointCount = oldValue.EndpointCount
-	} else {
+	return nil
+}
+
+func (p *Processor) storeServiceData(serviceName string, waypoint *workloadapi.GatewayAddress, ports []*workloadapi.Port,
+	loadBalancing *workloadapi.LoadBalancing, regroup bool) error {
+	serviceId := p.hashName.StrToNum(serviceName)
+
+	// ports are stored before the service, so the service never refers to a missing port
+	if err := p.storeServicePorts(serviceId, serviceName, ports); err != nil {
+		log.Errorf("storeServicePorts failed, err:%s", err)
+		return err
+	}
+
+	txn := p.bpf.NewEndpointTxn(serviceId)
+	txn.Service.LbPolicy = lbPolicyOf(loadBalancing)
+	txn.Service.WaypointAddr, txn.Service.WaypointPort, _ = p.resolveWaypoint(waypoint)
+
+	if !txn.Exists() {
 		// Only update the endpoint map when the service is first time added
-		endpointCaches, ok := p.endpointsByService[serviceName]
-		if ok {
-			newValue.EndpointCount = uint32(len(endpointCaches))
-			endpointIndex := uint32(0)
-			for workloadUid := range endpointCaches {
-				endpointIndex++
-				ek.ServiceId = sk.ServiceId
-				ek.BackendIndex = endpointIndex
-				ev.BackendUid = p.hashName.StrToNum(workloadUid)
-
-				if err = p.bpf.EndpointUpdate(&ek, &ev); err != nil {
-					log.Errorf("Update Endpoint failed, err:%s", err)
-					return err
-				}
-			}
+		for workloadUid := range p.endpointsByService[serviceName] {
+			prio := p.locality.CalcLocalityLBPrio(p.WorkloadCache.GetWorkloadByUid(workloadUid), loadBalancing.GetRoutingPreference())
+			txn.Add(p.hashName.StrToNum(workloadUid), prio)
 		}
-		delete(p.endpointsByService, serviceName)
+	} else if regroup {
+		// the new lb policy and the regrouped endpoints are published together
+		p.regroupEndpoints(txn, serviceName, func(uint32) bool { return true })
 	}
 
-	if err = p.bpf.ServiceUpdate(&sk, &newValue); err != nil {
+	if err := txn.Commit(); err != nil {
 		log.Errorf("Update Service failed, err:%s", err)
+		return err
 	}
+	delete(p.endpointsByService, serviceName)
 
 	return nil
 }
 
 func (p *Processor) handleService(service *workloadapi.Service) error {
 	log.Debugf("service resource name: %s/%s", service.Namespace, service.Hostname)
-	p.ServiceCache.AddOrUpdateService(service)
 	serviceName := service.ResourceName()
+	oldService := p.ServiceCache.GetService(serviceName)
+	p.ServiceCache.AddOrUpdateService(service)
+	p.indexWaypoint(p.servicesByWaypoint, serviceName, oldService.GetWaypoint(), service.GetWaypoint())
 	serviceId := p.hashName.StrToNum(serviceName)
 
 	// store in frontend
@@ -528,14 +637,110 @@ func (p *Processor) handleService(service *workloadapi.Service) error {
 		return err
 	}
 
+	// the endpoints need to be regrouped if the locality load balancing of the service changed
+	regroup := oldService != nil && !proto.Equal(oldService.GetLoadBalancing(), service.GetLoadBalancing())
+
 	// get endpoint from ServiceCache, and update service and endpoint map
-	if err := p.storeServiceData(serviceName, service.GetWaypoint(), service.GetPorts()); err != nil {
+	if err := p.storeServiceData(serviceName, service.GetWaypoint(), service.GetPorts(), service.GetLoadBalancing(), regroup); err != nil {
 		log.Errorf("storeServiceData failed, err:%s", err)
 		return err
 	}
+
+	// the users of the service as a waypoint follow its VIP
+	addressEqual := func(a, b *workloadapi.NetworkAddress) bool { return proto.Equal(a, b) }
+	if oldService == nil || !slices.EqualFunc(oldService.GetAddresses(), service.GetAddresses(), addressEqual) {
+		p.updateWaypointUsers(serviceName)
+	}
 	return nil
 }
 
+// lbPolicyOf converts the load balancing config of a service to the lb policy in bpf map
+func lbPolicyOf(loadBalancing *workloadapi.LoadBalancing) uint32 {
+	if len(loadBalancing.GetRoutingPreference()) == 0 {
+		return LbPolicyRandom
+	}
+	if loadBalancing.GetMode() == workloadapi.LoadBalancing_STRICT {
+		return LbPolicyStrict
+	}
+	// UNSPECIFIED_MODE with routing preferences behaves as failover, same as ztunnel
+	return LbPolicyFailover
+}
+
+// calcEndpointPrio returns the locality lb priority of the workload as an endpoint of the service
+func (p *Processor) calcEndpointPrio(workload *workloadapi.Workload, serviceName string) uint32 {
+	loadBalancing := p.ServiceCache.GetService(serviceName).GetLoadBalancing()
+	return p.locality.CalcLocalityLBPrio(workload, loadBalancing.GetRoutingPreference())
+}
+
+// moveEndpointPrio moves the endpoint of the backend to its locality lb priority in the service,
+// which changes with the locality of the workload on update.
+func (p *Processor) moveEndpointPrio(backendUid uint32, workload *workloadapi.Workload, serviceName string) error {
+	txn := p.bpf.NewEndpointTxn(p.hashName.StrToNum(serviceName))
+	if !txn.Exists() {
+		return nil
+	}
+	prio := p.calcEndpointPrio(workload, serviceName)
+	if slices.Contains(txn.Backends()[prio], backendUid) || !txn.Remove(backendUid) {
+		return nil
+	}
+	txn.Add(backendUid, prio)
+	if err := txn.Commit(); err != nil {
+		log.Errorf("move endpoint %d of service %s to prio %d failed: %s", backendUid, serviceName, prio, err)
+		return err
+	}
+	return nil
+}
+
+// updateEndpointPrio regroups all the endpoints of the service by their locality lb priority.
+func (p *Processor) updateEndpointPrio(serviceName string) error {
+	return p.rewriteEndpoints(serviceName, func(uint32) bool { return true })
+}
+
+// rewriteEndpoints regroups the endpoints of the service like updateEndpointPrio and drops the
+// backends rejected by keep.
+func (p *Processor) rewriteEndpoints(serviceName string, keep func(backendUid uint32) bool) error {
+	txn := p.bpf.NewEndpointTxn(p.hashName.StrToNum(serviceName))
+	if !txn.Exists() {
+		// service not stored yet, the endpoints will be grouped when it is stored
+		return nil
+	}
+
+	p.regroupEndpoints(txn, serviceName, keep)
+	if err := txn.Commit(); err != nil {
+		log.Errorf("Update Service failed, err:%s", err)
+		return err
+	}
+	return nil
+}
+
+// regroupEndpoints stages the endpoints of the service again with their current locality lb priority.
+func (p *Processor) regroupEndpoints(txn *bpf.EndpointTxn, serviceName string, keep func(backendUid uint32) bool) {
+	backends := txn.Backends()
+	txn.Reset()
+	for _, uids := range backends {
+		for _, uid := range uids {
+			if !keep(uid) {
+				continue
+			}
+			workload := p.WorkloadCache.GetWorkloadByUid(p.hashName.NumToStr(uid))
+			txn.Add(uid, p.calcEndpointPrio(workload, serviceName))
+		}
+	}
+}
+
+// updateLocalityLBPrio regroups the endpoints of all the services using locality load balancing,
+// it is called when the locality of the local node changed.
+func (p *Processor) updateLocalityLBPrio() {
+	for _, service := range p.ServiceCache.List() {
+		if len(service.GetLoadBalancing().GetRoutingPreference()) == 0 {
+			continue
+		}
+		if err := p.updateEndpointPrio(service.ResourceName()); err != nil {
+			log.Errorf("updateEndpointPrio for service %s failed, err:%s", service.ResourceName(), err)
+		}
+	}
+}
+
 func (p *Processor) handleRemovedAddresses(removed []string) error {
 	var workloadNames []string
 	var serviceNames []string
@@ -581,16 +786,29 @@ func (p *Processor) handleAddressTypeResponse(rsp *service_discovery_v3.DeltaDis
 		default:
 			log.Errorf("unknown type")
 		}
+		recordMapError(err)
 	}
 	if err != nil {
 		log.Error(err)
 	}
 
-	_ = p.handleRemovedAddresses(rsp.RemovedResources)
+	recordMapError(p.handleRemovedAddresses(rsp.RemovedResources))
 
 	return err
 }
 
+// recordMapError counts the failed bpf map update the error comes from
+func recordMapError(err error) {
+	var mapErr *bpf.MapUpdateError
+	if !errors.As(err, &mapErr) {
+		return
+	}
+	telemetry.RecordMapUpdateError(mapErr.Map)
+	if errors.Is(mapErr, bpf.ErrMapFull) {
+		telemetry.RecordMapOverflow(mapErr.Map)
+	}
+}
+
 func (p *Processor) handleAuthorizationTypeResponse(rsp *service_discovery_v3.DeltaDiscoveryResponse, rbac *auth.Rbac) error {
 	if rbac == nil {
 		return fmt.Errorf("Rbac module uninitialized")
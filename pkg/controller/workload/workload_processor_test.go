@@ -17,10 +17,12 @@
 package workload
 
 import (
+	"fmt"
 	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 	"k8s.io/apimachinery/pkg/util/rand"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
@@ -92,11 +94,102 @@ func Test_handleWorkload(t *testing.T) {
 	checkServiceMap(t, p, svcID, fakeSvc, 2)
 }
 
+func Test_handleServiceWithLocalityLB(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	p.nodeName = "node1"
+
+	// the locality of the local node is learned from the workload running on it
+	local := createFakeWorkload("1.2.3.4")
+	local.Node = "node1"
+	local.Locality = &workloadapi.Locality{Region: "r1", Zone: "z1"}
+	sameRegion := createFakeWorkload("1.2.3.5")
+	sameRegion.Node = "node2"
+	sameRegion.Locality = &workloadapi.Locality{Region: "r1", Zone: "z2"}
+	remote := createFakeWorkload("1.2.3.6")
+	remote.Node = "node3"
+	remote.Locality = &workloadapi.Locality{Region: "r2", Zone: "z3"}
+	for _, wl := range []*workloadapi.Workload{local, sameRegion, remote} {
+		assert.NoError(t, p.handleWorkload(wl))
+	}
+
+	// 1. failover mode, endpoints are grouped by the count of unmatched routing preferences
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	svc.Waypoint = nil
+	svc.LoadBalancing = &workloadapi.LoadBalancing{
+		RoutingPreference: []workloadapi.LoadBalancing_Scope{workloadapi.LoadBalancing_REGION, workloadapi.LoadBalancing_ZONE},
+		Mode:              workloadapi.LoadBalancing_FAILOVER,
+	}
+	assert.NoError(t, p.handleService(svc))
+
+	svcID := checkFrontEndMap(t, svc.Addresses[0].Address, p)
+	var sv bpfcache.ServiceValue
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcID}, &sv))
+	assert.Equal(t, uint32(LbPolicyFailover), sv.LbPolicy)
+	assert.Equal(t, bpfcache.EndpointCounts{1, 1, 1}, sv.EndpointCount)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 0, BackendIndex: 1}, local)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 1, BackendIndex: 1}, sameRegion)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 2, BackendIndex: 1}, remote)
+
+	// 2. switch to strict mode with region preference, endpoints are regrouped
+	svc = createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	svc.Waypoint = nil
+	svc.LoadBalancing = &workloadapi.LoadBalancing{
+		RoutingPreference: []workloadapi.LoadBalancing_Scope{workloadapi.LoadBalancing_REGION},
+		Mode:              workloadapi.LoadBalancing_STRICT,
+	}
+	assert.NoError(t, p.handleService(svc))
+
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcID}, &sv))
+	assert.Equal(t, uint32(LbPolicyStrict), sv.LbPolicy)
+	assert.Equal(t, bpfcache.EndpointCounts{2, 1}, sv.EndpointCount)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 1, BackendIndex: 1}, remote)
+	var ev bpfcache.EndpointValue
+	assert.Error(t, p.bpf.EndpointLookup(&bpfcache.EndpointKey{ServiceId: svcID, Version: sv.EndpointVersion, Prio: 2, BackendIndex: 1}, &ev))
+	assert.Len(t, p.bpf.EndpointIterAll(), 3)
+
+	// 3. the remote workload moves into the region of the node, its endpoint follows it
+	remote = proto.Clone(remote).(*workloadapi.Workload)
+	remote.Locality = &workloadapi.Locality{Region: "r1", Zone: "z3"}
+	assert.NoError(t, p.handleWorkload(remote))
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcID}, &sv))
+	assert.Equal(t, bpfcache.EndpointCounts{3}, sv.EndpointCount)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 0, BackendIndex: 3}, remote)
+
+	// 4. the node moves to another region, the endpoints are regrouped by its locality
+	local = proto.Clone(local).(*workloadapi.Workload)
+	local.Locality = &workloadapi.Locality{Region: "r2", Zone: "z1"}
+	assert.NoError(t, p.handleWorkload(local))
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcID}, &sv))
+	assert.Equal(t, bpfcache.EndpointCounts{1, 2}, sv.EndpointCount)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 0, BackendIndex: 1}, local)
+
+	// 5. remove an endpoint, only the group it belongs to shrinks
+	assert.NoError(t, p.removeWorkloadResource([]string{remote.Uid}))
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcID}, &sv))
+	assert.Equal(t, bpfcache.EndpointCounts{1, 1}, sv.EndpointCount)
+}
+
+// checkEndpoint looks up the endpoint in the endpoint set currently published by the service
+func checkEndpoint(t *testing.T, p *Processor, ek bpfcache.EndpointKey, wl *workloadapi.Workload) {
+	var (
+		sv bpfcache.ServiceValue
+		ev bpfcache.EndpointValue
+	)
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: ek.ServiceId}, &sv))
+	ek.Version = sv.EndpointVersion
+	err := p.bpf.EndpointLookup(&ek, &ev)
+	assert.NoError(t, err)
+	assert.Equal(t, p.hashName.StrToNum(wl.Uid), ev.BackendUid)
+}
+
 func checkServiceMap(t *testing.T, p *Processor, svcId uint32, fakeSvc *workloadapi.Service, endpointCount uint32) {
 	var sv bpfcache.ServiceValue
 	err := p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcId}, &sv)
 	assert.NoError(t, err)
-	assert.Equal(t, sv.EndpointCount, endpointCount)
+	assert.Equal(t, sv.EndpointCount[0], endpointCount)
 	waypointAddr := fakeSvc.GetWaypoint().GetAddress().GetAddress()
 	if waypointAddr != nil {
 		assert.Equal(t, test.EqualIp(sv.WaypointAddr, waypointAddr), true)
@@ -104,11 +197,168 @@ func checkServiceMap(t *testing.T, p *Processor, svcId uint32, fakeSvc *workload
 	assert.Equal(t, sv.WaypointPort, nets.ConvertPortToBigEndian(15008))
 }
 
+func Test_handleServiceWithManyPorts(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+
+	// 1. neither the ports of a service nor the services of a workload are limited
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	svc.Waypoint = nil
+	svc.Ports = nil
+	for i := uint32(0); i < 20; i++ {
+		svc.Ports = append(svc.Ports, &workloadapi.Port{ServicePort: 1000 + i, TargetPort: 2000 + i})
+	}
+	err := p.handleService(svc)
+	assert.NoError(t, err)
+
+	svcID := p.hashName.StrToNum(svc.ResourceName())
+	for _, port := range svc.Ports {
+		var spv bpfcache.ServicePortValue
+		spk := bpfcache.ServicePortKey{ServiceId: svcID, ServicePort: nets.ConvertPortToBigEndian(port.ServicePort)}
+		err = p.bpf.ServicePortLookup(&spk, &spv)
+		assert.NoError(t, err)
+		assert.Equal(t, nets.ConvertPortToBigEndian(port.TargetPort), spv.TargetPort)
+	}
+
+	wl := createFakeWorkload("1.2.3.4")
+	for i := 0; i < 20; i++ {
+		wl.Services[fmt.Sprintf("default/svc%d.default.svc.cluster.local", i)] = &workloadapi.PortList{}
+	}
+	err = p.handleWorkload(wl)
+	assert.NoError(t, err)
+	workloadID := p.hashName.StrToNum(wl.Uid)
+	assert.Len(t, p.bpf.BackendServiceIterFindKey(workloadID), len(wl.Services))
+
+	// 2. the ports and services removed by an update are deleted
+	svc.Ports = svc.Ports[:5]
+	err = p.handleService(svc)
+	assert.NoError(t, err)
+	assert.Len(t, p.bpf.ServicePortIterFindKey(svcID), 5)
+
+	wl = proto.Clone(wl).(*workloadapi.Workload)
+	delete(wl.Services, "default/svc0.default.svc.cluster.local")
+	err = p.handleWorkload(wl)
+	assert.NoError(t, err)
+	assert.Len(t, p.bpf.BackendServiceIterFindKey(workloadID), len(wl.Services))
+
+	// 3. removal cleans up all of them
+	err = p.removeWorkloadResource([]string{wl.Uid})
+	assert.NoError(t, err)
+	assert.Len(t, p.bpf.BackendServiceIterFindKey(workloadID), 0)
+
+	err = p.removeServiceResource([]string{svc.ResourceName()})
+	assert.NoError(t, err)
+	assert.Len(t, p.bpf.ServicePortIterFindKey(svcID), 0)
+}
+
+func Test_handleWorkloadLeavesService(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	otherSvc := createFakeService("othersvc", "10.240.10.3", "10.240.10.2")
+	otherSvc.Hostname = "othersvc.default.svc.cluster.local"
+	pendingSvcName := "default/pendingsvc.default.svc.cluster.local"
+	assert.NoError(t, p.handleService(svc))
+	assert.NoError(t, p.handleService(otherSvc))
+	svcID := p.hashName.StrToNum(svc.ResourceName())
+	otherSvcID := p.hashName.StrToNum(otherSvc.ResourceName())
+
+	// 1. the workload belongs to two services and one which is not received yet
+	wl := createFakeWorkload("1.2.3.4")
+	wl.Services[otherSvc.ResourceName()] = &workloadapi.PortList{}
+	wl.Services[pendingSvcName] = &workloadapi.PortList{}
+	wl2 := createFakeWorkload("1.2.3.5")
+	assert.NoError(t, p.handleWorkload(wl))
+	assert.NoError(t, p.handleWorkload(wl2))
+	checkServiceMap(t, p, svcID, svc, 2)
+	checkServiceMap(t, p, otherSvcID, otherSvc, 1)
+	assert.Contains(t, p.endpointsByService[pendingSvcName], wl.Uid)
+
+	// 2. the workload leaves testsvc and pendingsvc, it is no longer balanced to by them
+	wl = proto.Clone(wl).(*workloadapi.Workload)
+	delete(wl.Services, svc.ResourceName())
+	delete(wl.Services, pendingSvcName)
+	assert.NoError(t, p.handleWorkload(wl))
+	checkServiceMap(t, p, svcID, svc, 1)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: svcID, Prio: 0, BackendIndex: 1}, wl2)
+	checkServiceMap(t, p, otherSvcID, otherSvc, 1)
+	checkEndpoint(t, p, bpfcache.EndpointKey{ServiceId: otherSvcID, Prio: 0, BackendIndex: 1}, wl)
+	assert.NotContains(t, p.endpointsByService, pendingSvcName)
+
+	workloadID := p.hashName.StrToNum(wl.Uid)
+	for _, ek := range p.bpf.EndpointIterFindKey(workloadID) {
+		assert.Equal(t, otherSvcID, ek.ServiceId)
+	}
+}
+
+func Test_handleWorkloadWithMultiAddresses(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+
+	// 1. a dual-stack workload, every address is stored and IPv4 ones come first
+	wl := createFakeWorkload("1.2.3.4")
+	wl.Addresses = [][]byte{
+		netip.MustParseAddr("fd00::1:2:3:4").AsSlice(),
+		netip.MustParseAddr("1.2.3.4").AsSlice(),
+	}
+	err := p.handleWorkload(wl)
+	assert.NoError(t, err)
+
+	workloadID := p.hashName.StrToNum(wl.Uid)
+	for _, ip := range wl.Addresses {
+		assert.Equal(t, workloadID, checkFrontEndMap(t, ip, p))
+	}
+	checkBackendMap(t, p, workloadID, wl)
+
+	var bv bpfcache.BackendValue
+	err = p.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: workloadID}, &bv)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), bv.Ipv4Count)
+	assert.Equal(t, uint32(1), bv.Ipv6Count)
+	assert.True(t, test.EqualIp(bv.Addrs[0], wl.Addresses[1]))
+	assert.True(t, test.EqualIp(bv.Addrs[1], wl.Addresses[0]))
+
+	// 2. the IPv6 address is replaced, the stale frontend record is deleted
+	oldIpv6 := wl.Addresses[0]
+	wl.Addresses = [][]byte{
+		netip.MustParseAddr("1.2.3.4").AsSlice(),
+		netip.MustParseAddr("fd00::5:6:7:8").AsSlice(),
+	}
+	err = p.handleWorkload(wl)
+	assert.NoError(t, err)
+	for _, ip := range wl.Addresses {
+		assert.Equal(t, workloadID, checkFrontEndMap(t, ip, p))
+	}
+	checkBackendMap(t, p, workloadID, wl)
+	checkNotExistInFrontEndMap(t, oldIpv6, p)
+
+	// 3. remove the workload, all the frontend records are deleted
+	err = p.removeWorkloadResource([]string{wl.Uid})
+	assert.NoError(t, err)
+	for _, ip := range wl.Addresses {
+		checkNotExistInFrontEndMap(t, ip, p)
+	}
+}
+
 func checkBackendMap(t *testing.T, p *Processor, workloadID uint32, wl *workloadapi.Workload) {
 	var bv bpfcache.BackendValue
 	err := p.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: workloadID}, &bv)
 	assert.NoError(t, err)
-	assert.Equal(t, test.EqualIp(bv.Ip, wl.Addresses[0]), true)
+	addrs := bv.Addresses()
+	assert.Len(t, addrs, len(wl.Addresses))
+	for _, ip := range wl.Addresses {
+		found := false
+		for _, addr := range addrs {
+			found = found || test.EqualIp(addr, ip)
+		}
+		assert.True(t, found)
+	}
 	waypointAddr := wl.GetWaypoint().GetAddress().GetAddress()
 	if waypointAddr != nil {
 		assert.Equal(t, test.EqualIp(bv.WaypointAddr, waypointAddr), true)
@@ -126,6 +376,14 @@ func checkFrontEndMap(t *testing.T, ip []byte, p *Processor) (upstreamId uint32)
 	return
 }
 
+func checkNotExistInFrontEndMap(t *testing.T, ip []byte, p *Processor) {
+	var fk bpfcache.FrontendKey
+	var fv bpfcache.FrontendValue
+	nets.CopyIpByteFromSlice(&fk.Ip, ip)
+	err := p.bpf.FrontendLookup(&fk, &fv)
+	assert.Error(t, err)
+}
+
 func BenchmarkHandleDataWithService(b *testing.B) {
 	t := &testing.T{}
 	config := options.BpfConfig{
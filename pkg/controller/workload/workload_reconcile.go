@@ -0,0 +1,183 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"errors"
+	"sort"
+
+	bpf "kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+// reconcile removes the bpf map entries which are not backed by the workload and service caches.
+//
+// After a restart the pinned maps still hold the entries of the last run, while the caches are
+// rebuilt from the first full xDS response. The resources deleted while kmesh was down are never
+// notified as removed, so their entries have to be found by walking the maps.
+func (p *Processor) reconcile() error {
+	var errs []error
+
+	services := make(map[uint32]string)
+	for _, service := range p.ServiceCache.List() {
+		services[p.hashName.StrToNum(service.ResourceName())] = service.ResourceName()
+	}
+	workloads := make(map[uint32]string)
+	// the services each workload refers to, they may not be received yet
+	memberships := make(map[uint32]map[uint32]struct{})
+	for _, workload := range p.WorkloadCache.List() {
+		uid := p.hashName.StrToNum(workload.GetUid())
+		workloads[uid] = workload.GetUid()
+		memberships[uid] = make(map[uint32]struct{}, len(workload.GetServices()))
+		for serviceName := range workload.GetServices() {
+			memberships[uid][p.hashName.StrToNum(serviceName)] = struct{}{}
+		}
+	}
+
+	for fk, fv := range p.bpf.FrontendIterAll() {
+		_, isService := services[fv.UpstreamId]
+		_, isWorkload := workloads[fv.UpstreamId]
+		if isService || isWorkload {
+			continue
+		}
+		log.Infof("reconcile: delete stale frontend %v of upstream %d", fk.Ip, fv.UpstreamId)
+		if err := p.bpf.FrontendDelete(&fk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for sk := range p.bpf.ServiceIterAll() {
+		if _, ok := services[sk.ServiceId]; ok {
+			continue
+		}
+		log.Infof("reconcile: delete stale service %d", sk.ServiceId)
+		if err := p.bpf.ServiceDelete(&sk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for spk := range p.bpf.ServicePortIterAll() {
+		if _, ok := services[spk.ServiceId]; ok {
+			continue
+		}
+		if err := p.bpf.ServicePortDelete(&spk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for bk := range p.bpf.BackendIterAll() {
+		if _, ok := workloads[bk.BackendUid]; ok {
+			continue
+		}
+		log.Infof("reconcile: delete stale backend %d", bk.BackendUid)
+		if err := p.bpf.BackendDelete(&bk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// a backend service entry is written with the workload, before its service may arrive, so it
+	// is kept as long as the workload refers to the service
+	for _, bsk := range p.bpf.BackendServiceIterAll() {
+		if _, ok := memberships[bsk.BackendUid][bsk.ServiceId]; ok {
+			continue
+		}
+		if err := p.bpf.BackendServiceDelete(&bsk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// the endpoints of the remaining services are compacted without the stale backends
+	for _, serviceName := range services {
+		err := p.rewriteEndpoints(serviceName, func(backendUid uint32) bool {
+			_, ok := workloads[backendUid]
+			return ok
+		})
+		if err != nil {
+			log.Errorf("reconcile: rewrite endpoints of service %s failed, err:%s", serviceName, err)
+			errs = append(errs, err)
+		}
+	}
+
+	// anything outside the published endpoint set of its service is unreachable
+	svcValues := p.bpf.ServiceIterAll()
+	for ek := range p.bpf.EndpointIterAll() {
+		sv, ok := svcValues[bpf.ServiceKey{ServiceId: ek.ServiceId}]
+		if ok && ek.Version == sv.EndpointVersion && ek.Prio < bpf.PrioCount &&
+			ek.BackendIndex >= 1 && ek.BackendIndex <= sv.EndpointCount[ek.Prio] {
+			continue
+		}
+		if err := p.bpf.EndpointDelete(&ek); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+	// the ids of the stale resources are not in the maps any more
+	p.deleteStaleNames(services, workloads)
+	p.hashName.Release()
+	return nil
+}
+
+// deleteStaleNames removes the persisted names of the resources which no longer exist, the
+// services referred to by a workload are kept since their entries are written before they arrive.
+func (p *Processor) deleteStaleNames(services, workloads map[uint32]string) {
+	live := make(map[string]struct{}, len(services)+len(workloads))
+	for _, name := range services {
+		live[name] = struct{}{}
+	}
+	for _, uid := range workloads {
+		live[uid] = struct{}{}
+		for serviceName := range p.WorkloadCache.GetWorkloadByUid(uid).GetServices() {
+			live[serviceName] = struct{}{}
+		}
+	}
+	for _, name := range p.hashName.Names() {
+		if _, ok := live[name]; !ok {
+			log.Infof("reconcile: delete stale hash name %s", name)
+			p.hashName.Delete(name)
+		}
+	}
+}
+
+// unknownHashIds returns the ids in the bpf maps which can not be resolved by the persisted hash
+// names. They point to resources the names of which are lost, the entries are left to reconcile.
+func (p *Processor) unknownHashIds() []uint32 {
+	ids := make(map[uint32]struct{})
+	for _, fv := range p.bpf.FrontendIterAll() {
+		ids[fv.UpstreamId] = struct{}{}
+	}
+	for sk := range p.bpf.ServiceIterAll() {
+		ids[sk.ServiceId] = struct{}{}
+	}
+	for bk := range p.bpf.BackendIterAll() {
+		ids[bk.BackendUid] = struct{}{}
+	}
+	for ek, ev := range p.bpf.EndpointIterAll() {
+		ids[ek.ServiceId] = struct{}{}
+		ids[ev.BackendUid] = struct{}{}
+	}
+
+	var unknown []uint32
+	for id := range ids {
+		if p.hashName.NumToStr(id) == "" {
+			unknown = append(unknown, id)
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i] < unknown[j] })
+	return unknown
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+func Test_reconcile(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	// 1. the last run stored two services and two workloads
+	p := newProcessor(workloadMap)
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	staleSvc := createFakeService("stalesvc", "10.240.10.3", "10.240.10.2")
+	staleSvc.Hostname = "stalesvc.default.svc.cluster.local"
+	wl := createFakeWorkload("1.2.3.4")
+	staleWl := createFakeWorkload("1.2.3.5")
+	assert.NoError(t, p.handleService(svc))
+	assert.NoError(t, p.handleService(staleSvc))
+	assert.NoError(t, p.handleWorkload(wl))
+	assert.NoError(t, p.handleWorkload(staleWl))
+
+	svcID := p.hashName.StrToNum(svc.ResourceName())
+	staleSvcID := p.hashName.StrToNum(staleSvc.ResourceName())
+	wlID := p.hashName.StrToNum(wl.Uid)
+	staleWlID := p.hashName.StrToNum(staleWl.Uid)
+	checkServiceMap(t, p, svcID, svc, 2)
+
+	// 2. after the restart only part of them are received in the first full sync
+	p2 := newProcessor(workloadMap)
+	p2.hashName = p.hashName
+	assert.NoError(t, p2.handleService(svc))
+	assert.NoError(t, p2.handleWorkload(wl))
+	assert.NoError(t, p2.reconcile())
+
+	// 3. the entries of the removed resources are gone, the rest are kept
+	assert.Equal(t, svcID, checkFrontEndMap(t, svc.Addresses[0].Address, p2))
+	assert.Equal(t, wlID, checkFrontEndMap(t, wl.Addresses[0], p2))
+	checkNotExistInFrontEndMap(t, staleSvc.Addresses[0].Address, p2)
+	checkNotExistInFrontEndMap(t, staleWl.Addresses[0], p2)
+
+	var sv bpfcache.ServiceValue
+	assert.Error(t, p2.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: staleSvcID}, &sv))
+	assert.Len(t, p2.bpf.ServicePortIterFindKey(staleSvcID), 0)
+	assert.NotEmpty(t, p2.bpf.ServicePortIterFindKey(svcID))
+
+	var bv bpfcache.BackendValue
+	assert.Error(t, p2.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: staleWlID}, &bv))
+	assert.Len(t, p2.bpf.BackendServiceIterFindKey(staleWlID), 0)
+	assert.Len(t, p2.bpf.BackendServiceIterFindKey(wlID), 1)
+
+	checkServiceMap(t, p2, svcID, svc, 1)
+	checkEndpoint(t, p2, bpfcache.EndpointKey{ServiceId: svcID, Prio: 0, BackendIndex: 1}, wl)
+	for ek := range p2.bpf.EndpointIterAll() {
+		assert.Equal(t, svcID, ek.ServiceId)
+	}
+	assert.Len(t, p2.bpf.EndpointIterFindKey(staleWlID), 0)
+
+	// 4. the names of the removed resources are not persisted any more
+	assert.Equal(t, "", p2.hashName.NumToStr(staleSvcID))
+	assert.Equal(t, "", p2.hashName.NumToStr(staleWlID))
+	assert.Equal(t, svc.ResourceName(), p2.hashName.NumToStr(svcID))
+	assert.Equal(t, wl.Uid, p2.hashName.NumToStr(wlID))
+}
+
+func Test_reconcileServiceNotReceived(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	wl := createFakeWorkload("1.2.3.4")
+	assert.NoError(t, p.handleService(svc))
+	assert.NoError(t, p.handleWorkload(wl))
+	svcID := p.hashName.StrToNum(svc.ResourceName())
+	wlID := p.hashName.StrToNum(wl.Uid)
+
+	// after the restart the workload is received before the service it refers to
+	p2 := newProcessor(workloadMap)
+	p2.hashName = p.hashName
+	assert.NoError(t, p2.handleWorkload(wl))
+	assert.NoError(t, p2.reconcile())
+
+	// the membership of the workload is kept for the service to come
+	assert.Equal(t, []bpfcache.BackendServiceKey{{BackendUid: wlID, ServiceId: svcID}}, p2.bpf.BackendServiceIterFindKey(wlID))
+	assert.Equal(t, svc.ResourceName(), p2.hashName.NumToStr(svcID))
+
+	assert.NoError(t, p2.handleService(svc))
+	assert.Equal(t, []bpfcache.BackendServiceKey{{BackendUid: wlID, ServiceId: svcID}}, p2.bpf.BackendServiceIterFindKey(wlID))
+}
+
+func Test_unknownHashIds(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	wl := createFakeWorkload("1.2.3.4")
+	assert.NoError(t, p.handleService(svc))
+	assert.NoError(t, p.handleWorkload(wl))
+	assert.Empty(t, p.unknownHashIds())
+
+	// the name of the workload is lost, its id can not be resolved any more
+	wlID := p.hashName.StrToNum(wl.Uid)
+	p.hashName.Delete(wl.Uid)
+	assert.Equal(t, []uint32{wlID}, p.unknownHashIds())
+
+	// the id is not handed out again until the reconcile removes its entries
+	p.hashName.Reserve(p.unknownHashIds())
+	assert.NotEqual(t, wlID, p.hashName.StrToNum(wl.Uid))
+	assert.NoError(t, p.reconcile())
+	assert.Empty(t, p.unknownHashIds())
+	assert.Nil(t, p.hashName.reserved)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+	bpf "kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+const tunnelStatsPeriod = 15 * time.Second
+
+// tunnelStatsNames are the tunnels indexed by the TUNNEL_STATS_* of map_of_tunnel_stats
+var tunnelStatsNames = [...]string{"PROXY", "HBONE"}
+
+// setBackendTunnel encodes how the workload expects to receive traffic into the backend value.
+func setBackendTunnel(bv *bpf.BackendValue, workload *workloadapi.Workload) {
+	bv.TunnelProtocol = uint32(workload.GetTunnelProtocol())
+	bv.AppTunnelProtocol = uint32(workload.GetApplicationTunnel().GetProtocol())
+}
+
+// TunnelMisconfigs returns the reasons why the tunnel settings of the workload are inconsistent
+// or can not be honored by kmesh, it is empty for a valid workload.
+func TunnelMisconfigs(workload *workloadapi.Workload) []string {
+	var reasons []string
+	appTunnel := workload.GetApplicationTunnel()
+
+	if appTunnel.GetProtocol() == workloadapi.ApplicationTunnel_NONE && appTunnel.GetPort() != 0 {
+		reasons = append(reasons, "application tunnel port is set without a protocol")
+	}
+	if appTunnel.GetProtocol() == workloadapi.ApplicationTunnel_PROXY {
+		if workload.GetTunnelProtocol() != workloadapi.TunnelProtocol_HBONE {
+			reasons = append(reasons, "PROXY application tunnel requires the HBONE tunnel protocol")
+		}
+		if workload.GetWaypoint() == nil {
+			reasons = append(reasons, "PROXY application tunnel is only reachable through a waypoint")
+		}
+	}
+	if appTunnel.GetPort() > 65535 {
+		reasons = append(reasons, "application tunnel port is out of range")
+	}
+	if workload.GetNativeTunnel() && workload.GetTunnelProtocol() != workloadapi.TunnelProtocol_HBONE {
+		reasons = append(reasons, "native tunnel requires the HBONE tunnel protocol")
+	}
+
+	return reasons
+}
+
+// tunnelStats reports the connections map_of_tunnel_stats counts, kmesh sends them plain TCP to
+// backends expecting the PROXY protocol or accepting HBONE, as it speaks neither of them.
+type tunnelStats struct {
+	statsMap *ebpf.Map
+	// last holds the counts reported so far, the map counts from the time it was created
+	last [len(tunnelStatsNames)]uint64
+}
+
+func newTunnelStats(statsMap *ebpf.Map) *tunnelStats {
+	s := &tunnelStats{statsMap: statsMap}
+	for i := range tunnelStatsNames {
+		s.last[i] = s.lookup(uint32(i))
+	}
+	return s
+}
+
+// Run reports the counts periodically until ctx is done.
+func (s *tunnelStats) Run(ctx context.Context) {
+	ticker := time.NewTicker(tunnelStatsPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.report()
+		}
+	}
+}
+
+func (s *tunnelStats) report() {
+	for i, name := range tunnelStatsNames {
+		count := s.lookup(uint32(i))
+		if count > s.last[i] {
+			telemetry.RecordPlainTcpTunnelConnections(name, count-s.last[i])
+			s.last[i] = count
+		}
+	}
+}
+
+// lookup returns the count of the tunnel summed over the cpus
+func (s *tunnelStats) lookup(index uint32) uint64 {
+	var (
+		counts []uint64
+		sum    uint64
+	)
+	if err := s.statsMap.Lookup(&index, &counts); err != nil {
+		log.Errorf("lookup tunnel stats %s failed, err:%s", tunnelStatsNames[index], err)
+		return 0
+	}
+	for _, count := range counts {
+		sum += count
+	}
+	return sum
+}
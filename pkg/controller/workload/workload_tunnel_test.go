@@ -0,0 +1,131 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+func Test_handleWorkloadWithTunnel(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	wl := createFakeWorkload("1.2.3.4")
+	wl.TunnelProtocol = workloadapi.TunnelProtocol_HBONE
+	wl.ApplicationTunnel = &workloadapi.ApplicationTunnel{
+		Protocol: workloadapi.ApplicationTunnel_PROXY,
+		Port:     15088,
+	}
+	assert.NoError(t, p.handleWorkload(wl))
+
+	var bv bpfcache.BackendValue
+	assert.NoError(t, p.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: p.hashName.StrToNum(wl.Uid)}, &bv))
+	assert.Equal(t, uint32(workloadapi.TunnelProtocol_HBONE), bv.TunnelProtocol)
+	assert.Equal(t, uint32(workloadapi.ApplicationTunnel_PROXY), bv.AppTunnelProtocol)
+}
+
+func TestTunnelMisconfigs(t *testing.T) {
+	waypoint := &workloadapi.GatewayAddress{
+		Destination: &workloadapi.GatewayAddress_Address{Address: &workloadapi.NetworkAddress{Address: []byte{10, 0, 0, 1}}},
+	}
+	testCases := []struct {
+		name     string
+		workload *workloadapi.Workload
+		count    int
+	}{
+		{
+			name:     "plain TCP",
+			workload: &workloadapi.Workload{},
+		},
+		{
+			name: "PROXY behind a waypoint",
+			workload: &workloadapi.Workload{
+				TunnelProtocol:    workloadapi.TunnelProtocol_HBONE,
+				ApplicationTunnel: &workloadapi.ApplicationTunnel{Protocol: workloadapi.ApplicationTunnel_PROXY},
+				Waypoint:          waypoint,
+			},
+		},
+		{
+			name: "PROXY without HBONE and waypoint",
+			workload: &workloadapi.Workload{
+				ApplicationTunnel: &workloadapi.ApplicationTunnel{Protocol: workloadapi.ApplicationTunnel_PROXY},
+			},
+			count: 2,
+		},
+		{
+			name: "port without protocol",
+			workload: &workloadapi.Workload{
+				ApplicationTunnel: &workloadapi.ApplicationTunnel{Port: 15088},
+			},
+			count: 1,
+		},
+		{
+			name:     "native tunnel without HBONE",
+			workload: &workloadapi.Workload{NativeTunnel: true},
+			count:    1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Len(t, TunnelMisconfigs(tc.workload), tc.count)
+		})
+	}
+}
+
+func TestTunnelStats(t *testing.T) {
+	statsMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "kmesh_tunnel_stats",
+		Type:       ebpf.PerCPUArray,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: uint32(len(tunnelStatsNames)),
+	})
+	if err != nil {
+		t.Fatal("Create kmesh_tunnel_stats failed, err: ", err)
+	}
+	defer statsMap.Close()
+
+	cpus, err := ebpf.PossibleCPU()
+	assert.NoError(t, err)
+	put := func(index uint32, perCPU uint64) {
+		counts := make([]uint64, cpus)
+		for i := range counts {
+			counts[i] = perCPU
+		}
+		assert.NoError(t, statsMap.Put(&index, counts))
+	}
+
+	// the counts before kmesh starts are not reported again
+	put(0, 1)
+	stats := newTunnelStats(statsMap)
+	assert.Equal(t, uint64(cpus), stats.last[0])
+	assert.Equal(t, uint64(0), stats.last[1])
+
+	put(0, 2)
+	put(1, 3)
+	stats.report()
+	assert.Equal(t, uint64(2*cpus), stats.last[0])
+	assert.Equal(t, uint64(3*cpus), stats.last[1])
+}
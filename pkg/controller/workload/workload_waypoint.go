@@ -0,0 +1,189 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"net/netip"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/nets"
+)
+
+// waypointServiceName returns the resource name of the service a waypoint is specified by, or an
+// empty string if the waypoint is specified by address.
+func waypointServiceName(waypoint *workloadapi.GatewayAddress) string {
+	hostname := waypoint.GetHostname()
+	if hostname == nil {
+		return ""
+	}
+	return hostname.GetNamespace() + "/" + hostname.GetHostname()
+}
+
+// networkAddressKey returns the key of a VIP in the waypoint index, in the form of network/ip.
+func networkAddressKey(address *workloadapi.NetworkAddress) string {
+	ip, ok := netip.AddrFromSlice(address.GetAddress())
+	if !ok {
+		return ""
+	}
+	return address.GetNetwork() + "/" + ip.Unmap().String()
+}
+
+// waypointKey returns the key of the waypoint in the waypoint index, it is the resource name of
+// the waypoint service or the key of the waypoint address.
+func waypointKey(waypoint *workloadapi.GatewayAddress) string {
+	if name := waypointServiceName(waypoint); name != "" {
+		return name
+	}
+	if address := waypoint.GetAddress(); address != nil {
+		return networkAddressKey(address)
+	}
+	return ""
+}
+
+// resolveWaypoint returns the address and the port of the waypoint in bpf map format. A waypoint
+// specified by hostname is resolved to the VIP of the waypoint service, ok is false until the
+// service is received.
+func (p *Processor) resolveWaypoint(waypoint *workloadapi.GatewayAddress) (addr [16]byte, port uint32, ok bool) {
+	if waypoint == nil {
+		return addr, 0, false
+	}
+
+	switch waypoint.GetDestination().(type) {
+	case *workloadapi.GatewayAddress_Address:
+		nets.CopyIpByteFromSlice(&addr, waypoint.GetAddress().GetAddress())
+	case *workloadapi.GatewayAddress_Hostname:
+		name := waypointServiceName(waypoint)
+		addresses := p.ServiceCache.GetService(name).GetAddresses()
+		if len(addresses) == 0 {
+			log.Warnf("waypoint service %s is not resolved yet", name)
+			return addr, 0, false
+		}
+		nets.CopyIpByteFromSlice(&addr, addresses[0].GetAddress())
+	default:
+		return addr, 0, false
+	}
+
+	return addr, nets.ConvertPortToBigEndian(waypoint.GetHboneMtlsPort()), true
+}
+
+// waypointPending returns whether the waypoint is specified by hostname and its service is not
+// received yet.
+func (p *Processor) waypointPending(waypoint *workloadapi.GatewayAddress) bool {
+	name := waypointServiceName(waypoint)
+	return name != "" && len(p.ServiceCache.GetService(name).GetAddresses()) == 0
+}
+
+// indexWaypoint records name as a user of the waypoint, so that it can be re-programmed when the
+// waypoint service changes. A service referenced as a waypoint gets its ports redirected to the
+// waypoint port, which is updated once the first user comes or the last user leaves.
+func (p *Processor) indexWaypoint(index map[string]map[string]struct{}, name string, oldWaypoint, waypoint *workloadapi.GatewayAddress) {
+	oldKey, newKey := waypointKey(oldWaypoint), waypointKey(waypoint)
+	if oldKey == newKey {
+		return
+	}
+
+	if oldKey != "" {
+		delete(index[oldKey], name)
+		if len(index[oldKey]) == 0 {
+			delete(index, oldKey)
+			p.updateWaypointPorts(oldKey)
+		}
+	}
+
+	if newKey != "" {
+		users, ok := index[newKey]
+		if !ok {
+			users = make(map[string]struct{})
+			index[newKey] = users
+		}
+		users[name] = struct{}{}
+		if !ok {
+			p.updateWaypointPorts(newKey)
+		}
+	}
+}
+
+// isWaypoint returns whether the service is referenced as a waypoint by any workload or service,
+// either by its hostname or by one of its VIPs.
+func (p *Processor) isWaypoint(serviceName string) bool {
+	if p.isWaypointKey(serviceName) {
+		return true
+	}
+	for _, address := range p.ServiceCache.GetService(serviceName).GetAddresses() {
+		if p.isWaypointKey(networkAddressKey(address)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Processor) isWaypointKey(key string) bool {
+	return len(p.workloadsByWaypoint[key]) != 0 || len(p.servicesByWaypoint[key]) != 0
+}
+
+// updateWaypointPorts stores the ports of the services matching the waypoint key again, after the
+// key is referenced for the first time or no longer referenced.
+func (p *Processor) updateWaypointPorts(key string) {
+	var services []*workloadapi.Service
+	if service := p.ServiceCache.GetService(key); service != nil {
+		services = append(services, service)
+	} else {
+		for _, service := range p.ServiceCache.List() {
+			for _, address := range service.GetAddresses() {
+				if networkAddressKey(address) == key {
+					services = append(services, service)
+					break
+				}
+			}
+		}
+	}
+
+	for _, service := range services {
+		serviceName := service.ResourceName()
+		serviceId := p.hashName.StrToNum(serviceName)
+		if err := p.storeServicePorts(serviceId, serviceName, service.GetPorts()); err != nil {
+			log.Errorf("update ports of waypoint service %s failed, err:%s", serviceName, err)
+		}
+	}
+}
+
+// updateWaypointUsers re-programs the workloads and services using the waypoint service, it is
+// called when the addresses of the waypoint service changed or the service is removed. The
+// pending workloads are stored once the service is received, the stored ones keep their last
+// waypoint once it is removed.
+func (p *Processor) updateWaypointUsers(waypointName string) {
+	for uid := range p.workloadsByWaypoint[waypointName] {
+		workload := p.WorkloadCache.GetWorkloadByUid(uid)
+		if workload == nil {
+			continue
+		}
+		if err := p.storeBackendData(p.hashName.StrToNum(uid), workload); err != nil {
+			log.Errorf("update waypoint of workload %s failed, err:%s", uid, err)
+		}
+	}
+
+	for serviceName := range p.servicesByWaypoint[waypointName] {
+		service := p.ServiceCache.GetService(serviceName)
+		if service == nil {
+			continue
+		}
+		err := p.storeServiceData(serviceName, service.GetWaypoint(), service.GetPorts(), service.GetLoadBalancing(), false)
+		if err != nil {
+			log.Errorf("update waypoint of service %s failed, err:%s", serviceName, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+	"kmesh.net/kmesh/pkg/nets"
+	"kmesh.net/kmesh/pkg/utils/test"
+)
+
+func Test_handleWaypointWithHostname(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	waypoint := &workloadapi.GatewayAddress{
+		Destination: &workloadapi.GatewayAddress_Hostname{
+			Hostname: &workloadapi.NamespacedHostname{
+				Namespace: "default",
+				Hostname:  "waypoint.default.svc.cluster.local",
+			},
+		},
+		HboneMtlsPort: 15008,
+	}
+
+	checkWaypoint := func(vip string) {
+		var (
+			bv bpfcache.BackendValue
+			sv bpfcache.ServiceValue
+		)
+		wlID := p.hashName.StrToNum(p.WorkloadCache.List()[0].GetUid())
+		assert.NoError(t, p.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: wlID}, &bv))
+		svcID := p.hashName.StrToNum("default/testsvc.default.svc.cluster.local")
+		assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcID}, &sv))
+		if vip == "" {
+			assert.Equal(t, [16]byte{}, bv.WaypointAddr)
+			assert.Equal(t, [16]byte{}, sv.WaypointAddr)
+			return
+		}
+		assert.True(t, test.EqualIp(bv.WaypointAddr, netip.MustParseAddr(vip).AsSlice()))
+		assert.True(t, test.EqualIp(sv.WaypointAddr, netip.MustParseAddr(vip).AsSlice()))
+		assert.Equal(t, nets.ConvertPortToBigEndian(15008), bv.WaypointPort)
+		assert.Equal(t, nets.ConvertPortToBigEndian(15008), sv.WaypointPort)
+	}
+
+	// 1. the waypoint service is not received yet, the workload is pending instead of bypassing it
+	wl := createFakeWorkload("1.2.3.4")
+	wl.Waypoint = waypoint
+	svc := createFakeService("testsvc", "10.240.10.1", "10.240.10.2")
+	svc.Waypoint = waypoint
+	assert.NoError(t, p.handleWorkload(wl))
+	assert.NoError(t, p.handleService(svc))
+	var bv bpfcache.BackendValue
+	wlID := p.hashName.StrToNum(wl.GetUid())
+	assert.Error(t, p.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: wlID}, &bv))
+	assert.Contains(t, p.pendingBackends, wl.GetUid())
+
+	// 2. the waypoint is resolved to the VIP of the waypoint service
+	waypointSvc := createFakeService("waypoint", "10.240.10.5", "10.240.10.2")
+	waypointSvc.Hostname = "waypoint.default.svc.cluster.local"
+	waypointSvc.Waypoint = nil
+	assert.NoError(t, p.handleService(waypointSvc))
+	checkWaypoint("10.240.10.5")
+	assert.Empty(t, p.pendingBackends)
+
+	// 3. the VIP of the waypoint service changed
+	waypointSvc = createFakeService("waypoint", "10.240.10.6", "10.240.10.2")
+	waypointSvc.Hostname = "waypoint.default.svc.cluster.local"
+	waypointSvc.Waypoint = nil
+	assert.NoError(t, p.handleService(waypointSvc))
+	checkWaypoint("10.240.10.6")
+
+	// 4. the waypoint service is removed, the workload keeps its last waypoint
+	assert.NoError(t, p.removeServiceResource([]string{waypointSvc.ResourceName()}))
+	assert.NoError(t, p.bpf.BackendLookup(&bpfcache.BackendKey{BackendUid: wlID}, &bv))
+	assert.True(t, test.EqualIp(bv.WaypointAddr, netip.MustParseAddr("10.240.10.6").AsSlice()))
+	assert.Contains(t, p.pendingBackends, wl.GetUid())
+
+	// 5. the users are removed from the index with themselves
+	assert.NoError(t, p.removeServiceResource([]string{svc.ResourceName()}))
+	assert.NoError(t, p.removeWorkloadResource([]string{wl.Uid}))
+	assert.Empty(t, p.workloadsByWaypoint)
+	assert.Empty(t, p.servicesByWaypoint)
+	assert.Empty(t, p.pendingBackends)
+}
+
+func Test_waypointPorts(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := newProcessor(workloadMap)
+	p.waypointPort = 15020
+
+	checkTargetPorts := func(svc *workloadapi.Service, waypoint bool) {
+		var spv bpfcache.ServicePortValue
+		svcID := p.hashName.StrToNum(svc.ResourceName())
+		for _, port := range svc.GetPorts() {
+			spk := bpfcache.ServicePortKey{ServiceId: svcID, ServicePort: nets.ConvertPortToBigEndian(port.ServicePort)}
+			assert.NoError(t, p.bpf.ServicePortLookup(&spk, &spv))
+			if waypoint {
+				assert.Equal(t, nets.ConvertPortToBigEndian(15020), spv.TargetPort)
+			} else {
+				assert.Equal(t, nets.ConvertPortToBigEndian(port.TargetPort), spv.TargetPort)
+			}
+		}
+	}
+
+	// 1. a service is not taken as a waypoint because of its name
+	waypointSvc := createFakeService("waypoint-analytics", "10.240.10.5", "10.240.10.2")
+	waypointSvc.Hostname = "waypoint-analytics.default.svc.cluster.local"
+	waypointSvc.Waypoint = nil
+	assert.NoError(t, p.handleService(waypointSvc))
+	checkTargetPorts(waypointSvc, false)
+
+	// 2. a workload uses the service VIP as its waypoint
+	wl := createFakeWorkload("1.2.3.4")
+	wl.Waypoint = &workloadapi.GatewayAddress{
+		Destination: &workloadapi.GatewayAddress_Address{
+			Address: &workloadapi.NetworkAddress{
+				Address: netip.MustParseAddr("10.240.10.5").AsSlice(),
+			},
+		},
+		HboneMtlsPort: 15008,
+	}
+	assert.NoError(t, p.handleWorkload(wl))
+	checkTargetPorts(waypointSvc, true)
+
+	// 3. the ports are kept when the waypoint service is updated
+	assert.NoError(t, p.handleService(waypointSvc))
+	checkTargetPorts(waypointSvc, true)
+
+	// 4. the service is no longer a waypoint once its last user left
+	assert.NoError(t, p.removeWorkloadResource([]string{wl.Uid}))
+	checkTargetPorts(waypointSvc, false)
+}
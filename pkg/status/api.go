@@ -17,9 +17,15 @@
 package status
 
 import (
+	"encoding/json"
 	"net"
 
+	"google.golang.org/protobuf/encoding/protojson"
+
 	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/auth"
+	"kmesh.net/kmesh/pkg/controller/workload"
 )
 
 type Workload struct {
@@ -41,6 +47,8 @@ type Workload struct {
 	Network           string            `json:"network,omitempty"`
 	Status            string            `json:"status"`
 	ApplicationTunnel ApplicationTunnel `json:"applicationTunnel,omitempty"`
+	// TunnelErrors lists the tunnel settings kmesh can not honor
+	TunnelErrors []string `json:"tunnelErrors,omitempty"`
 }
 
 type Locality struct {
@@ -71,6 +79,20 @@ type Service struct {
 	Ports        []*workloadapi.Port `json:"ports"`
 	LoadBalancer *LoadBalancer       `json:"loadBalancer"`
 	Waypoint     *Waypoint           `json:"waypoint"`
+	// SubjectAltNames are the identities the backends of the service are expected to have
+	SubjectAltNames []string `json:"subjectAltNames,omitempty"`
+}
+
+type AuthorizationPolicy struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Scope     string            `json:"scope"`
+	Action    string            `json:"action"`
+	Rules     []json.RawMessage `json:"rules"`
+	// AttachedTo is "global" for a global policy, namespace/<ns> for a namespace policy and the uids
+	// of the workloads referring to a workload selector policy
+	AttachedTo []string `json:"attachedTo"`
+	DryRun     bool     `json:"dryRun,omitempty"`
 }
 
 type NetworkAddress struct {
@@ -116,6 +138,7 @@ func ConvertWorkload(w *workloadapi.Workload) *Workload {
 	if w.ApplicationTunnel != nil {
 		out.ApplicationTunnel = ApplicationTunnel{Protocol: w.ApplicationTunnel.Protocol.String(), Port: w.ApplicationTunnel.Port}
 	}
+	out.TunnelErrors = workload.TunnelMisconfigs(w)
 	return out
 }
 
@@ -132,12 +155,13 @@ func ConvertService(s *workloadapi.Service) *Service {
 	}
 
 	out := &Service{
-		Name:      s.Name,
-		Namespace: s.Namespace,
-		Hostname:  s.Hostname,
-		Addresses: vips,
-		Ports:     s.Ports,
-		Waypoint:  &Waypoint{Destination: waypoint},
+		Name:            s.Name,
+		Namespace:       s.Namespace,
+		Hostname:        s.Hostname,
+		Addresses:       vips,
+		Ports:           s.Ports,
+		Waypoint:        &Waypoint{Destination: waypoint},
+		SubjectAltNames: s.SubjectAltNames,
 	}
 
 	if s.LoadBalancing != nil {
@@ -150,3 +174,34 @@ func ConvertService(s *workloadapi.Service) *Service {
 
 	return out
 }
+
+func ConvertAuthorizationPolicy(dump auth.PolicyDump) *AuthorizationPolicy {
+	p := dump.Policy
+	out := &AuthorizationPolicy{
+		Name:       p.GetName(),
+		Namespace:  p.GetNamespace(),
+		Scope:      p.GetScope().String(),
+		Action:     p.GetAction().String(),
+		Rules:      make([]json.RawMessage, 0, len(p.GetRules())),
+		AttachedTo: []string{},
+		DryRun:     dump.DryRun,
+	}
+	for _, rule := range p.GetRules() {
+		data, err := protojson.Marshal(rule)
+		if err != nil {
+			log.Errorf("Failed to marshal rule of authorization policy %s: %v", p.ResourceName(), err)
+			continue
+		}
+		out.Rules = append(out.Rules, data)
+	}
+
+	switch p.GetScope() {
+	case security.Scope_GLOBAL:
+		out.AttachedTo = append(out.AttachedTo, "global")
+	case security.Scope_NAMESPACE:
+		out.AttachedTo = append(out.AttachedTo, "namespace/"+p.GetNamespace())
+	default:
+		out.AttachedTo = append(out.AttachedTo, dump.Workloads...)
+	}
+	return out
+}
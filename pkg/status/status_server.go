@@ -22,19 +22,23 @@ import (
 	"io"
 	"net/http"
 	"net/http/pprof"
+	"net/netip"
 	"strconv"
 	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/encoding/protojson"
+	"istio.io/istio/pkg/util/sets"
 
 	adminv2 "kmesh.net/kmesh/api/v2/admin"
 	"kmesh.net/kmesh/api/v2/workloadapi/security"
 	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/auth"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller"
 	"kmesh.net/kmesh/pkg/controller/ads"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/logger"
 )
 
@@ -51,6 +55,9 @@ const (
 	patternConfigDumpWorkload = configDumpPrefix + "/workload"
 	patternReadyProbe         = "/debug/ready"
 	patternLoggers            = "/debug/loggers"
+	patternAuthzAudit         = "/debug/authz/audit"
+	patternAuthzCheck         = "/debug/authz/check"
+	patternAccessLog          = "/debug/accesslog"
 
 	bpfLoggerName = "bpf"
 
@@ -73,6 +80,10 @@ func GetLoggerURL() string {
 	return "http://" + adminAddr + patternLoggers
 }
 
+func GetAuthzCheckURL() string {
+	return "http://" + adminAddr + patternAuthzCheck
+}
+
 func NewServer(c *controller.XdsClient, configs *options.BootstrapConfigs, bpfLogLevel *ebpf.Map) *Server {
 	s := &Server{
 		config:         configs,
@@ -93,6 +104,9 @@ func NewServer(c *controller.XdsClient, configs *options.BootstrapConfigs, bpfLo
 	s.mux.HandleFunc(patternConfigDumpAds, s.configDumpAds)
 	s.mux.HandleFunc(patternConfigDumpWorkload, s.configDumpWorkload)
 	s.mux.HandleFunc(patternLoggers, s.loggersHandler)
+	s.mux.HandleFunc(patternAuthzAudit, s.authzAudit)
+	s.mux.HandleFunc(patternAuthzCheck, s.authzCheck)
+	s.mux.HandleFunc(patternAccessLog, s.accessLogHandler)
 
 	// TODO: add dump certificate, authorizationPolicies and services
 	s.mux.HandleFunc(patternReadyProbe, s.readyProbe)
@@ -118,9 +132,15 @@ func (s *Server) httpHelp(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "\t%s: %s\n", patternConfigDumpAds,
 		"dump xDS[Listener, Route, Cluster] configurations")
 	fmt.Fprintf(w, "\t%s: %s\n", patternConfigDumpWorkload,
-		"dump workload configurations")
+		"dump workload configurations, filtered by namespace and workload")
 	fmt.Fprintf(w, "\t%s: %s\n", patternLoggers,
 		"get or set logger level")
+	fmt.Fprintf(w, "\t%s: %s\n", patternAuthzAudit,
+		"query the recent authorization decisions, filtered by decision, src, dst, workload and limit")
+	fmt.Fprintf(w, "\t%s: %s\n", patternAuthzCheck,
+		"explain the authorization decision on a connection from src=<ip> to dst=<ip>:<port>")
+	fmt.Fprintf(w, "\t%s: %s\n", patternAccessLog,
+		"get or set whether the access log is enabled and its format")
 }
 
 func (s *Server) httpOptions(w http.ResponseWriter, r *http.Request) {
@@ -278,10 +298,12 @@ func (s *Server) configDumpAds(w http.ResponseWriter, r *http.Request) {
 type WorkloadDump struct {
 	Workloads []*Workload
 	Services  []*Service
-	// TODO: add authorization
-	Policies []*security.Authorization
+	Policies  []*AuthorizationPolicy
 }
 
+// configDumpWorkload dumps the workloads, services and authorization policies, the query
+// parameters namespace and workload select those in a namespace or applied to a workload uid.
+// The global policies apply to every namespace, so they are kept by the namespace filter.
 func (s *Server) configDumpWorkload(w http.ResponseWriter, r *http.Request) {
 	client := s.xdsClient
 	if client == nil || client.WorkloadController == nil {
@@ -290,21 +312,183 @@ func (s *Server) configDumpWorkload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+	namespace, workloadUid := query.Get("namespace"), query.Get("workload")
 	workloads := client.WorkloadController.Processor.WorkloadCache.List()
 	services := client.WorkloadController.Processor.ServiceCache.List()
+	policies := client.WorkloadController.Rbac.DumpPolicies()
 	workloadDump := WorkloadDump{
 		Workloads: make([]*Workload, 0, len(workloads)),
 		Services:  make([]*Service, 0, len(services)),
+		Policies:  make([]*AuthorizationPolicy, 0, len(policies)),
+	}
+
+	// the services and policies of the selected workload, nil selects all
+	var serviceNames, policyNames sets.Set[string]
+	if workloadUid != "" {
+		serviceNames, policyNames = sets.New[string](), sets.New[string]()
+		if wl := client.WorkloadController.Processor.WorkloadCache.GetWorkloadByUid(workloadUid); wl != nil {
+			for name := range wl.GetServices() {
+				serviceNames.Insert(name)
+			}
+			policyNames.InsertAll(client.WorkloadController.Rbac.PolicyNamesOf(wl)...)
+		}
+	}
+
+	for _, wl := range workloads {
+		if (namespace != "" && wl.GetNamespace() != namespace) || (workloadUid != "" && wl.GetUid() != workloadUid) {
+			continue
+		}
+		workloadDump.Workloads = append(workloadDump.Workloads, ConvertWorkload(wl))
 	}
-	for _, w := range workloads {
-		workloadDump.Workloads = append(workloadDump.Workloads, ConvertWorkload(w))
+	for _, svc := range services {
+		if (namespace != "" && svc.GetNamespace() != namespace) || (serviceNames != nil && !serviceNames.Contains(svc.ResourceName())) {
+			continue
+		}
+		workloadDump.Services = append(workloadDump.Services, ConvertService(svc))
 	}
-	for _, s := range services {
-		workloadDump.Services = append(workloadDump.Services, ConvertService(s))
+	for _, policy := range policies {
+		if (namespace != "" && policy.Policy.GetNamespace() != namespace &&
+			policy.Policy.GetScope() != security.Scope_GLOBAL) ||
+			(policyNames != nil && !policyNames.Contains(policy.Policy.ResourceName())) {
+			continue
+		}
+		workloadDump.Policies = append(workloadDump.Policies, ConvertAuthorizationPolicy(policy))
 	}
 	printWorkloadDump(w, workloadDump)
 }
 
+func (s *Server) authzAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	client := s.xdsClient
+	if client == nil || client.WorkloadController == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "\t%s\n", "invalid ClientMode")
+		return
+	}
+	auditLog := client.WorkloadController.Rbac.AuditLog()
+	if auditLog == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "\t%s\n", "authorization audit log is not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := auth.AuditFilter{
+		Decision:    query.Get("decision"),
+		SrcIp:       query.Get("src"),
+		DstIp:       query.Get("dst"),
+		DstWorkload: query.Get("workload"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "\tinvalid limit %q\n", limit)
+			return
+		}
+	}
+
+	data, err := json.MarshalIndent(auditLog.Query(filter), "", "    ")
+	if err != nil {
+		log.Errorf("Failed to marshal audit records: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func (s *Server) authzCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	client := s.xdsClient
+	if client == nil || client.WorkloadController == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "\t%s\n", "invalid ClientMode")
+		return
+	}
+
+	query := r.URL.Query()
+	src, err := netip.ParseAddr(query.Get("src"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "\tinvalid src %q, should be an ip\n", query.Get("src"))
+		return
+	}
+	dst, err := netip.ParseAddrPort(query.Get("dst"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "\tinvalid dst %q, should be ip:port\n", query.Get("dst"))
+		return
+	}
+
+	data, err := json.MarshalIndent(client.WorkloadController.Rbac.Check(src, dst), "", "    ")
+	if err != nil {
+		log.Errorf("Failed to marshal authorization check result: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// AccessLogInfo is the state of the access log, Format is one of [text, json]
+type AccessLogInfo struct {
+	Enabled bool   `json:"enabled"`
+	Format  string `json:"format"`
+}
+
+func (s *Server) accessLogHandler(w http.ResponseWriter, r *http.Request) {
+	client := s.xdsClient
+	if client == nil || client.WorkloadController == nil || client.WorkloadController.AccessLogger == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "\t%s\n", "invalid ClientMode")
+		return
+	}
+	accessLogger := client.WorkloadController.AccessLogger
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		defer r.Body.Close()
+		enabled, format := accessLogger.AccessLog()
+		info := AccessLogInfo{Enabled: enabled, Format: format.String()}
+		// the fields missing in the body are left as they are
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "\t%s: %v\n", "Invalid request body format", err)
+			return
+		}
+		format, err := telemetry.ParseAccessLogFormat(info.Format)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "\t%v\n", err)
+			return
+		}
+		accessLogger.SetAccessLog(info.Enabled, format)
+		log.Infof("access log enabled: %v, format: %s", info.Enabled, format)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	enabled, format := accessLogger.AccessLog()
+	data, err := json.MarshalIndent(&AccessLogInfo{Enabled: enabled, Format: format.String()}, "", "    ")
+	if err != nil {
+		log.Errorf("Failed to marshal access log info: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
 func (s *Server) readyProbe(w http.ResponseWriter, r *http.Request) {
 	// TODO: Add some components check
 	w.WriteHeader(http.StatusOK)
@@ -31,9 +31,12 @@ import (
 	"istio.io/istio/pilot/test/util"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
 	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/auth"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/controller/workload"
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
 	"kmesh.net/kmesh/pkg/logger"
@@ -295,3 +298,171 @@ func TestServer_configDumpWorkload(t *testing.T) {
 
 	util.CompareContent(t, w.Body.Bytes(), "./testdata/workload_configdump.json")
 }
+
+func TestServer_configDumpWorkloadPolicies(t *testing.T) {
+	fakeWorkloadCache := cache.NewWorkloadCache()
+	fakeServiceCache := cache.NewServiceCache()
+	fakeWorkloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:                   "cluster0//Pod/ns/name",
+		Namespace:             "ns",
+		Name:                  "name",
+		Services:              map[string]*workloadapi.PortList{"ns/svc.ns.svc.cluster.local": {}},
+		AuthorizationPolicies: []string{"ns/selector"},
+	})
+	fakeWorkloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/other/name",
+		Namespace: "other",
+		Name:      "name",
+	})
+	fakeServiceCache.AddOrUpdateService(&workloadapi.Service{Name: "svc", Namespace: "ns", Hostname: "svc.ns.svc.cluster.local"})
+	fakeServiceCache.AddOrUpdateService(&workloadapi.Service{Name: "svc2", Namespace: "ns", Hostname: "svc2.ns.svc.cluster.local"})
+
+	rbac := auth.NewRbac(fakeWorkloadCache, fakeServiceCache)
+	for _, policy := range []*security.Authorization{
+		{Name: "global", Namespace: "istio-system", Scope: security.Scope_GLOBAL, Action: security.Action_DENY},
+		{Name: "namespace", Namespace: "ns", Scope: security.Scope_NAMESPACE, Action: security.Action_ALLOW},
+		{Name: "selector", Namespace: "ns", Scope: security.Scope_WORKLOAD_SELECTOR, Action: security.Action_ALLOW,
+			Rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{DestinationPorts: []uint32{8080}}}}}}}},
+		{Name: "namespace", Namespace: "other", Scope: security.Scope_NAMESPACE, Action: security.Action_DENY},
+	} {
+		assert.NoError(t, rbac.UpdatePolicy(policy))
+	}
+	rbac.SetDryRunPolicies([]string{"other/*"})
+	server := &Server{
+		xdsClient: &controller.XdsClient{
+			WorkloadController: &workload.Controller{
+				Processor: &workload.Processor{
+					WorkloadCache: fakeWorkloadCache,
+					ServiceCache:  fakeServiceCache,
+				},
+				Rbac: rbac,
+			},
+		},
+	}
+
+	dump := func(query string) WorkloadDump {
+		w := httptest.NewRecorder()
+		server.configDumpWorkload(w, httptest.NewRequest(http.MethodGet, patternConfigDumpWorkload+query, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var out WorkloadDump
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+		return out
+	}
+	policyNames := func(wd WorkloadDump) []string {
+		names := []string{}
+		for _, policy := range wd.Policies {
+			names = append(names, policy.Namespace+"/"+policy.Name)
+		}
+		return names
+	}
+
+	all := dump("")
+	assert.Len(t, all.Workloads, 2)
+	assert.Len(t, all.Services, 2)
+	assert.Equal(t, []string{"istio-system/global", "ns/namespace", "ns/selector", "other/namespace"}, policyNames(all))
+	assert.Equal(t, []string{"global"}, all.Policies[0].AttachedTo)
+	assert.Equal(t, []string{"namespace/ns"}, all.Policies[1].AttachedTo)
+	assert.Equal(t, []string{"cluster0//Pod/ns/name"}, all.Policies[2].AttachedTo)
+	assert.Equal(t, security.Scope_WORKLOAD_SELECTOR.String(), all.Policies[2].Scope)
+	assert.Len(t, all.Policies[2].Rules, 1)
+	assert.False(t, all.Policies[2].DryRun)
+	assert.True(t, all.Policies[3].DryRun)
+
+	byNamespace := dump("?namespace=other")
+	assert.Len(t, byNamespace.Workloads, 1)
+	assert.Len(t, byNamespace.Services, 0)
+	// the global policies apply to every namespace
+	assert.Equal(t, []string{"istio-system/global", "other/namespace"}, policyNames(byNamespace))
+
+	// the policies applied to the workload, including the global ones
+	byWorkload := dump("?workload=cluster0//Pod/ns/name")
+	assert.Len(t, byWorkload.Workloads, 1)
+	assert.Len(t, byWorkload.Services, 1)
+	assert.Equal(t, "svc", byWorkload.Services[0].Name)
+	assert.Equal(t, []string{"istio-system/global", "ns/namespace", "ns/selector"}, policyNames(byWorkload))
+
+	unknown := dump("?workload=unknown")
+	assert.Empty(t, unknown.Workloads)
+	assert.Empty(t, unknown.Services)
+	assert.Empty(t, unknown.Policies)
+}
+
+func TestServer_authzCheck(t *testing.T) {
+	fakeWorkloadCache := cache.NewWorkloadCache()
+	fakeWorkloadCache.AddWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns/name",
+		Namespace: "ns",
+		Addresses: [][]byte{netip.AddrFrom4([4]byte{1, 2, 3, 4}).AsSlice()},
+	})
+	rbac := auth.NewRbac(fakeWorkloadCache, nil)
+	rbac.SetAddressSynced()
+	rbac.SetPolicySynced()
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "deny-8080",
+		Namespace: "ns",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{{Clauses: []*security.Clause{{Matches: []*security.Match{{
+			DestinationPorts: []uint32{8080},
+		}}}}}},
+	}))
+	server := &Server{
+		xdsClient: &controller.XdsClient{
+			WorkloadController: &workload.Controller{Rbac: rbac},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, patternAuthzCheck+"?src=10.0.0.1&dst=1.2.3.4:8080", nil)
+	w := httptest.NewRecorder()
+	server.authzCheck(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result auth.CheckResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, auth.AuditDecisionDeny, result.Decision)
+	assert.Equal(t, "cluster0//Pod/ns/name", result.DstWorkload)
+	assert.Len(t, result.Policies, 1)
+	assert.True(t, result.Policies[0].Matched)
+
+	for _, query := range []string{"?src=10.0.0.1", "?src=10.0.0.1&dst=1.2.3.4", "?src=foo&dst=1.2.3.4:80"} {
+		w = httptest.NewRecorder()
+		server.authzCheck(w, httptest.NewRequest(http.MethodGet, patternAuthzCheck+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestServer_accessLogHandler(t *testing.T) {
+	accessLogger := telemetry.NewAccessLogger(cache.NewWorkloadCache())
+	server := &Server{
+		xdsClient: &controller.XdsClient{
+			WorkloadController: &workload.Controller{AccessLogger: accessLogger},
+		},
+	}
+	handle := func(method, body string) (int, AccessLogInfo) {
+		w := httptest.NewRecorder()
+		server.accessLogHandler(w, httptest.NewRequest(method, patternAccessLog, bytes.NewBufferString(body)))
+		var info AccessLogInfo
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		}
+		return w.Code, info
+	}
+
+	code, info := handle(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, AccessLogInfo{Enabled: false, Format: "text"}, info)
+
+	code, info = handle(http.MethodPost, `{"enabled": true, "format": "json"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, AccessLogInfo{Enabled: true, Format: "json"}, info)
+
+	// the format is kept if it is not in the body
+	code, info = handle(http.MethodPost, `{"enabled": false}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, AccessLogInfo{Enabled: false, Format: "json"}, info)
+
+	code, _ = handle(http.MethodPost, `{"format": "xml"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = handle(http.MethodDelete, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -74,6 +74,13 @@ func AtomicWrite(path string, data []byte, mode os.FileMode) error {
 		return err
 	}
 
+	// the content is on disk before the rename makes it visible
+	if err = tempfile.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync tempfile %v: %v", tempfile.Name(), err)
+		log.Error(err)
+		return err
+	}
+
 	if err = tempfile.Close(); err != nil {
 		err = fmt.Errorf("failed to close tempfile %v: %v", tempfile.Name(), err)
 		log.Error(err)